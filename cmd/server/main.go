@@ -11,6 +11,7 @@ import (
 	"github.com/rusik69/serverscheduler/internal/config"
 	"github.com/rusik69/serverscheduler/internal/database"
 	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/middleware"
 	"github.com/rusik69/serverscheduler/internal/server"
 	"github.com/rusik69/serverscheduler/internal/services"
 )
@@ -27,13 +28,78 @@ func main() {
 	}
 	defer db.Close()
 
+	switch cfg.SessionBackend {
+	case "sqlite":
+		middleware.SetSessionBackend(middleware.NewSQLiteSessionBackend(db))
+	case "memory":
+	default:
+		slog.Error("unknown session backend", "backend", cfg.SessionBackend)
+		os.Exit(1)
+	}
+
+	keys, err := services.LoadKeyring(cfg.MasterKey, cfg.MasterKeyFile)
+	if err != nil {
+		slog.Error("failed to load master key", "error", err)
+		os.Exit(1)
+	}
 	userSvc := services.NewUserService(db)
-	serverSvc := services.NewServerService(db)
-	resSvc := services.NewReservationService(db)
-	sshSvc := services.NewSSHService()
+	serverSvc := services.NewServerService(db, keys)
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rotate-master-key":
+			// Re-encrypts every server key with the first configured master key;
+			// the old key must still be listed after it
+			n, err := serverSvc.EncryptPrivateKeys(context.Background(), true)
+			if err != nil {
+				slog.Error("master key rotation failed", "error", err)
+				os.Exit(1)
+			}
+			// Loading the CA key seals it again with the first master key
+			if _, err := services.NewCertificateAuthority(cfg.SSHCAKeyPath, cfg.SSHCertTTL, keys, nil, nil, nil); err != nil {
+				slog.Error("master key rotation of the SSH CA key failed", "path", cfg.SSHCAKeyPath, "error", err)
+				os.Exit(1)
+			}
+			slog.Info("master key rotation finished", "rows", n, "key_id", keys.ActiveKeyID())
+			return
+		default:
+			slog.Error("unknown command", "command", os.Args[1])
+			os.Exit(2)
+		}
+	}
+	if keys == nil {
+		slog.Warn("MASTER_KEY is not set; server private keys are stored in plaintext")
+	} else if _, err := serverSvc.EncryptPrivateKeys(context.Background(), false); err != nil {
+		slog.Error("encrypting server private keys failed", "error", err)
+		os.Exit(1)
+	}
+
+	if n, refused, err := userSvc.MigrateSSHKeys(context.Background()); err != nil {
+		slog.Error("moving SSH keys to user_ssh_keys failed", "error", err)
+		os.Exit(1)
+	} else {
+		if n > 0 {
+			slog.Info("SSH keys moved to user_ssh_keys", "keys", n)
+		}
+		if len(refused) > 0 {
+			slog.Warn("users without SSH access until they register a new key on the Profile page; their old key is kept in users.ssh_public_key", "users", refused)
+		}
+	}
+
 	slackSvc := services.NewSlackService(cfg.SlackWebhookURL)
+	resSvc := services.NewReservationService(db, slackSvc)
+	sshSvc := services.NewSSHService(db)
+	tokenSvc := services.NewAPITokenService(db)
+	waitlistSvc := services.NewWaitlistService(db, resSvc, slackSvc)
+	policySvc := services.NewPolicyService(db)
+	driftSvc := services.NewDriftService(db)
+	ca, err := services.NewCertificateAuthority(cfg.SSHCAKeyPath, cfg.SSHCertTTL, keys, resSvc, serverSvc, userSvc)
+	if err != nil {
+		slog.Error("failed to load SSH CA key", "path", cfg.SSHCAKeyPath, "error", err)
+		os.Exit(1)
+	}
 
-	srv := server.NewServer(cfg, userSvc, serverSvc, resSvc, sshSvc, slackSvc)
+	srv := server.NewServer(cfg, userSvc, serverSvc, resSvc, sshSvc, slackSvc, tokenSvc, waitlistSvc, policySvc, driftSvc, ca)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -2,6 +2,10 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Config holds application configuration
@@ -12,6 +16,29 @@ type Config struct {
 	AdminPassword   string
 	SlackWebhookURL string
 	LogLevel        string
+	SessionBackend  string
+	// SchedulerSweepInterval is how often the scheduler re-checks all reservations
+	// on top of waking at each start and end time
+	SchedulerSweepInterval time.Duration
+	// ReconcileInterval is how often authorized_keys on every server is checked
+	// against the reservations; zero disables the check
+	ReconcileInterval time.Duration
+	// SSHCAKeyPath is the private key of the SSH certificate authority used for
+	// servers in certificate access mode; it is generated on first start
+	SSHCAKeyPath string
+	// SSHCertTTL caps how long an SSH certificate is valid, so access through
+	// one ends soon after a reservation is released, cancelled or shortened
+	SSHCertTTL time.Duration
+	// MasterKey and MasterKeyFile hold the base64 master keys that encrypt server
+	// private keys at rest; the first key listed encrypts, the rest only decrypt
+	MasterKey     string
+	MasterKeyFile string
+	// ExpiryReminders are how long before a reservation ends its user is
+	// reminded, longest first; empty disables reminders
+	ExpiryReminders []time.Duration
+	// BaseURL is the address the web UI is reached at, used for links in
+	// notifications
+	BaseURL string
 }
 
 // LoadConfig creates and returns application configuration from environment variables
@@ -43,12 +70,67 @@ func LoadConfig() Config {
 		logLevel = "info"
 	}
 
+	sessionBackend := os.Getenv("SESSION_BACKEND")
+	if sessionBackend == "" {
+		sessionBackend = "sqlite"
+	}
+
+	sweepInterval, err := time.ParseDuration(os.Getenv("SCHEDULER_SWEEP_INTERVAL"))
+	if err != nil || sweepInterval <= 0 {
+		sweepInterval = time.Minute
+	}
+
+	reconcileInterval := 15 * time.Minute
+	if v := os.Getenv("RECONCILE_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			reconcileInterval = d
+		}
+	}
+
+	caKeyPath := os.Getenv("SSH_CA_KEY_PATH")
+	if caKeyPath == "" {
+		// Next to the database so it lives on the same volume
+		caKeyPath = filepath.Join(filepath.Dir(dbPath), "ssh_ca_key")
+	}
+
+	certTTL, err := time.ParseDuration(os.Getenv("SSH_CERT_TTL"))
+	if err != nil || certTTL <= 0 {
+		certTTL = time.Hour
+	}
+
+	// Unset means the defaults, set but empty turns reminders off
+	reminders := "30m,5m"
+	if v, ok := os.LookupEnv("EXPIRY_REMINDERS"); ok {
+		reminders = v
+	}
+	var expiryReminders []time.Duration
+	for _, v := range strings.Split(reminders, ",") {
+		if d, err := time.ParseDuration(strings.TrimSpace(v)); err == nil && d > 0 {
+			expiryReminders = append(expiryReminders, d)
+		}
+	}
+	sort.Slice(expiryReminders, func(i, j int) bool { return expiryReminders[i] > expiryReminders[j] })
+
+	baseURL := strings.TrimRight(os.Getenv("BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+
 	return Config{
-		Port:            port,
-		DBPath:          dbPath,
-		AdminUsername:   adminUsername,
-		AdminPassword:   adminPassword,
-		SlackWebhookURL: slackWebhookURL,
-		LogLevel:        logLevel,
+		Port:                   port,
+		DBPath:                 dbPath,
+		AdminUsername:          adminUsername,
+		AdminPassword:          adminPassword,
+		SlackWebhookURL:        slackWebhookURL,
+		LogLevel:               logLevel,
+		SessionBackend:         sessionBackend,
+		SchedulerSweepInterval: sweepInterval,
+		ReconcileInterval:      reconcileInterval,
+		SSHCAKeyPath:           caKeyPath,
+		SSHCertTTL:             certTTL,
+		MasterKey:              os.Getenv("MASTER_KEY"),
+		MasterKeyFile:          os.Getenv("MASTER_KEY_FILE"),
+		ExpiryReminders:        expiryReminders,
+		BaseURL:                baseURL,
 	}
 }
@@ -2,14 +2,22 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log/slog"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// InitDB creates the database and runs migrations
+// InitDB creates the database and runs migrations. Transactions are opened with
+// BEGIN IMMEDIATE so that check-then-insert sequences such as booking a server
+// hold the write lock from the start and cannot interleave.
 func InitDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite3", dbPath+sep+"_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
@@ -60,6 +68,116 @@ func migrate(db *sql.DB) error {
 		`CREATE INDEX IF NOT EXISTS idx_reservations_user ON reservations(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_reservations_server ON reservations(server_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_reservations_status ON reservations(status)`,
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			prefix TEXT NOT NULL,
+			expires_at DATETIME,
+			last_used_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id)`,
+		`CREATE TABLE IF NOT EXISTS user_ssh_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			key_type TEXT NOT NULL,
+			last_used_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			UNIQUE (user_id, fingerprint)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_ssh_keys_fingerprint ON user_ssh_keys(fingerprint)`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL,
+			client_ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			last_seen_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_username ON sessions(username)`,
+		`CREATE TABLE IF NOT EXISTS reservation_series (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			server_id INTEGER NOT NULL,
+			start_time DATETIME NOT NULL,
+			end_time DATETIME NOT NULL,
+			rule TEXT NOT NULL,
+			exceptions TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'active',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (server_id) REFERENCES servers(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS waitlist (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			server_id INTEGER NOT NULL,
+			start_time DATETIME NOT NULL,
+			end_time DATETIME NOT NULL,
+			status TEXT NOT NULL DEFAULT 'waiting',
+			reservation_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (server_id) REFERENCES servers(id),
+			FOREIGN KEY (reservation_id) REFERENCES reservations(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_waitlist_server_status ON waitlist(server_id, status)`,
+		`CREATE TABLE IF NOT EXISTS booking_policies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			server_id INTEGER,
+			max_duration_minutes INTEGER NOT NULL DEFAULT 0,
+			max_concurrent INTEGER NOT NULL DEFAULT 0,
+			max_hours_per_week INTEGER NOT NULL DEFAULT 0,
+			max_advance_days INTEGER NOT NULL DEFAULT 0,
+			min_gap_minutes INTEGER NOT NULL DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (server_id) REFERENCES servers(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS key_removals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			server_id INTEGER NOT NULL,
+			public_key TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			next_retry_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (server_id) REFERENCES servers(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS drift_reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			server_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			user_id INTEGER,
+			username TEXT NOT NULL DEFAULT '',
+			detail TEXT NOT NULL DEFAULT '',
+			repaired INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (server_id) REFERENCES servers(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_drift_reports_created ON drift_reports(created_at)`,
+		`CREATE TABLE IF NOT EXISTS expiry_reminders (
+			reservation_id INTEGER NOT NULL,
+			lead_seconds INTEGER NOT NULL,
+			end_time DATETIME NOT NULL,
+			sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (reservation_id, lead_seconds, end_time),
+			FOREIGN KEY (reservation_id) REFERENCES reservations(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS known_hosts (
+			address TEXT PRIMARY KEY,
+			host_key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
 	}
 
 	for _, m := range migrations {
@@ -68,5 +186,110 @@ func migrate(db *sql.DB) error {
 		}
 	}
 
+	// Columns added after the initial schema. SQLite has no ADD COLUMN IF NOT EXISTS.
+	columns := []struct{ table, column, definition string }{
+		{"reservations", "series_id", "INTEGER REFERENCES reservation_series(id)"},
+		{"reservations", "actual_end_time", "DATETIME"},
+		{"reservations", "decided_by", "TEXT NOT NULL DEFAULT ''"},
+		{"reservations", "decision_reason", "TEXT NOT NULL DEFAULT ''"},
+		{"reservations", "activation_attempts", "INTEGER NOT NULL DEFAULT 0"},
+		{"reservations", "last_error", "TEXT NOT NULL DEFAULT ''"},
+		{"reservations", "next_retry_at", "DATETIME"},
+		{"reservations", "certificate", "TEXT NOT NULL DEFAULT ''"},
+		{"reservations", "installed_key", "TEXT NOT NULL DEFAULT ''"},
+		{"reservations", "ssh_key_ids", "TEXT NOT NULL DEFAULT ''"},
+		{"reservations", "sessions_killed", "INTEGER NOT NULL DEFAULT 0"},
+		{"key_removals", "marker", "TEXT NOT NULL DEFAULT ''"},
+		{"servers", "requires_approval", "INTEGER NOT NULL DEFAULT 0"},
+		{"servers", "owner_id", "INTEGER REFERENCES users(id)"},
+		{"servers", "access_mode", "TEXT NOT NULL DEFAULT 'authorized_keys'"},
+		{"servers", "ssh_public_key", "TEXT NOT NULL DEFAULT ''"},
+		{"servers", "decommissioned_at", "DATETIME"},
+		{"servers", "session_policy", "TEXT NOT NULL DEFAULT 'terminate'"},
+	}
+	for _, col := range columns {
+		if err := addColumnIfMissing(db, col.table, col.column, col.definition); err != nil {
+			return err
+		}
+	}
+
+	// Policies are saved with an upsert on one policy per scope, so startup
+	// stops rather than guess which copy an admin meant to keep
+	dups, err := duplicates(db, `SELECT CASE WHEN server_id IS NULL THEN 'global' ELSE 'server ' || server_id END, GROUP_CONCAT(id, ', ')
+		FROM booking_policies GROUP BY IFNULL(server_id, 0) HAVING COUNT(*) > 1`)
+	if err != nil {
+		return err
+	}
+	if len(dups) > 0 {
+		return fmt.Errorf("booking_policies has more than one policy per scope (%s); delete all but one policy of each scope and restart", strings.Join(dups, "; "))
+	}
+
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_reservations_series ON reservations(series_id)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_booking_policies_scope ON booking_policies(IFNULL(server_id, 0))`,
+	}
+
+	// A key belongs to one user. New keys are checked when they are added, but
+	// older versions let several users register the same key; those copies are
+	// reported for the users to sort out, and the unique index follows once
+	// they have.
+	dups, err = duplicates(db, `SELECT k.fingerprint, GROUP_CONCAT(k.id || ' (' || COALESCE(u.username, 'user ' || k.user_id) || ')', ', ')
+		FROM user_ssh_keys k LEFT JOIN users u ON u.id = k.user_id GROUP BY k.fingerprint HAVING COUNT(*) > 1`)
+	if err != nil {
+		return err
+	}
+	if len(dups) > 0 {
+		slog.Warn("SSH keys registered by more than one user; each copy still grants its user access, and keys are not unique until all but one of them remove it on the Profile page", "keys", dups)
+	} else {
+		indexes = append(indexes, `CREATE UNIQUE INDEX IF NOT EXISTS idx_user_ssh_keys_fingerprint_unique ON user_ssh_keys(fingerprint)`)
+	}
+	for _, m := range indexes {
+		if _, err := db.Exec(m); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// duplicates lists the rows a unique index would reject. query returns the
+// duplicated value and the IDs of the rows that share it.
+func duplicates(db *sql.DB, query string) ([]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []string
+	for rows.Next() {
+		var value, ids string
+		if err := rows.Scan(&value, &ids); err != nil {
+			return nil, err
+		}
+		list = append(list, fmt.Sprintf("%s: ids %s", value, ids))
+	}
+	return list, rows.Err()
+}
+
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/config"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/middleware"
+	"github.com/rusik69/serverscheduler/internal/models"
+	"github.com/rusik69/serverscheduler/internal/services"
+)
+
+// APIHandler serves the versioned JSON API under /api/v1
+type APIHandler struct {
+	user        services.UserService
+	server      services.ServerService
+	reservation services.ReservationService
+	ssh         services.SSHService
+	tokens      services.APITokenService
+	waitlist    services.WaitlistService
+	policy      services.PolicyService
+	reconciler  *services.Reconciler
+	ca          *services.CertificateAuthority
+	config      config.Config
+}
+
+// NewAPIHandler creates an APIHandler
+func NewAPIHandler(user services.UserService, srv services.ServerService, res services.ReservationService, ssh services.SSHService, tokens services.APITokenService, waitlist services.WaitlistService, policy services.PolicyService, reconciler *services.Reconciler, ca *services.CertificateAuthority, cfg config.Config) *APIHandler {
+	return &APIHandler{user: user, server: srv, reservation: res, ssh: ssh, tokens: tokens, waitlist: waitlist, policy: policy, reconciler: reconciler, ca: ca, config: cfg}
+}
+
+// APIError is the error body returned by every API endpoint
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiCaller is the authenticated principal of an API request.
+// User is nil for the configured admin, which has no users row.
+type apiCaller struct {
+	Username string
+	User     *models.User
+	IsAdmin  bool
+}
+
+func apiError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": APIError{Code: code, Message: message}})
+}
+
+func apiInternalError(c *gin.Context, err error) {
+	logger.FromContext(c.Request.Context()).Error("api request failed", "path", c.Request.URL.Path, "error", err)
+	apiError(c, http.StatusInternalServerError, "internal", "internal server error")
+}
+
+// caller resolves the current principal, writing a 401 if there is none
+func (h *APIHandler) caller(c *gin.Context) (*apiCaller, bool) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		apiError(c, http.StatusUnauthorized, "unauthorized", "authentication required")
+		return nil, false
+	}
+	if username == h.config.AdminUsername {
+		return &apiCaller{Username: username, IsAdmin: true}, true
+	}
+	u, err := h.user.GetByUsername(c.Request.Context(), username)
+	if err != nil {
+		apiInternalError(c, err)
+		return nil, false
+	}
+	if u == nil {
+		apiError(c, http.StatusUnauthorized, "unauthorized", "user no longer exists")
+		return nil, false
+	}
+	return &apiCaller{Username: username, User: u, IsAdmin: u.Role == "admin"}, true
+}
+
+// requireAdmin resolves the caller and writes a 403 unless they are an admin
+func (h *APIHandler) requireAdmin(c *gin.Context) (*apiCaller, bool) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return nil, false
+	}
+	if !caller.IsAdmin {
+		apiError(c, http.StatusForbidden, "forbidden", "admin required")
+		return nil, false
+	}
+	return caller, true
+}
+
+func idParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_id", "invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+func bindJSON(c *gin.Context, dst interface{}) bool {
+	if err := c.ShouldBindJSON(dst); err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_body", err.Error())
+		return false
+	}
+	return true
+}
+
+// meResponse describes the authenticated caller
+type meResponse struct {
+	ID       int64           `json:"id,omitempty"`
+	Username string          `json:"username"`
+	Role     string          `json:"role"`
+	SSHKeys  []models.SSHKey `json:"ssh_keys,omitempty"`
+}
+
+// Me returns the authenticated caller
+func (h *APIHandler) Me(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	if caller.User == nil {
+		c.JSON(http.StatusOK, meResponse{Username: caller.Username, Role: "admin"})
+		return
+	}
+	keys, err := h.user.ListSSHKeys(c.Request.Context(), caller.User.ID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, meResponse{
+		ID:       caller.User.ID,
+		Username: caller.User.Username,
+		Role:     caller.User.Role,
+		SSHKeys:  keys,
+	})
+}
+
+// sessionResponse describes a browser session without its secret cookie value
+type sessionResponse struct {
+	ID         string    `json:"id"`
+	ClientIP   string    `json:"client_ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ListMySessions returns the caller's active browser sessions
+func (h *APIHandler) ListMySessions(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	sessions := middleware.GetSessionStore().ListUserSessions(caller.Username)
+	list := make([]sessionResponse, len(sessions))
+	for i, s := range sessions {
+		list[i] = sessionResponse{
+			ID:         s.ID,
+			ClientIP:   s.ClientIP,
+			UserAgent:  s.UserAgent,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+		}
+	}
+	c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/models"
+	"github.com/rusik69/serverscheduler/internal/services"
+)
+
+// GetSSHCA returns the public key of the SSH certificate authority. Servers in
+// certificate mode list it in sshd's TrustedUserCAKeys.
+func (h *APIHandler) GetSSHCA(c *gin.Context) {
+	if _, ok := h.caller(c); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"public_key": h.ca.PublicKey()})
+}
+
+// GetReservationCertificate returns the SSH certificate of an active
+// reservation on a server in certificate mode
+func (h *APIHandler) GetReservationCertificate(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	r, err := h.reservation.Get(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if r == nil || (!caller.IsAdmin && r.UserID != caller.User.ID) {
+		apiError(c, http.StatusNotFound, "not_found", "reservation not found")
+		return
+	}
+	srv, err := h.server.Get(c.Request.Context(), r.ServerID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if srv == nil || srv.AccessMode != models.AccessModeCertificate {
+		apiError(c, http.StatusConflict, "not_certificate_mode", "the server uses authorized_keys, not certificates")
+		return
+	}
+	if r.Status != "active" {
+		apiError(c, http.StatusConflict, "not_active", "reservation is "+r.Status)
+		return
+	}
+	certs, validBefore, err := h.ca.Issue(c.Request.Context(), r)
+	if err != nil {
+		if errors.Is(err, services.ErrNoSSHKey) {
+			apiError(c, http.StatusConflict, "no_ssh_key", err.Error())
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"certificate":  certs[0].Certificate,
+		"certificates": certs,
+		"principals":   []string{srv.SSHUser},
+		"valid_after":  r.StartTime.UTC().Format(time.RFC3339),
+		"valid_before": validBefore.Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// ListDrift returns the most recent authorized_keys drift reports (admin only)
+func (h *APIHandler) ListDrift(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	list, err := h.reconciler.Reports(c.Request.Context(), driftPageSize)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []models.DriftReport{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// CheckDrift runs the reconciler now and returns what it found (admin only)
+func (h *APIHandler) CheckDrift(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	reports, err := h.reconciler.Run(c.Request.Context())
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if reports == nil {
+		reports = []models.DriftReport{}
+	}
+	logger.FromContext(c.Request.Context()).Info("drift check run", "drift", len(reports), "via", "api")
+	c.JSON(http.StatusOK, reports)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/models"
+	"github.com/rusik69/serverscheduler/internal/services"
+)
+
+type savePolicyRequest struct {
+	ServerID           *int64 `json:"server_id"`
+	MaxDurationMinutes int    `json:"max_duration_minutes"`
+	MaxConcurrent      int    `json:"max_concurrent"`
+	MaxHoursPerWeek    int    `json:"max_hours_per_week"`
+	MaxAdvanceDays     int    `json:"max_advance_days"`
+	MinGapMinutes      int    `json:"min_gap_minutes"`
+}
+
+// ListPolicies returns the booking policies so clients can show the limits
+func (h *APIHandler) ListPolicies(c *gin.Context) {
+	if _, ok := h.caller(c); !ok {
+		return
+	}
+	list, err := h.policy.List(c.Request.Context())
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []models.BookingPolicyWithDetails{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// SavePolicy creates or replaces the global (server_id null) or a server's policy (admin only)
+func (h *APIHandler) SavePolicy(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	var req savePolicyRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.ServerID != nil {
+		srv, err := h.server.Get(c.Request.Context(), *req.ServerID)
+		if err != nil {
+			apiInternalError(c, err)
+			return
+		}
+		if srv == nil {
+			apiError(c, http.StatusBadRequest, "invalid_server", "server not found")
+			return
+		}
+	}
+	saved, err := h.policy.Save(c.Request.Context(), &models.BookingPolicy{
+		ServerID:           req.ServerID,
+		MaxDurationMinutes: req.MaxDurationMinutes,
+		MaxConcurrent:      req.MaxConcurrent,
+		MaxHoursPerWeek:    req.MaxHoursPerWeek,
+		MaxAdvanceDays:     req.MaxAdvanceDays,
+		MinGapMinutes:      req.MinGapMinutes,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPolicy) {
+			apiError(c, http.StatusBadRequest, "invalid_policy", err.Error())
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("booking policy saved", "policy_id", saved.ID, "global", saved.ServerID == nil, "via", "api")
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeletePolicy removes a booking policy (admin only)
+func (h *APIHandler) DeletePolicy(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	if err := h.policy.Delete(c.Request.Context(), id); err != nil {
+		if errors.Is(err, services.ErrPolicyNotFound) {
+			apiError(c, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("booking policy deleted", "policy_id", id, "via", "api")
+	c.Status(http.StatusNoContent)
+}
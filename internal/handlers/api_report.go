@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// defaultReportPeriod is the utilization window used when ?from= is not given
+const defaultReportPeriod = 30 * 24 * time.Hour
+
+// UtilizationReport returns booked versus used hours per server (admin only).
+// The period defaults to the last 30 days and can be set with ?from= and ?to=.
+func (h *APIHandler) UtilizationReport(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	from, to, err := reportRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_range", err.Error())
+		return
+	}
+	list, err := h.reservation.Utilization(c.Request.Context(), from, to)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []models.ServerUtilization{}
+	}
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "servers": list})
+}
+
+// reportRange parses optional from/to bounds (date or datetime, UTC)
+func reportRange(fromStr, toStr string) (time.Time, time.Time, error) {
+	to := time.Now().UTC()
+	if toStr != "" {
+		t, err := parseReportTime(toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to")
+		}
+		to = t
+	}
+	from := to.Add(-defaultReportPeriod)
+	if fromStr != "" {
+		t, err := parseReportTime(fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid from")
+		}
+		from = t
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, errors.New("to must be after from")
+	}
+	return from, to, nil
+}
+
+func parseReportTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return parseDateTimeUTC(s)
+}
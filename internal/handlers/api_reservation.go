@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/models"
+	"github.com/rusik69/serverscheduler/internal/services"
+)
+
+type createReservationRequest struct {
+	ServerID  int64  `json:"server_id" binding:"required"`
+	UserID    int64  `json:"user_id"`
+	StartTime string `json:"start_time" binding:"required"`
+	EndTime   string `json:"end_time" binding:"required"`
+	// SSHKeyIDs limits access to some of the user's keys; empty means all
+	SSHKeyIDs []int64 `json:"ssh_key_ids"`
+}
+
+// ListReservations returns the caller's reservations, or all of them for admins.
+// Admins may narrow the list with ?user_id=.
+func (h *APIHandler) ListReservations(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	var userID *int64
+	if !caller.IsAdmin {
+		userID = &caller.User.ID
+	} else if q := c.Query("user_id"); q != "" {
+		id, err := strconv.ParseInt(q, 10, 64)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_user_id", "invalid user_id")
+			return
+		}
+		userID = &id
+	}
+	list, err := h.reservation.List(c.Request.Context(), userID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []models.ReservationWithDetails{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetReservation returns a single reservation visible to the caller
+func (h *APIHandler) GetReservation(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	r, err := h.reservation.Get(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if r == nil || (!caller.IsAdmin && r.UserID != caller.User.ID) {
+		apiError(c, http.StatusNotFound, "not_found", "reservation not found")
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// CreateReservation books a server for the caller. Admins book on behalf of user_id.
+func (h *APIHandler) CreateReservation(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	var req createReservationRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	userID := req.UserID
+	if caller.IsAdmin {
+		if userID == 0 {
+			apiError(c, http.StatusBadRequest, "user_required", "admins must set user_id")
+			return
+		}
+		u, err := h.user.GetByID(c.Request.Context(), userID)
+		if err != nil {
+			apiInternalError(c, err)
+			return
+		}
+		if u == nil {
+			apiError(c, http.StatusBadRequest, "invalid_user", "user not found")
+			return
+		}
+	} else {
+		if userID != 0 && userID != caller.User.ID {
+			apiError(c, http.StatusForbidden, "forbidden", "cannot book on behalf of another user")
+			return
+		}
+		userID = caller.User.ID
+	}
+	srv, err := h.server.Get(c.Request.Context(), req.ServerID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if srv == nil {
+		apiError(c, http.StatusBadRequest, "invalid_server", "server not found")
+		return
+	}
+	start, err := parseDateTimeUTC(req.StartTime)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_start_time", "invalid start_time")
+		return
+	}
+	end, err := parseDateTimeUTC(req.EndTime)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_end_time", "invalid end_time")
+		return
+	}
+	if !end.After(start) {
+		apiError(c, http.StatusBadRequest, "invalid_range", "end must be after start")
+		return
+	}
+	if start.Before(time.Now().UTC()) {
+		apiError(c, http.StatusBadRequest, "start_in_past", "start time cannot be in the past")
+		return
+	}
+
+	r, err := h.reservation.CreateWithKeys(c.Request.Context(), userID, req.ServerID, start, end, req.SSHKeyIDs)
+	if err != nil {
+		if errors.Is(err, services.ErrOverlap) {
+			apiError(c, http.StatusConflict, "overlap", err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrSSHKeyNotFound) {
+			apiError(c, http.StatusBadRequest, "invalid_ssh_key", "ssh_key_ids must be keys of the booking user")
+			return
+		}
+		if errors.Is(err, services.ErrServerDecommissioned) {
+			apiError(c, http.StatusConflict, "server_decommissioned", err.Error())
+			return
+		}
+		if policyError(c, err) {
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("reservation created", "reservation_id", r.ID, "user_id", userID, "server_id", req.ServerID, "via", "api")
+	c.JSON(http.StatusCreated, r)
+}
+
+type updateReservationRequest struct {
+	EndTime string `json:"end_time" binding:"required"`
+}
+
+// UpdateReservation extends or shortens a pending or active reservation.
+// SSH access of an active reservation is not touched.
+func (h *APIHandler) UpdateReservation(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	var req updateReservationRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	r, err := h.reservation.Get(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if r == nil || (!caller.IsAdmin && r.UserID != caller.User.ID) {
+		apiError(c, http.StatusNotFound, "not_found", "reservation not found")
+		return
+	}
+	end, err := parseDateTimeUTC(req.EndTime)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_end_time", "invalid end_time")
+		return
+	}
+	updated, err := h.reservation.UpdateEndTime(c.Request.Context(), id, end)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOverlap):
+			apiError(c, http.StatusConflict, "overlap", err.Error())
+		case errors.Is(err, services.ErrNotFound):
+			apiError(c, http.StatusConflict, "not_changeable", "reservation is "+r.Status)
+		case errors.Is(err, services.ErrInvalidEndTime):
+			apiError(c, http.StatusBadRequest, "invalid_end_time", err.Error())
+		case errors.As(err, new(*services.PolicyViolationError)):
+			policyError(c, err)
+		default:
+			apiInternalError(c, err)
+		}
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("reservation end time changed", "reservation_id", id, "user_id", r.UserID, "old_end", r.EndTime, "new_end", updated.EndTime, "via", "api")
+	if updated.EndTime.Before(r.EndTime) {
+		promoteWaitlist(c.Request.Context(), h.waitlist, r.ServerID)
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// ReleaseReservation ends an active reservation early, revokes access and frees the slot
+func (h *APIHandler) ReleaseReservation(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	r, err := h.reservation.Get(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if r == nil || (!caller.IsAdmin && r.UserID != caller.User.ID) {
+		apiError(c, http.StatusNotFound, "not_found", "reservation not found")
+		return
+	}
+	if r.Status != "active" {
+		apiError(c, http.StatusConflict, "not_active", "reservation is "+r.Status)
+		return
+	}
+	if err := h.reservation.Release(c.Request.Context(), id); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			apiError(c, http.StatusConflict, "not_active", "reservation is no longer active")
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	revokeAccess(c.Request.Context(), h.user, h.server, h.ssh, h.reservation, r)
+	logger.FromContext(c.Request.Context()).Info("reservation released", "reservation_id", id, "user_id", r.UserID, "via", "api")
+	promoteWaitlist(c.Request.Context(), h.waitlist, r.ServerID)
+	updated, err := h.reservation.Get(c.Request.Context(), id)
+	if err != nil || updated == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+type reviewRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ListApprovals returns bookings awaiting the caller's approval: all of them for
+// admins, otherwise those on servers the caller owns
+func (h *APIHandler) ListApprovals(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	var ownerID *int64
+	if !caller.IsAdmin {
+		ownerID = &caller.User.ID
+	}
+	list, err := h.reservation.ListRequested(c.Request.Context(), ownerID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []models.ReservationWithDetails{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// ApproveReservation signs off a requested booking (admins and server owners)
+func (h *APIHandler) ApproveReservation(c *gin.Context) {
+	h.review(c, true)
+}
+
+// RejectReservation declines a requested booking; a reason is required
+func (h *APIHandler) RejectReservation(c *gin.Context) {
+	h.review(c, false)
+}
+
+func (h *APIHandler) review(c *gin.Context, approve bool) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	var req reviewRequest
+	if c.Request.ContentLength > 0 && !bindJSON(c, &req) {
+		return
+	}
+	r, err := h.reservation.Get(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if r == nil {
+		apiError(c, http.StatusNotFound, "not_found", "reservation not found")
+		return
+	}
+	if !caller.IsAdmin {
+		srv, err := h.server.Get(c.Request.Context(), r.ServerID)
+		if err != nil {
+			apiInternalError(c, err)
+			return
+		}
+		if srv == nil || srv.OwnerID == nil || *srv.OwnerID != caller.User.ID {
+			apiError(c, http.StatusForbidden, "forbidden", "only admins and the server owner can review bookings")
+			return
+		}
+	}
+	var decided *models.Reservation
+	if approve {
+		decided, err = h.reservation.Approve(c.Request.Context(), id, caller.Username, req.Reason)
+	} else {
+		decided, err = h.reservation.Reject(c.Request.Context(), id, caller.Username, req.Reason)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrNotFound):
+			apiError(c, http.StatusConflict, "not_requested", "reservation is "+r.Status)
+		case errors.Is(err, services.ErrReasonRequired):
+			apiError(c, http.StatusBadRequest, "reason_required", "a reason is required to reject")
+		default:
+			apiInternalError(c, err)
+		}
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("reservation reviewed", "reservation_id", id, "user_id", r.UserID, "status", decided.Status, "decided_by", caller.Username, "via", "api")
+	if !approve {
+		promoteWaitlist(c.Request.Context(), h.waitlist, r.ServerID)
+	}
+	c.JSON(http.StatusOK, decided)
+}
+
+// CancelReservation cancels a pending or active reservation and revokes access
+func (h *APIHandler) CancelReservation(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	r, err := h.reservation.Get(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if r == nil || (!caller.IsAdmin && r.UserID != caller.User.ID) {
+		apiError(c, http.StatusNotFound, "not_found", "reservation not found")
+		return
+	}
+	if r.Status != "requested" && r.Status != "pending" && r.Status != "active" {
+		apiError(c, http.StatusConflict, "not_cancellable", "reservation is "+r.Status)
+		return
+	}
+	if caller.IsAdmin {
+		err = h.reservation.CancelByAdmin(c.Request.Context(), id)
+	} else {
+		err = h.reservation.Cancel(c.Request.Context(), id, r.UserID)
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			apiError(c, http.StatusConflict, "not_cancellable", "reservation is no longer requested, pending or active")
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	// Access is revoked once the reservation is no longer active, so the
+	// reconciler cannot restore it in between
+	if r.Status == "active" {
+		revokeAccess(c.Request.Context(), h.user, h.server, h.ssh, h.reservation, r)
+	}
+	logger.FromContext(c.Request.Context()).Info("reservation cancelled", "reservation_id", id, "user_id", r.UserID, "via", "api")
+	promoteWaitlist(c.Request.Context(), h.waitlist, r.ServerID)
+	updated, err := h.reservation.Get(c.Request.Context(), id)
+	if err != nil || updated == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// policyError writes a 422 with code policy_<rule> if err is a booking policy violation
+func policyError(c *gin.Context, err error) bool {
+	var violation *services.PolicyViolationError
+	if !errors.As(err, &violation) {
+		return false
+	}
+	apiError(c, http.StatusUnprocessableEntity, "policy_"+violation.Rule, err.Error())
+	return true
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/models"
+	"github.com/rusik69/serverscheduler/internal/services"
+)
+
+type createSeriesRequest struct {
+	ServerID   int64    `json:"server_id" binding:"required"`
+	UserID     int64    `json:"user_id"`
+	StartTime  string   `json:"start_time" binding:"required"`
+	EndTime    string   `json:"end_time" binding:"required"`
+	Rule       string   `json:"rule" binding:"required"`
+	Exceptions []string `json:"exceptions"`
+}
+
+// createSeriesResponse lists every occurrence; conflicting ones carry an error instead of a reservation_id
+type createSeriesResponse struct {
+	Series      *models.ReservationSeries `json:"series"`
+	Occurrences []models.SeriesOccurrence `json:"occurrences"`
+	Booked      int                       `json:"booked"`
+	Conflicts   int                       `json:"conflicts"`
+}
+
+// ListSeries returns the caller's recurring reservations, or all of them for admins
+func (h *APIHandler) ListSeries(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	var userID *int64
+	if !caller.IsAdmin {
+		userID = &caller.User.ID
+	} else if q := c.Query("user_id"); q != "" {
+		id, err := strconv.ParseInt(q, 10, 64)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_user_id", "invalid user_id")
+			return
+		}
+		userID = &id
+	}
+	list, err := h.reservation.ListSeries(c.Request.Context(), userID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []models.ReservationSeriesWithDetails{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetSeries returns a recurring reservation visible to the caller
+func (h *APIHandler) GetSeries(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	series, err := h.reservation.GetSeries(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if series == nil || (!caller.IsAdmin && series.UserID != caller.User.ID) {
+		apiError(c, http.StatusNotFound, "not_found", "series not found")
+		return
+	}
+	c.JSON(http.StatusOK, series)
+}
+
+// CreateSeries books a recurring reservation. Occurrences that overlap existing
+// bookings are reported individually; the rest are still booked.
+func (h *APIHandler) CreateSeries(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	var req createSeriesRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	userID := req.UserID
+	if caller.IsAdmin {
+		if userID == 0 {
+			apiError(c, http.StatusBadRequest, "user_required", "admins must set user_id")
+			return
+		}
+		if u, err := h.user.GetByID(c.Request.Context(), userID); err != nil {
+			apiInternalError(c, err)
+			return
+		} else if u == nil {
+			apiError(c, http.StatusBadRequest, "invalid_user", "user not found")
+			return
+		}
+	} else {
+		if userID != 0 && userID != caller.User.ID {
+			apiError(c, http.StatusForbidden, "forbidden", "cannot book on behalf of another user")
+			return
+		}
+		userID = caller.User.ID
+	}
+	if srv, err := h.server.Get(c.Request.Context(), req.ServerID); err != nil {
+		apiInternalError(c, err)
+		return
+	} else if srv == nil {
+		apiError(c, http.StatusBadRequest, "invalid_server", "server not found")
+		return
+	}
+	start, err := parseDateTimeUTC(req.StartTime)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_start_time", "invalid start_time")
+		return
+	}
+	end, err := parseDateTimeUTC(req.EndTime)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_end_time", "invalid end_time")
+		return
+	}
+	if !end.After(start) {
+		apiError(c, http.StatusBadRequest, "invalid_range", "end must be after start")
+		return
+	}
+	if start.Before(time.Now().UTC()) {
+		apiError(c, http.StatusBadRequest, "start_in_past", "start time cannot be in the past")
+		return
+	}
+	rule, err := services.ParseRecurrenceRule(req.Rule)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_rule", err.Error())
+		return
+	}
+	exceptions := make([]time.Time, 0, len(req.Exceptions))
+	for _, e := range req.Exceptions {
+		t, err := time.Parse("2006-01-02", e)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_exception", "exceptions must be YYYY-MM-DD dates")
+			return
+		}
+		exceptions = append(exceptions, t)
+	}
+
+	series, occurrences, err := h.reservation.CreateSeries(c.Request.Context(), userID, req.ServerID, start, end, rule, exceptions)
+	if err != nil {
+		var ruleErr *services.SeriesRuleError
+		if errors.As(err, &ruleErr) {
+			apiError(c, http.StatusBadRequest, "invalid_rule", err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrServerDecommissioned) {
+			apiError(c, http.StatusConflict, "server_decommissioned", err.Error())
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	resp := createSeriesResponse{Series: series, Occurrences: occurrences}
+	for _, o := range occurrences {
+		if o.Error == "" {
+			resp.Booked++
+		} else {
+			resp.Conflicts++
+		}
+	}
+	logger.FromContext(c.Request.Context()).Info("reservation series created", "series_id", series.ID, "user_id", userID, "server_id", req.ServerID, "booked", resp.Booked, "conflicts", resp.Conflicts, "via", "api")
+	c.JSON(http.StatusCreated, resp)
+}
+
+// CancelSeries stops a recurring reservation and cancels its upcoming occurrences
+func (h *APIHandler) CancelSeries(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	series, err := h.reservation.GetSeries(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if series == nil || (!caller.IsAdmin && series.UserID != caller.User.ID) {
+		apiError(c, http.StatusNotFound, "not_found", "series not found")
+		return
+	}
+	if err := h.reservation.CancelSeries(c.Request.Context(), id); err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			apiError(c, http.StatusConflict, "not_cancellable", "series is already cancelled")
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("reservation series cancelled", "series_id", id, "user_id", series.UserID, "via", "api")
+	promoteWaitlist(c.Request.Context(), h.waitlist, series.ServerID)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/models"
+	"github.com/rusik69/serverscheduler/internal/services"
+)
+
+type createServerRequest struct {
+	Name             string `json:"name" binding:"required"`
+	Hostname         string `json:"hostname" binding:"required"`
+	Port             int    `json:"port"`
+	SSHUser          string `json:"ssh_user" binding:"required"`
+	SSHPrivateKey    string `json:"ssh_private_key"`
+	GenerateKey      bool   `json:"generate_key"` // instead of ssh_private_key
+	Description      string `json:"description"`
+	RequiresApproval bool   `json:"requires_approval"`
+	OwnerID          *int64 `json:"owner_id"`
+	AccessMode       string `json:"access_mode"`
+}
+
+type serverApprovalRequest struct {
+	RequiresApproval bool   `json:"requires_approval"`
+	OwnerID          *int64 `json:"owner_id"`
+}
+
+// ListServers returns all servers
+func (h *APIHandler) ListServers(c *gin.Context) {
+	if _, ok := h.caller(c); !ok {
+		return
+	}
+	list, err := h.server.List(c.Request.Context())
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []models.Server{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetServer returns a single server
+func (h *APIHandler) GetServer(c *gin.Context) {
+	if _, ok := h.caller(c); !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	srv, err := h.server.Get(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if srv == nil {
+		apiError(c, http.StatusNotFound, "not_found", "server not found")
+		return
+	}
+	c.JSON(http.StatusOK, srv)
+}
+
+// CreateServer adds a server after verifying the SSH connection (admin only)
+func (h *APIHandler) CreateServer(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	var req createServerRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.Port == 0 {
+		req.Port = 22
+	}
+	if req.Port < 1 || req.Port > 65535 {
+		apiError(c, http.StatusBadRequest, "invalid_port", "port must be between 1 and 65535")
+		return
+	}
+	if req.GenerateKey == (req.SSHPrivateKey != "") {
+		apiError(c, http.StatusBadRequest, "ssh_key_required", "set exactly one of ssh_private_key and generate_key")
+		return
+	}
+	if !h.validOwner(c, req.OwnerID) {
+		return
+	}
+	if req.AccessMode == "" {
+		req.AccessMode = models.AccessModeAuthorizedKeys
+	}
+	if !services.ValidAccessMode(req.AccessMode) {
+		apiError(c, http.StatusBadRequest, "invalid_access_mode", services.ErrInvalidAccessMode.Error())
+		return
+	}
+	// A generated key is returned in ssh_public_key for the admin to install; it
+	// cannot be tested before that
+	if !req.GenerateKey {
+		if err := h.ssh.TestConnection(c.Request.Context(), req.Hostname, req.Port, req.SSHUser, req.SSHPrivateKey); err != nil {
+			logger.FromContext(c.Request.Context()).Error("add server SSH test failed", "name", req.Name, "error", err, "via", "api")
+			var mismatch *services.HostKeyMismatchError
+			if errors.As(err, &mismatch) {
+				apiError(c, http.StatusConflict, "host_key_mismatch", err.Error())
+				return
+			}
+			apiError(c, http.StatusUnprocessableEntity, "ssh_connection_failed", "SSH connection failed: "+err.Error())
+			return
+		}
+	}
+	created, err := h.server.Create(c.Request.Context(), &models.Server{
+		Name:             req.Name,
+		Hostname:         req.Hostname,
+		Port:             req.Port,
+		SSHUser:          req.SSHUser,
+		SSHPrivateKey:    req.SSHPrivateKey,
+		Description:      req.Description,
+		RequiresApproval: req.RequiresApproval,
+		OwnerID:          req.OwnerID,
+		AccessMode:       req.AccessMode,
+	})
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("server added", "server_id", created.ID, "name", created.Name, "generated_key", req.GenerateKey, "via", "api")
+	c.JSON(http.StatusCreated, created)
+}
+
+// SetServerApproval sets whether bookings need sign-off and who owns the server (admin only)
+func (h *APIHandler) SetServerApproval(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	var req serverApprovalRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	srv, err := h.server.Get(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if srv == nil {
+		apiError(c, http.StatusNotFound, "not_found", "server not found")
+		return
+	}
+	if !h.validOwner(c, req.OwnerID) {
+		return
+	}
+	if err := h.server.SetApproval(c.Request.Context(), id, req.RequiresApproval, req.OwnerID); err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("server approval updated", "server_id", id, "requires_approval", req.RequiresApproval, "via", "api")
+	updated, err := h.server.Get(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+type updateServerRequest struct {
+	Name          *string `json:"name"`
+	Hostname      *string `json:"hostname"`
+	Port          *int    `json:"port"`
+	SSHUser       *string `json:"ssh_user"`
+	Description   *string `json:"description"`
+	SSHPrivateKey string  `json:"ssh_private_key"` // empty keeps the stored key
+}
+
+// UpdateServer changes the given fields of a server (admin only). The SSH
+// connection is tested again when the address, SSH user or key change, and
+// access of active reservations follows a new address or SSH user.
+func (h *APIHandler) UpdateServer(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	var req updateServerRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	srv, ok := h.serverParam(c)
+	if !ok {
+		return
+	}
+	updated := *srv
+	if req.Name != nil {
+		updated.Name = *req.Name
+	}
+	if req.Hostname != nil {
+		updated.Hostname = *req.Hostname
+	}
+	if req.Port != nil {
+		updated.Port = *req.Port
+	}
+	if req.SSHUser != nil {
+		updated.SSHUser = *req.SSHUser
+	}
+	if req.Description != nil {
+		updated.Description = *req.Description
+	}
+	if updated.Name == "" || updated.Hostname == "" || updated.SSHUser == "" {
+		apiError(c, http.StatusBadRequest, "invalid_body", "name, hostname and ssh_user cannot be empty")
+		return
+	}
+	if updated.Port < 1 || updated.Port > 65535 {
+		apiError(c, http.StatusBadRequest, "invalid_port", "port must be between 1 and 65535")
+		return
+	}
+	if req.SSHPrivateKey != "" {
+		updated.SSHPrivateKey = req.SSHPrivateKey
+	}
+	if req.SSHPrivateKey != "" || !services.SameTarget(srv, &updated) {
+		if err := h.ssh.TestConnection(c.Request.Context(), updated.Hostname, updated.Port, updated.SSHUser, updated.SSHPrivateKey); err != nil {
+			logger.FromContext(c.Request.Context()).Error("edit server SSH test failed", "server_id", srv.ID, "error", err, "via", "api")
+			var mismatch *services.HostKeyMismatchError
+			if errors.As(err, &mismatch) {
+				apiError(c, http.StatusConflict, "host_key_mismatch", err.Error())
+				return
+			}
+			apiError(c, http.StatusUnprocessableEntity, "ssh_connection_failed", "SSH connection failed: "+err.Error())
+			return
+		}
+	}
+	// Only a new key is written; an empty one keeps the stored key
+	updated.SSHPrivateKey = req.SSHPrivateKey
+	saved, err := h.server.Update(c.Request.Context(), &updated)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("server updated", "server_id", saved.ID, "name", saved.Name, "new_key", req.SSHPrivateKey != "", "via", "api")
+	if err := services.MoveActiveKeys(c.Request.Context(), h.reservation, h.user, h.ssh, srv, saved); err != nil {
+		logger.FromContext(c.Request.Context()).Warn("moving active reservation access failed", "server_id", saved.ID, "error", err, "via", "api")
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+// validOwner checks that an optional owner_id refers to an existing user
+func (h *APIHandler) validOwner(c *gin.Context, ownerID *int64) bool {
+	if ownerID == nil {
+		return true
+	}
+	u, err := h.user.GetByID(c.Request.Context(), *ownerID)
+	if err != nil {
+		apiInternalError(c, err)
+		return false
+	}
+	if u == nil {
+		apiError(c, http.StatusBadRequest, "invalid_owner", "owner not found")
+		return false
+	}
+	return true
+}
+
+// DecommissionServer retires a server: it takes no new bookings, its open
+// reservations are cancelled and active access is revoked. The server is kept
+// for history (admin only).
+func (h *APIHandler) DecommissionServer(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	srv, ok := h.serverParam(c)
+	if !ok {
+		return
+	}
+	cancelled, err := services.DecommissionServer(c.Request.Context(), h.server, h.reservation, h.user, h.ssh, srv)
+	if err != nil {
+		if errors.Is(err, services.ErrServerDecommissioned) {
+			apiError(c, http.StatusConflict, "server_decommissioned", err.Error())
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("server decommissioned", "server_id", srv.ID, "cancelled", len(cancelled), "via", "api")
+	if cancelled == nil {
+		cancelled = []models.Reservation{}
+	}
+	c.JSON(http.StatusOK, gin.H{"cancelled_reservations": cancelled})
+}
+
+type accessModeRequest struct {
+	AccessMode string `json:"access_mode" binding:"required"`
+}
+
+// SetServerAccessMode switches a server between authorized_keys and
+// certificate access (admin only)
+func (h *APIHandler) SetServerAccessMode(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	var req accessModeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	srv, ok := h.serverParam(c)
+	if !ok {
+		return
+	}
+	if err := h.server.SetAccessMode(c.Request.Context(), srv.ID, req.AccessMode); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidAccessMode):
+			apiError(c, http.StatusBadRequest, "invalid_access_mode", err.Error())
+		case errors.Is(err, services.ErrAccessModeBusy):
+			apiError(c, http.StatusConflict, "server_busy", err.Error())
+		default:
+			apiInternalError(c, err)
+		}
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("server access mode updated", "server_id", srv.ID, "access_mode", req.AccessMode, "via", "api")
+	updated, err := h.server.Get(c.Request.Context(), srv.ID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+type sessionPolicyRequest struct {
+	SessionPolicy string `json:"session_policy" binding:"required"`
+}
+
+// SetServerSessionPolicy sets what happens to live SSH sessions when a
+// reservation on the server ends (admin only)
+func (h *APIHandler) SetServerSessionPolicy(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	var req sessionPolicyRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	srv, ok := h.serverParam(c)
+	if !ok {
+		return
+	}
+	if err := h.server.SetSessionPolicy(c.Request.Context(), srv.ID, req.SessionPolicy); err != nil {
+		if errors.Is(err, services.ErrInvalidSessionPolicy) {
+			apiError(c, http.StatusBadRequest, "invalid_session_policy", err.Error())
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("server session policy updated", "server_id", srv.ID, "session_policy", req.SessionPolicy, "via", "api")
+	updated, err := h.server.Get(c.Request.Context(), srv.ID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+type trustHostKeyRequest struct {
+	Fingerprint string `json:"fingerprint" binding:"required"`
+}
+
+// GetServerHostKey returns the trusted host key fingerprint and the one the
+// server presents now (admin only)
+func (h *APIHandler) GetServerHostKey(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	srv, ok := h.serverParam(c)
+	if !ok {
+		return
+	}
+	presented, err := h.ssh.HostKey(c.Request.Context(), srv.Hostname, srv.Port)
+	if err != nil {
+		apiError(c, http.StatusBadGateway, "ssh_unreachable", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"trusted": srv.HostKeyFingerprint, "presented": presented, "match": srv.HostKeyFingerprint == presented})
+}
+
+// TrustServerHostKey trusts the host key the server presents now, e.g. after a
+// rebuild; the request must name its fingerprint (admin only)
+func (h *APIHandler) TrustServerHostKey(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	var req trustHostKeyRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	srv, ok := h.serverParam(c)
+	if !ok {
+		return
+	}
+	if err := h.ssh.TrustHostKey(c.Request.Context(), srv.Hostname, srv.Port, req.Fingerprint); err != nil {
+		var mismatch *services.FingerprintMismatchError
+		if errors.As(err, &mismatch) {
+			apiError(c, http.StatusConflict, "fingerprint_mismatch", err.Error())
+			return
+		}
+		apiError(c, http.StatusBadGateway, "ssh_unreachable", err.Error())
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("host key re-trusted", "server_id", srv.ID, "name", srv.Name, "fingerprint", req.Fingerprint, "previous", srv.HostKeyFingerprint, "via", "api")
+	updated, err := h.server.Get(c.Request.Context(), srv.ID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// serverParam loads the server named by the :id path parameter, writing a 400
+// or 404 if there is none
+func (h *APIHandler) serverParam(c *gin.Context) (*models.Server, bool) {
+	id, ok := idParam(c)
+	if !ok {
+		return nil, false
+	}
+	srv, err := h.server.Get(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return nil, false
+	}
+	if srv == nil {
+		apiError(c, http.StatusNotFound, "not_found", "server not found")
+		return nil, false
+	}
+	return srv, true
+}
+
+// RotateServerKey replaces the scheduler's key for a server with a newly
+// generated one. old_key_removed is false when the old key is still in
+// authorized_keys and must be removed by hand (admin only).
+func (h *APIHandler) RotateServerKey(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	srv, ok := h.serverParam(c)
+	if !ok {
+		return
+	}
+	removed, err := services.RotateServerKey(c.Request.Context(), h.server, h.ssh, srv)
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Error("server key rotation failed", "server_id", srv.ID, "error", err, "via", "api")
+		apiError(c, http.StatusBadGateway, "key_rotation_failed", err.Error())
+		return
+	}
+	updated, err := h.server.Get(c.Request.Context(), srv.ID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"server": updated, "old_key_removed": removed})
+}
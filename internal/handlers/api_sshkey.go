@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/models"
+	"github.com/rusik69/serverscheduler/internal/services"
+)
+
+type addSSHKeyRequest struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key" binding:"required"`
+}
+
+// sshKeyOwner resolves the caller as a users row; the configured admin has no SSH keys
+func (h *APIHandler) sshKeyOwner(c *gin.Context) (*models.User, bool) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return nil, false
+	}
+	if caller.User == nil {
+		apiError(c, http.StatusBadRequest, "not_allowed", "admin cannot set SSH keys")
+		return nil, false
+	}
+	return caller.User, true
+}
+
+// ListMySSHKeys returns the caller's SSH keys
+func (h *APIHandler) ListMySSHKeys(c *gin.Context) {
+	u, ok := h.sshKeyOwner(c)
+	if !ok {
+		return
+	}
+	list, err := h.user.ListSSHKeys(c.Request.Context(), u.ID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []models.SSHKey{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// AddMySSHKey registers another SSH key for the caller. Active reservations
+// that use all of the caller's keys get it installed right away.
+func (h *APIHandler) AddMySSHKey(c *gin.Context) {
+	u, ok := h.sshKeyOwner(c)
+	if !ok {
+		return
+	}
+	var req addSSHKeyRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	before, err := h.user.ListSSHKeys(c.Request.Context(), u.ID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	k, err := h.user.AddSSHKey(c.Request.Context(), u.ID, req.Name, req.PublicKey)
+	if err != nil {
+		if sshKeyError(c, err) {
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("SSH key added", "username", u.Username, "key_id", k.ID, "fingerprint", k.Fingerprint, "via", "api")
+	if !h.syncActiveKeys(c, u, before) {
+		return
+	}
+	c.JSON(http.StatusCreated, k)
+}
+
+// DeleteMySSHKey removes one of the caller's SSH keys and revokes the access
+// active reservations granted with it
+func (h *APIHandler) DeleteMySSHKey(c *gin.Context) {
+	u, ok := h.sshKeyOwner(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	before, err := h.user.ListSSHKeys(c.Request.Context(), u.ID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if _, err := h.user.DeleteSSHKey(c.Request.Context(), id, u.ID); err != nil {
+		if errors.Is(err, services.ErrSSHKeyNotFound) {
+			apiError(c, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("SSH key removed", "username", u.Username, "key_id", id, "via", "api")
+	if !h.syncActiveKeys(c, u, before) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// syncActiveKeys applies a change to the user's keys to their active
+// reservations, writing a 502 if that did not fully work
+func (h *APIHandler) syncActiveKeys(c *gin.Context, u *models.User, before []models.SSHKey) bool {
+	after, err := h.user.ListSSHKeys(c.Request.Context(), u.ID)
+	if err != nil {
+		apiInternalError(c, err)
+		return false
+	}
+	if err := services.SyncActiveKeys(c.Request.Context(), h.reservation, h.server, h.user, h.ssh, u, before, after); err != nil {
+		logger.FromContext(c.Request.Context()).Warn("updating keys on active reservations failed", "username", u.Username, "error", err, "via", "api")
+		apiError(c, http.StatusBadGateway, "key_sync_failed", "keys saved, but active reservations were not fully updated: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// sshKeyError writes the response for a public key that was refused and
+// reports whether err was one
+func sshKeyError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrInvalidSSHKey):
+		apiError(c, http.StatusBadRequest, "invalid_ssh_key", err.Error())
+	case errors.Is(err, services.ErrWeakSSHKey):
+		apiError(c, http.StatusBadRequest, "weak_ssh_key", err.Error())
+	case errors.Is(err, services.ErrDuplicateSSHKey):
+		apiError(c, http.StatusConflict, "duplicate_ssh_key", err.Error())
+	case errors.Is(err, services.ErrSSHKeyInUse):
+		apiError(c, http.StatusConflict, "ssh_key_in_use", err.Error())
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/models"
+	"github.com/rusik69/serverscheduler/internal/services"
+)
+
+type createTokenRequest struct {
+	Name      string `json:"name" binding:"required"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// createTokenResponse carries the plaintext token, which is returned only once
+type createTokenResponse struct {
+	models.APIToken
+	Token string `json:"token"`
+}
+
+// tokenOwner resolves the caller as a users row; the configured admin cannot hold tokens
+func (h *APIHandler) tokenOwner(c *gin.Context) (*models.User, bool) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return nil, false
+	}
+	if caller.User == nil {
+		apiError(c, http.StatusBadRequest, "not_allowed", "admin cannot create API tokens")
+		return nil, false
+	}
+	return caller.User, true
+}
+
+// ListTokens returns the caller's API tokens
+func (h *APIHandler) ListTokens(c *gin.Context) {
+	u, ok := h.tokenOwner(c)
+	if !ok {
+		return
+	}
+	list, err := h.tokens.List(c.Request.Context(), u.ID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []models.APIToken{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateToken issues a new API token for the caller
+func (h *APIHandler) CreateToken(c *gin.Context) {
+	u, ok := h.tokenOwner(c)
+	if !ok {
+		return
+	}
+	var req createTokenRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	var expiresAt *time.Time
+	if req.ExpiresAt != "" {
+		t, err := parseDateTimeUTC(req.ExpiresAt)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_expires_at", "invalid expires_at")
+			return
+		}
+		if !t.After(time.Now().UTC()) {
+			apiError(c, http.StatusBadRequest, "invalid_expires_at", "expires_at must be in the future")
+			return
+		}
+		expiresAt = &t
+	}
+	token, t, err := h.tokens.Create(c.Request.Context(), u.ID, req.Name, expiresAt)
+	if err != nil {
+		if errors.Is(err, services.ErrTokenNameRequired) {
+			apiError(c, http.StatusBadRequest, "name_required", err.Error())
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("API token created", "username", u.Username, "token_id", t.ID, "via", "api")
+	c.JSON(http.StatusCreated, createTokenResponse{APIToken: *t, Token: token})
+}
+
+// RevokeToken deletes one of the caller's API tokens
+func (h *APIHandler) RevokeToken(c *gin.Context) {
+	u, ok := h.tokenOwner(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	if err := h.tokens.Revoke(c.Request.Context(), id, u.ID); err != nil {
+		if errors.Is(err, services.ErrTokenNotFound) {
+			apiError(c, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("API token revoked", "username", u.Username, "token_id", id, "via", "api")
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/middleware"
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+type createUserRequest struct {
+	Username     string `json:"username" binding:"required"`
+	Password     string `json:"password" binding:"required"`
+	Role         string `json:"role"`
+	SSHPublicKey string `json:"ssh_public_key"`
+}
+
+func toUserPublic(u *models.User) models.UserPublic {
+	return models.UserPublic{ID: u.ID, Username: u.Username, Role: u.Role, CreatedAt: u.CreatedAt}
+}
+
+// ListUsers returns all users (admin only)
+func (h *APIHandler) ListUsers(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	list, err := h.user.List(c.Request.Context())
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []models.UserPublic{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetUser returns a single user (admin only)
+func (h *APIHandler) GetUser(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	u, err := h.user.GetByID(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if u == nil {
+		apiError(c, http.StatusNotFound, "not_found", "user not found")
+		return
+	}
+	c.JSON(http.StatusOK, toUserPublic(u))
+}
+
+// CreateUser registers a user or admin (admin only)
+func (h *APIHandler) CreateUser(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	var req createUserRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.Role == "" {
+		req.Role = "user"
+	}
+	if req.Role != "user" && req.Role != "admin" {
+		apiError(c, http.StatusBadRequest, "invalid_role", "role must be user or admin")
+		return
+	}
+	if len(req.Password) < 6 {
+		apiError(c, http.StatusBadRequest, "weak_password", "password must be at least 6 characters")
+		return
+	}
+	if req.Username == h.config.AdminUsername {
+		apiError(c, http.StatusBadRequest, "username_not_allowed", "username not allowed")
+		return
+	}
+	existing, err := h.user.GetByUsername(c.Request.Context(), req.Username)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if existing != nil {
+		apiError(c, http.StatusConflict, "username_taken", "username already exists")
+		return
+	}
+	var u *models.User
+	if req.Role == "admin" {
+		u, err = h.user.Create(c.Request.Context(), req.Username, req.Password, "admin")
+	} else {
+		u, err = h.user.CreateWithSSHKey(c.Request.Context(), req.Username, req.Password, req.SSHPublicKey)
+	}
+	if err != nil {
+		if sshKeyError(c, err) {
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("register user success", "username", u.Username, "role", u.Role, "via", "api")
+	c.JSON(http.StatusCreated, toUserPublic(u))
+}
+
+// DeleteUser revokes a user's active access, drops their reservations and deletes them (admin only)
+func (h *APIHandler) DeleteUser(c *gin.Context) {
+	caller, ok := h.requireAdmin(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	u, err := h.user.GetByID(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if u == nil {
+		apiError(c, http.StatusNotFound, "not_found", "user not found")
+		return
+	}
+	if u.Username == h.config.AdminUsername || u.Username == caller.Username {
+		apiError(c, http.StatusBadRequest, "not_allowed", "cannot delete this user")
+		return
+	}
+	// Reservations go first so the reconciler does not restore revoked keys
+	reservations, _ := h.reservation.List(c.Request.Context(), &u.ID)
+	_ = h.reservation.DeleteByUserID(c.Request.Context(), u.ID)
+	freed := map[int64]bool{}
+	for _, r := range reservations {
+		if r.Status == "active" {
+			revokeAccess(c.Request.Context(), h.user, h.server, h.ssh, h.reservation, &r.Reservation)
+		}
+		if r.Status == "active" || r.Status == "pending" {
+			freed[r.ServerID] = true
+		}
+	}
+	if err := h.user.Delete(c.Request.Context(), id); err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	for serverID := range freed {
+		promoteWaitlist(c.Request.Context(), h.waitlist, serverID)
+	}
+	killed := middleware.GetSessionStore().DeleteUserSessions(u.Username)
+	logger.FromContext(c.Request.Context()).Info("user deleted", "user_id", id, "username", u.Username, "sessions_revoked", killed, "via", "api")
+	c.Status(http.StatusNoContent)
+}
+
+// LogoutUser signs a user out of every browser session (admin only)
+func (h *APIHandler) LogoutUser(c *gin.Context) {
+	if _, ok := h.requireAdmin(c); !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	u, err := h.user.GetByID(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if u == nil {
+		apiError(c, http.StatusNotFound, "not_found", "user not found")
+		return
+	}
+	killed := middleware.GetSessionStore().DeleteUserSessions(u.Username)
+	logger.FromContext(c.Request.Context()).Info("user sessions revoked by admin", "user_id", id, "username", u.Username, "sessions_revoked", killed, "via", "api")
+	c.JSON(http.StatusOK, gin.H{"sessions_revoked": killed})
+}
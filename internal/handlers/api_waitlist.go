@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/models"
+	"github.com/rusik69/serverscheduler/internal/services"
+)
+
+type joinWaitlistRequest struct {
+	ServerID  int64  `json:"server_id" binding:"required"`
+	StartTime string `json:"start_time" binding:"required"`
+	EndTime   string `json:"end_time" binding:"required"`
+}
+
+// ListWaitlist returns the caller's waitlist entries, or all of them for admins
+func (h *APIHandler) ListWaitlist(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	var userID *int64
+	if !caller.IsAdmin {
+		userID = &caller.User.ID
+	}
+	list, err := h.waitlist.List(c.Request.Context(), userID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []models.WaitlistEntryWithDetails{}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// JoinWaitlist queues the caller for a server and time range. If the range is
+// already free the entry is promoted to a reservation right away.
+func (h *APIHandler) JoinWaitlist(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	if caller.IsAdmin {
+		apiError(c, http.StatusForbidden, "forbidden", "admins cannot join the waitlist")
+		return
+	}
+	var req joinWaitlistRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	srv, err := h.server.Get(c.Request.Context(), req.ServerID)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if srv == nil {
+		apiError(c, http.StatusBadRequest, "invalid_server", "server not found")
+		return
+	}
+	start, err := parseDateTimeUTC(req.StartTime)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_start_time", "invalid start_time")
+		return
+	}
+	end, err := parseDateTimeUTC(req.EndTime)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_end_time", "invalid end_time")
+		return
+	}
+	if !end.After(start) {
+		apiError(c, http.StatusBadRequest, "invalid_range", "end must be after start")
+		return
+	}
+	if !end.After(time.Now().UTC()) {
+		apiError(c, http.StatusBadRequest, "end_in_past", "end time is in the past")
+		return
+	}
+	w, err := h.waitlist.Join(c.Request.Context(), caller.User.ID, req.ServerID, start, end)
+	if err != nil {
+		if errors.Is(err, services.ErrAlreadyWaiting) {
+			apiError(c, http.StatusConflict, "already_waiting", err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrInvalidWaitlistWindow) {
+			apiError(c, http.StatusBadRequest, "invalid_range", err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrServerDecommissioned) {
+			apiError(c, http.StatusConflict, "server_decommissioned", err.Error())
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("waitlist joined", "waitlist_id", w.ID, "user_id", w.UserID, "server_id", w.ServerID, "via", "api")
+	promoteWaitlist(c.Request.Context(), h.waitlist, req.ServerID)
+	if updated, err := h.waitlist.Get(c.Request.Context(), w.ID); err == nil && updated != nil {
+		w = updated
+	}
+	c.JSON(http.StatusCreated, w)
+}
+
+// LeaveWaitlist cancels a waiting entry
+func (h *APIHandler) LeaveWaitlist(c *gin.Context) {
+	caller, ok := h.caller(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	w, err := h.waitlist.Get(c.Request.Context(), id)
+	if err != nil {
+		apiInternalError(c, err)
+		return
+	}
+	if w == nil || (!caller.IsAdmin && w.UserID != caller.User.ID) {
+		apiError(c, http.StatusNotFound, "not_found", "waitlist entry not found")
+		return
+	}
+	if err := h.waitlist.Cancel(c.Request.Context(), id); err != nil {
+		if errors.Is(err, services.ErrWaitlistNotFound) {
+			apiError(c, http.StatusConflict, "not_waiting", "waitlist entry is "+w.Status)
+			return
+		}
+		apiInternalError(c, err)
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("waitlist left", "waitlist_id", id, "user_id", w.UserID, "via", "api")
+	c.Status(http.StatusNoContent)
+}
@@ -2,7 +2,7 @@ package handlers
 
 import (
 	"net/http"
-	"time"
+	"net/url"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rusik69/serverscheduler/internal/config"
@@ -53,8 +53,8 @@ func (h *AuthHandler) Login(c *gin.Context) {
 			return
 		}
 		sessionID := middleware.GenerateSessionID()
-		middleware.GetSessionStore().SetSession(sessionID, username)
-		c.SetCookie("session_id", sessionID, int(24*time.Hour.Seconds()), "/", "", false, false)
+		middleware.GetSessionStore().SetSession(sessionID, username, c.ClientIP(), c.Request.UserAgent())
+		c.SetCookie("session_id", sessionID, int(middleware.SessionTTL.Seconds()), "/", "", false, false)
 		logger.FromContext(c.Request.Context()).Info("login success", "username", username, "role", "admin")
 		c.Redirect(http.StatusFound, "/reservations")
 		return
@@ -73,8 +73,8 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	sessionID := middleware.GenerateSessionID()
-	middleware.GetSessionStore().SetSession(sessionID, username)
-	c.SetCookie("session_id", sessionID, int(24*time.Hour.Seconds()), "/", "", false, false)
+	middleware.GetSessionStore().SetSession(sessionID, username, c.ClientIP(), c.Request.UserAgent())
+	c.SetCookie("session_id", sessionID, int(middleware.SessionTTL.Seconds()), "/", "", false, false)
 	logger.FromContext(c.Request.Context()).Info("login success", "username", username)
 	c.Redirect(http.StatusFound, "/reservations")
 }
@@ -105,6 +105,10 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	}
 
 	_, err := h.user.CreateWithSSHKey(c.Request.Context(), username, password, sshKey)
+	if sshKeyRefused(err) {
+		c.Redirect(http.StatusFound, "/register?error="+url.QueryEscape(err.Error()))
+		return
+	}
 	if err != nil {
 		logger.FromContext(c.Request.Context()).Warn("register failed", "username", username, "error", err)
 		c.Redirect(http.StatusFound, "/register?error=username+already+exists")
@@ -135,14 +139,22 @@ func (h *AuthHandler) RegisterUser(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/users?error=username+not+allowed")
 		return
 	}
-	_, err := h.user.CreateWithSSHKey(c.Request.Context(), username, password, sshKey)
+	u, err := h.user.CreateWithSSHKey(c.Request.Context(), username, password, sshKey)
+	if sshKeyRefused(err) {
+		c.Redirect(http.StatusFound, "/users?error="+url.QueryEscape(err.Error()))
+		return
+	}
 	if err != nil {
 		logger.FromContext(c.Request.Context()).Warn("register user failed", "username", username, "error", err)
 		c.Redirect(http.StatusFound, "/users?error=username+already+exists")
 		return
 	}
 	logger.FromContext(c.Request.Context()).Info("register user success", "username", username)
-	c.Redirect(http.StatusFound, "/users?success=User+registered")
+	success := "User registered"
+	if keys, _ := h.user.ListSSHKeys(c.Request.Context(), u.ID); len(keys) > 0 {
+		success += " with SSH key " + keys[0].Fingerprint
+	}
+	c.Redirect(http.StatusFound, "/users?success="+url.QueryEscape(success))
 }
 
 // RegisterAdmin handles form POST (admin only)
@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rusik69/serverscheduler/internal/config"
+	"github.com/rusik69/serverscheduler/internal/logger"
 	"github.com/rusik69/serverscheduler/internal/middleware"
+	"github.com/rusik69/serverscheduler/internal/models"
 	"github.com/rusik69/serverscheduler/internal/services"
 	"github.com/rusik69/serverscheduler/internal/templates"
 )
@@ -67,3 +71,68 @@ func isAdmin(c *gin.Context, user services.UserService, cfg config.Config) bool
 	}
 	return u.Role == "admin"
 }
+
+// sshKeyRefused reports whether err is a public key being refused at input,
+// whose message can be shown to the user
+func sshKeyRefused(err error) bool {
+	return errors.Is(err, services.ErrInvalidSSHKey) || errors.Is(err, services.ErrWeakSSHKey) ||
+		errors.Is(err, services.ErrDuplicateSSHKey) || errors.Is(err, services.ErrSSHKeyInUse)
+}
+
+// revokeAccess removes the reservation owner's SSH keys from the reserved server
+// and applies the server's session policy to their live sessions. A failed
+// removal is queued and retried by the scheduler.
+func revokeAccess(ctx context.Context, user services.UserService, server services.ServerService, ssh services.SSHService, res services.ReservationService, r *models.Reservation) {
+	keys, _ := user.ListSSHKeys(ctx, r.UserID)
+	installed := services.InstalledKeys(r, keys)
+	if len(installed) == 0 {
+		return
+	}
+	srv, _ := server.Get(ctx, r.ServerID)
+	usr, _ := user.GetByID(ctx, r.UserID)
+	if srv == nil || usr == nil {
+		return
+	}
+	// A certificate cannot be taken back; it runs out within the CA's ttl
+	if srv.AccessMode != models.AccessModeCertificate {
+		for _, key := range installed {
+			if err := services.RemoveKeyOrQueue(ctx, res, ssh, srv, r.UserID, key, services.KeyMarker(r.ID, usr.Username)); err != nil {
+				logger.FromContext(ctx).Warn("remove key failed, queued for retry", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "error", err)
+			}
+		}
+	}
+	// Only an active reservation can have sessions of its own
+	if r.Status != "active" {
+		return
+	}
+	if _, err := services.EndSessions(ctx, res, ssh, srv, r, keys); err != nil {
+		logger.FromContext(ctx).Warn("ending live sessions failed", "reservation_id", r.ID, "server_id", r.ServerID, "error", err)
+	}
+}
+
+// promoteWaitlist books waiting users into a server's freed time
+func promoteWaitlist(ctx context.Context, waitlist services.WaitlistService, serverID int64) {
+	promoted, err := waitlist.Promote(ctx, serverID)
+	if err != nil {
+		logger.FromContext(ctx).Error("waitlist promote failed", "server_id", serverID, "error", err)
+		return
+	}
+	if len(promoted) > 0 {
+		logger.FromContext(ctx).Info("waitlist promoted", "server_id", serverID, "promoted", len(promoted))
+	}
+}
+
+// canReview reports whether the current user may approve or reject bookings on
+// a server: admins, and the server's owner
+func canReview(c *gin.Context, user services.UserService, server services.ServerService, cfg config.Config, serverID int64) bool {
+	if isAdmin(c, user, cfg) {
+		return true
+	}
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		return false
+	}
+	u, _ := user.GetByUsername(c.Request.Context(), username)
+	srv, _ := server.Get(c.Request.Context(), serverID)
+	return u != nil && srv != nil && srv.OwnerID != nil && *srv.OwnerID == u.ID
+}
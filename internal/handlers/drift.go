@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+)
+
+// driftPageSize is how many recent drift reports are shown and returned
+const driftPageSize = 50
+
+// CheckDrift handles form POST - runs the authorized_keys reconciler now (admin only)
+func (h *ServerHandler) CheckDrift(c *gin.Context) {
+	if !isAdmin(c, h.user, h.config) {
+		c.Redirect(http.StatusFound, "/servers?error=admin+required")
+		return
+	}
+	reports, err := h.reconciler.Run(c.Request.Context())
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Error("drift check failed", "error", err)
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("drift check run", "drift", len(reports))
+	msg := "No drift found"
+	if len(reports) > 0 {
+		msg = fmt.Sprintf("Drift check found %d issue(s)", len(reports))
+	}
+	c.Redirect(http.StatusFound, "/servers?success="+url.QueryEscape(msg))
+}
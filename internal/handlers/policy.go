@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/models"
+	"github.com/rusik69/serverscheduler/internal/services"
+)
+
+// SavePolicy handles form POST - creates or replaces the global or a server's booking policy (admin only)
+func (h *ServerHandler) SavePolicy(c *gin.Context) {
+	if !isAdmin(c, h.user, h.config) {
+		c.Redirect(http.StatusFound, "/servers?error=admin+required")
+		return
+	}
+	serverID, err := parseOptionalID(c.PostForm("server_id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+server")
+		return
+	}
+	p := &models.BookingPolicy{ServerID: serverID}
+	for field, dest := range map[string]*int{
+		"max_duration_minutes": &p.MaxDurationMinutes,
+		"max_concurrent":       &p.MaxConcurrent,
+		"max_hours_per_week":   &p.MaxHoursPerWeek,
+		"max_advance_days":     &p.MaxAdvanceDays,
+		"min_gap_minutes":      &p.MinGapMinutes,
+	} {
+		v := c.PostForm(field)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape("invalid "+field))
+			return
+		}
+		*dest = n
+	}
+	saved, err := h.policy.Save(c.Request.Context(), p)
+	if err != nil {
+		if err == services.ErrInvalidPolicy {
+			c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(err.Error()))
+			return
+		}
+		logger.FromContext(c.Request.Context()).Error("save booking policy failed", "error", err)
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("booking policy saved", "policy_id", saved.ID, "global", saved.ServerID == nil)
+	c.Redirect(http.StatusFound, "/servers?success=Booking+policy+saved")
+}
+
+// DeletePolicy handles form POST (admin only)
+func (h *ServerHandler) DeletePolicy(c *gin.Context) {
+	if !isAdmin(c, h.user, h.config) {
+		c.Redirect(http.StatusFound, "/servers?error=admin+required")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+id")
+		return
+	}
+	if err := h.policy.Delete(c.Request.Context(), id); err != nil {
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("booking policy deleted", "policy_id", id)
+	c.Redirect(http.StatusFound, "/servers?success=Booking+policy+removed")
+}
@@ -3,8 +3,11 @@ package handlers
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -22,12 +25,14 @@ type ReservationHandler struct {
 	server      services.ServerService
 	user        services.UserService
 	ssh         services.SSHService
+	waitlist    services.WaitlistService
+	ca          *services.CertificateAuthority
 	config      config.Config
 }
 
 // NewReservationHandler creates a ReservationHandler
-func NewReservationHandler(res services.ReservationService, srv services.ServerService, user services.UserService, ssh services.SSHService, cfg config.Config) *ReservationHandler {
-	return &ReservationHandler{reservation: res, server: srv, user: user, ssh: ssh, config: cfg}
+func NewReservationHandler(res services.ReservationService, srv services.ServerService, user services.UserService, ssh services.SSHService, waitlist services.WaitlistService, ca *services.CertificateAuthority, cfg config.Config) *ReservationHandler {
+	return &ReservationHandler{reservation: res, server: srv, user: user, ssh: ssh, waitlist: waitlist, ca: ca, config: cfg}
 }
 
 // reservationDataItem is the JSON shape for /reservations/data
@@ -40,7 +45,12 @@ type reservationDataItem struct {
 	StartUTC        string `json:"start_utc"`
 	EndUTC          string `json:"end_utc"`
 	Status          string `json:"status"`
+	SeriesID        *int64 `json:"series_id,omitempty"`
+	DecisionReason  string `json:"decision_reason,omitempty"`
+	LastError       string `json:"last_error,omitempty"`
 	CanCancel       bool   `json:"can_cancel"`
+	CanRelease      bool   `json:"can_release"`
+	CanDownloadCert bool   `json:"can_download_certificate"`
 }
 
 // ReservationsData returns reservations as JSON for polling
@@ -85,7 +95,12 @@ func (h *ReservationHandler) ReservationsData(c *gin.Context) {
 			StartUTC:       startISO,
 			EndUTC:         endISO,
 			Status:        r.Status,
-			CanCancel:     r.Status == "pending" || r.Status == "active",
+			SeriesID:      r.SeriesID,
+			DecisionReason: r.DecisionReason,
+			LastError:      r.LastError,
+			CanCancel:     r.Status == "requested" || r.Status == "pending" || r.Status == "active",
+			CanRelease:    r.Status == "active",
+			CanDownloadCert: r.Status == "active" && r.AccessMode == models.AccessModeCertificate,
 		}
 	}
 	c.JSON(http.StatusOK, items)
@@ -117,16 +132,43 @@ func (h *ReservationHandler) ReservationsPage(c *gin.Context) {
 			}
 		}
 	}
+	series, _ := h.reservation.ListSeries(c.Request.Context(), userID)
+	waitlist, _ := h.waitlist.List(c.Request.Context(), userID)
+	var sshKeys []models.SSHKey
+	if canCreate && u != nil {
+		sshKeys, _ = h.user.ListSSHKeys(c.Request.Context(), u.ID)
+	}
+	var approvals []models.ReservationWithDetails
+	if isAdmin {
+		approvals, _ = h.reservation.ListRequested(c.Request.Context(), nil)
+	} else if u != nil {
+		approvals, _ = h.reservation.ListRequested(c.Request.Context(), &u.ID)
+	}
+	var offer *waitlistOffer
+	if canCreate && c.Query("waitlist_server") != "" {
+		offer = &waitlistOffer{ServerID: c.Query("waitlist_server"), StartTime: c.Query("waitlist_start"), EndTime: c.Query("waitlist_end")}
+		for _, s := range servers {
+			if strconv.FormatInt(s.ID, 10) == offer.ServerID {
+				offer.ServerName = s.Name
+			}
+		}
+	}
 	bd := baseData(c, h.user, h.config, "Reservations", "reservations")
 	data := struct {
 		templates.BaseData
-		Reservations []models.ReservationWithDetails
-		Servers      []models.Server
-		Users        []models.UserPublic
-		CanCreate    bool
-		IsAdmin      bool
-		Error        string
-	}{BaseData: bd, Reservations: reservations, Servers: servers, Users: users, CanCreate: canCreate, IsAdmin: isAdmin, Error: c.Query("error")}
+		Reservations  []models.ReservationWithDetails
+		Series        []models.ReservationSeriesWithDetails
+		Waitlist      []models.WaitlistEntryWithDetails
+		Approvals     []models.ReservationWithDetails
+		WaitlistOffer *waitlistOffer
+		Servers       []models.Server
+		Users         []models.UserPublic
+		SSHKeys       []models.SSHKey
+		CanCreate     bool
+		IsAdmin       bool
+		Error         string
+		Success       string
+	}{BaseData: bd, Reservations: reservations, Series: series, Waitlist: waitlist, Approvals: approvals, WaitlistOffer: offer, Servers: servers, Users: users, SSHKeys: sshKeys, CanCreate: canCreate, IsAdmin: isAdmin, Error: c.Query("error"), Success: c.Query("success")}
 	render(c, "reservations", data)
 }
 
@@ -176,19 +218,56 @@ func (h *ReservationHandler) AddReservation(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/reservations?error=start+time+cannot+be+in+the+past")
 		return
 	}
+	rule, exceptions, err := parseRepeatForm(c)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	var keyIDs []int64
+	for _, v := range c.PostFormArray("ssh_key_id") {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.Redirect(http.StatusFound, "/reservations?error=invalid+SSH+key")
+			return
+		}
+		keyIDs = append(keyIDs, id)
+	}
+	if rule != nil {
+		if len(keyIDs) > 0 {
+			c.Redirect(http.StatusFound, "/reservations?error=recurring+reservations+always+use+all+of+your+SSH+keys")
+			return
+		}
+		h.createSeries(c, u.ID, serverID, start, end, *rule, exceptions)
+		return
+	}
 
-	r, err := h.reservation.Create(c.Request.Context(), u.ID, serverID, start, end)
+	r, err := h.reservation.CreateWithKeys(c.Request.Context(), u.ID, serverID, start, end, keyIDs)
 	if err != nil {
 		if err == services.ErrOverlap {
 			logger.FromContext(c.Request.Context()).Warn("reservation create failed", "user_id", u.ID, "server_id", serverID, "error", "overlap")
-			c.Redirect(http.StatusFound, "/reservations?error=reservation+overlaps")
+			q := url.Values{}
+			q.Set("error", "reservation overlaps")
+			q.Set("waitlist_server", strconv.FormatInt(serverID, 10))
+			q.Set("waitlist_start", start.Format("2006-01-02T15:04"))
+			q.Set("waitlist_end", end.Format("2006-01-02T15:04"))
+			c.Redirect(http.StatusFound, "/reservations?"+q.Encode())
+			return
+		}
+		var violation *services.PolicyViolationError
+		if errors.As(err, &violation) {
+			logger.FromContext(c.Request.Context()).Warn("reservation create failed", "user_id", u.ID, "server_id", serverID, "error", "policy", "rule", violation.Rule)
+			c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
 			return
 		}
 		logger.FromContext(c.Request.Context()).Error("reservation create failed", "user_id", u.ID, "server_id", serverID, "error", err)
 		c.Redirect(http.StatusFound, "/reservations?error="+err.Error())
 		return
 	}
-	logger.FromContext(c.Request.Context()).Info("reservation created", "reservation_id", r.ID, "user_id", u.ID, "server_id", serverID)
+	logger.FromContext(c.Request.Context()).Info("reservation created", "reservation_id", r.ID, "user_id", u.ID, "server_id", serverID, "status", r.Status)
+	if r.Status == "requested" {
+		c.Redirect(http.StatusFound, "/reservations?success=Reservation+requested%3B+it+starts+once+approved")
+		return
+	}
 	c.Redirect(http.StatusFound, "/reservations")
 }
 
@@ -216,9 +295,6 @@ func (h *ReservationHandler) CancelReservation(c *gin.Context) {
 			return
 		}
 	}
-	if r.Status == "active" || r.Status == "pending" {
-		h.revokeAccess(c.Request.Context(), r)
-	}
 	if isAdmin(c, h.user, h.config) {
 		if err := h.reservation.CancelByAdmin(c.Request.Context(), id); err != nil {
 			logger.FromContext(c.Request.Context()).Error("admin cancel reservation failed", "reservation_id", id, "error", err)
@@ -236,20 +312,227 @@ func (h *ReservationHandler) CancelReservation(c *gin.Context) {
 			return
 		}
 	}
+	// Access is revoked once the reservation is no longer active, so the
+	// reconciler cannot restore it in between
+	if r.Status == "active" || r.Status == "pending" {
+		h.revokeAccess(c.Request.Context(), r)
+	}
 	logger.FromContext(c.Request.Context()).Info("reservation cancelled", "reservation_id", id, "user_id", r.UserID)
+	promoteWaitlist(c.Request.Context(), h.waitlist, r.ServerID)
 	c.Redirect(http.StatusFound, "/reservations")
 }
 
-func (h *ReservationHandler) revokeAccess(ctx context.Context, r *models.Reservation) {
-	usr, _ := h.user.GetByID(ctx, r.UserID)
-	if usr == nil || usr.SSHPublicKey == "" {
+// DownloadCertificate serves the SSH certificates of an active reservation on a
+// server in certificate mode, one line per key, or with ?key=<id> only the one
+// for that key, for use as ~/.ssh/id_<type>-cert.pub
+func (h *ReservationHandler) DownloadCertificate(c *gin.Context) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error=invalid+id")
+		return
+	}
+	r, _ := h.reservation.Get(c.Request.Context(), id)
+	if r == nil {
+		c.Redirect(http.StatusFound, "/reservations?error=reservation+not+found")
+		return
+	}
+	if !isAdmin(c, h.user, h.config) {
+		u, _ := h.user.GetByUsername(c.Request.Context(), username)
+		if u == nil || u.ID != r.UserID {
+			c.Redirect(http.StatusFound, "/reservations?error=reservation+not+found")
+			return
+		}
+	}
+	srv, _ := h.server.Get(c.Request.Context(), r.ServerID)
+	if srv == nil || srv.AccessMode != models.AccessModeCertificate {
+		c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape("this server uses authorized_keys, not certificates"))
+		return
+	}
+	if r.Status != "active" {
+		c.Redirect(http.StatusFound, "/reservations?error=certificates+are+only+available+for+active+reservations")
+		return
+	}
+	certs, _, err := h.ca.Issue(c.Request.Context(), r)
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Error("issue certificate failed", "reservation_id", id, "error", err)
+		c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	filename := fmt.Sprintf("reservation-%d-cert.pub", r.ID)
+	if key := c.Query("key"); key != "" {
+		var picked []services.IssuedCertificate
+		for _, cert := range certs {
+			if strconv.FormatInt(cert.SSHKeyID, 10) == key {
+				picked = append(picked, cert)
+			}
+		}
+		if len(picked) == 0 {
+			c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape("that key is not part of the reservation"))
+			return
+		}
+		certs = picked
+		filename = fmt.Sprintf("reservation-%d-key-%s-cert.pub", r.ID, key)
+	}
+	lines := make([]string, len(certs))
+	for i, cert := range certs {
+		lines[i] = cert.Certificate
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.String(http.StatusOK, strings.Join(lines, "\n")+"\n")
+}
+
+// ReleaseReservation handles form POST - ends an active reservation early ("I'm done")
+func (h *ReservationHandler) ReleaseReservation(c *gin.Context) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error=invalid+id")
+		return
+	}
+	r, _ := h.reservation.Get(c.Request.Context(), id)
+	if r == nil {
+		c.Redirect(http.StatusFound, "/reservations?error=reservation+not+found")
+		return
+	}
+	if !isAdmin(c, h.user, h.config) {
+		u, _ := h.user.GetByUsername(c.Request.Context(), username)
+		if u == nil || u.ID != r.UserID {
+			c.Redirect(http.StatusFound, "/reservations?error=reservation+not+found")
+			return
+		}
+	}
+	if r.Status != "active" {
+		c.Redirect(http.StatusFound, "/reservations?error=only+active+reservations+can+be+released")
+		return
+	}
+	if err := h.reservation.Release(c.Request.Context(), id); err != nil {
+		if err == services.ErrNotFound {
+			c.Redirect(http.StatusFound, "/reservations?error=only+active+reservations+can+be+released")
+			return
+		}
+		logger.FromContext(c.Request.Context()).Error("release reservation failed", "reservation_id", id, "error", err)
+		c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	h.revokeAccess(c.Request.Context(), r)
+	logger.FromContext(c.Request.Context()).Info("reservation released", "reservation_id", id, "user_id", r.UserID)
+	promoteWaitlist(c.Request.Context(), h.waitlist, r.ServerID)
+	c.Redirect(http.StatusFound, "/reservations?success=Reservation+released")
+}
+
+// ApproveReservation handles form POST - signs off a requested booking (admins and server owners)
+func (h *ReservationHandler) ApproveReservation(c *gin.Context) {
+	h.decide(c, true)
+}
+
+// RejectReservation handles form POST - declines a requested booking with a reason
+func (h *ReservationHandler) RejectReservation(c *gin.Context) {
+	h.decide(c, false)
+}
+
+func (h *ReservationHandler) decide(c *gin.Context, approve bool) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error=invalid+id")
+		return
+	}
+	r, _ := h.reservation.Get(c.Request.Context(), id)
+	if r == nil || !canReview(c, h.user, h.server, h.config, r.ServerID) {
+		c.Redirect(http.StatusFound, "/reservations?error=reservation+not+found")
+		return
+	}
+	reason := c.PostForm("reason")
+	var decided *models.Reservation
+	if approve {
+		decided, err = h.reservation.Approve(c.Request.Context(), id, username, reason)
+	} else {
+		decided, err = h.reservation.Reject(c.Request.Context(), id, username, reason)
+	}
+	if err != nil {
+		if err == services.ErrNotFound {
+			c.Redirect(http.StatusFound, "/reservations?error=reservation+is+not+awaiting+approval")
+			return
+		}
+		if err == services.ErrReasonRequired {
+			c.Redirect(http.StatusFound, "/reservations?error=a+reason+is+required+to+reject")
+			return
+		}
+		logger.FromContext(c.Request.Context()).Error("reservation review failed", "reservation_id", id, "error", err)
+		c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("reservation reviewed", "reservation_id", id, "user_id", r.UserID, "status", decided.Status, "decided_by", username)
+	if !approve {
+		promoteWaitlist(c.Request.Context(), h.waitlist, r.ServerID)
+		c.Redirect(http.StatusFound, "/reservations?success=Reservation+rejected")
+		return
+	}
+	c.Redirect(http.StatusFound, "/reservations?success=Reservation+approved")
+}
+
+// UpdateEndTime handles form POST - extends or shortens a pending or active reservation
+func (h *ReservationHandler) UpdateEndTime(c *gin.Context) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error=invalid+id")
+		return
+	}
+	r, _ := h.reservation.Get(c.Request.Context(), id)
+	if r == nil {
+		c.Redirect(http.StatusFound, "/reservations?error=reservation+not+found")
 		return
 	}
-	srv, _ := h.server.Get(ctx, r.ServerID)
-	if srv == nil {
+	if !isAdmin(c, h.user, h.config) {
+		u, _ := h.user.GetByUsername(c.Request.Context(), username)
+		if u == nil || u.ID != r.UserID {
+			c.Redirect(http.StatusFound, "/reservations?error=reservation+not+found")
+			return
+		}
+	}
+	end, err := parseDateTimeUTC(c.PostForm("end_time"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error=invalid+end_time")
+		return
+	}
+	updated, err := h.reservation.UpdateEndTime(c.Request.Context(), id, end)
+	if err != nil {
+		if err == services.ErrOverlap || err == services.ErrNotFound || err == services.ErrInvalidEndTime || errors.As(err, new(*services.PolicyViolationError)) {
+			logger.FromContext(c.Request.Context()).Warn("reservation end time change failed", "reservation_id", id, "error", err)
+			c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+			return
+		}
+		logger.FromContext(c.Request.Context()).Error("reservation end time change failed", "reservation_id", id, "error", err)
+		c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
 		return
 	}
-	_ = h.ssh.RemoveKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, usr.SSHPublicKey)
+	logger.FromContext(c.Request.Context()).Info("reservation end time changed", "reservation_id", id, "user_id", r.UserID, "old_end", r.EndTime, "new_end", updated.EndTime)
+	if updated.EndTime.Before(r.EndTime) {
+		promoteWaitlist(c.Request.Context(), h.waitlist, r.ServerID)
+	}
+	c.Redirect(http.StatusFound, "/reservations?success="+url.QueryEscape("Reservation now ends at "+updated.EndTime.UTC().Format("2006-01-02 15:04 UTC")))
+}
+
+func (h *ReservationHandler) revokeAccess(ctx context.Context, r *models.Reservation) {
+	revokeAccess(ctx, h.user, h.server, h.ssh, h.reservation, r)
 }
 
 // AdminAddReservation handles form POST (admin only) - creates reservation for a user
@@ -292,6 +575,15 @@ func (h *ReservationHandler) AdminAddReservation(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/reservations?error=start+time+cannot+be+in+the+past")
 		return
 	}
+	rule, exceptions, err := parseRepeatForm(c)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	if rule != nil {
+		h.createSeries(c, userID, serverID, start, end, *rule, exceptions)
+		return
+	}
 	r, err := h.reservation.Create(c.Request.Context(), userID, serverID, start, end)
 	if err != nil {
 		if err == services.ErrOverlap {
@@ -299,14 +591,223 @@ func (h *ReservationHandler) AdminAddReservation(c *gin.Context) {
 			c.Redirect(http.StatusFound, "/reservations?error=reservation+overlaps")
 			return
 		}
+		var violation *services.PolicyViolationError
+		if errors.As(err, &violation) {
+			logger.FromContext(c.Request.Context()).Warn("admin reservation create failed", "user_id", userID, "server_id", serverID, "error", "policy", "rule", violation.Rule)
+			c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+			return
+		}
 		logger.FromContext(c.Request.Context()).Error("admin reservation create failed", "user_id", userID, "server_id", serverID, "error", err)
 		c.Redirect(http.StatusFound, "/reservations?error="+err.Error())
 		return
 	}
-	logger.FromContext(c.Request.Context()).Info("admin reservation created", "reservation_id", r.ID, "user_id", userID, "server_id", serverID)
+	logger.FromContext(c.Request.Context()).Info("admin reservation created", "reservation_id", r.ID, "user_id", userID, "server_id", serverID, "status", r.Status)
+	if r.Status == "requested" {
+		c.Redirect(http.StatusFound, "/reservations?success=Reservation+requested%3B+it+starts+once+approved")
+		return
+	}
 	c.Redirect(http.StatusFound, "/reservations")
 }
 
+// createSeries books a recurring series and reports booked and conflicting occurrences
+func (h *ReservationHandler) createSeries(c *gin.Context, userID, serverID int64, start, end time.Time, rule services.RecurrenceRule, exceptions []time.Time) {
+	series, occurrences, err := h.reservation.CreateSeries(c.Request.Context(), userID, serverID, start, end, rule, exceptions)
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Warn("reservation series create failed", "user_id", userID, "server_id", serverID, "error", err)
+		c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	booked := 0
+	var conflicts []string
+	for _, o := range occurrences {
+		if o.Error == "" {
+			booked++
+			continue
+		}
+		conflicts = append(conflicts, o.StartTime.UTC().Format("2006-01-02 15:04")+" ("+o.Error+")")
+	}
+	logger.FromContext(c.Request.Context()).Info("reservation series created", "series_id", series.ID, "user_id", userID, "server_id", serverID, "booked", booked, "conflicts", len(conflicts))
+	q := url.Values{}
+	q.Set("success", fmt.Sprintf("Recurring reservation created: %d of %d occurrences booked", booked, len(occurrences)))
+	if len(conflicts) > 0 {
+		q.Set("error", "Not booked: "+strings.Join(conflicts, "; "))
+	}
+	c.Redirect(http.StatusFound, "/reservations?"+q.Encode())
+}
+
+// CancelSeries handles form POST - stops a series and cancels its upcoming occurrences
+func (h *ReservationHandler) CancelSeries(c *gin.Context) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error=invalid+id")
+		return
+	}
+	series, _ := h.reservation.GetSeries(c.Request.Context(), id)
+	if series == nil {
+		c.Redirect(http.StatusFound, "/reservations?error=series+not+found")
+		return
+	}
+	if !isAdmin(c, h.user, h.config) {
+		u, _ := h.user.GetByUsername(c.Request.Context(), username)
+		if u == nil || u.ID != series.UserID {
+			c.Redirect(http.StatusFound, "/reservations?error=series+not+found")
+			return
+		}
+	}
+	if err := h.reservation.CancelSeries(c.Request.Context(), id); err != nil {
+		if err == services.ErrNotFound {
+			c.Redirect(http.StatusFound, "/reservations?error=series+already+cancelled")
+			return
+		}
+		logger.FromContext(c.Request.Context()).Error("cancel reservation series failed", "series_id", id, "error", err)
+		c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("reservation series cancelled", "series_id", id, "user_id", series.UserID)
+	promoteWaitlist(c.Request.Context(), h.waitlist, series.ServerID)
+	c.Redirect(http.StatusFound, "/reservations?success=Recurring+reservation+cancelled")
+}
+
+// waitlistOffer pre-fills the "join waitlist" form after a booking overlapped
+type waitlistOffer struct {
+	ServerID   string
+	ServerName string
+	StartTime  string
+	EndTime    string
+}
+
+// JoinWaitlist handles form POST - queues the current user for a busy server
+func (h *ReservationHandler) JoinWaitlist(c *gin.Context) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	u, err := h.user.GetByUsername(c.Request.Context(), username)
+	if err != nil || u == nil {
+		c.Redirect(http.StatusFound, "/reservations?error=user+not+found")
+		return
+	}
+	serverID, err := strconv.ParseInt(c.PostForm("server_id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error=invalid+server")
+		return
+	}
+	start, err := parseDateTimeUTC(c.PostForm("start_time"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error=invalid+start_time")
+		return
+	}
+	end, err := parseDateTimeUTC(c.PostForm("end_time"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error=invalid+end_time")
+		return
+	}
+	if !end.After(start) {
+		c.Redirect(http.StatusFound, "/reservations?error=end+must+be+after+start")
+		return
+	}
+	if !end.After(time.Now().UTC()) {
+		c.Redirect(http.StatusFound, "/reservations?error=end+time+is+in+the+past")
+		return
+	}
+	w, err := h.waitlist.Join(c.Request.Context(), u.ID, serverID, start, end)
+	if err != nil {
+		if err == services.ErrAlreadyWaiting || err == services.ErrInvalidWaitlistWindow {
+			c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+			return
+		}
+		logger.FromContext(c.Request.Context()).Error("waitlist join failed", "user_id", u.ID, "server_id", serverID, "error", err)
+		c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("waitlist joined", "waitlist_id", w.ID, "user_id", u.ID, "server_id", serverID)
+	// The slot may already have been freed since the booking attempt
+	promoteWaitlist(c.Request.Context(), h.waitlist, serverID)
+	c.Redirect(http.StatusFound, "/reservations?success=Added+to+the+waitlist")
+}
+
+// LeaveWaitlist handles form POST - removes a waiting entry
+func (h *ReservationHandler) LeaveWaitlist(c *gin.Context) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error=invalid+id")
+		return
+	}
+	w, _ := h.waitlist.Get(c.Request.Context(), id)
+	if w == nil {
+		c.Redirect(http.StatusFound, "/reservations?error=waitlist+entry+not+found")
+		return
+	}
+	if !isAdmin(c, h.user, h.config) {
+		u, _ := h.user.GetByUsername(c.Request.Context(), username)
+		if u == nil || u.ID != w.UserID {
+			c.Redirect(http.StatusFound, "/reservations?error=waitlist+entry+not+found")
+			return
+		}
+	}
+	if err := h.waitlist.Cancel(c.Request.Context(), id); err != nil {
+		c.Redirect(http.StatusFound, "/reservations?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("waitlist left", "waitlist_id", id, "user_id", w.UserID)
+	c.Redirect(http.StatusFound, "/reservations?success=Removed+from+the+waitlist")
+}
+
+// parseRepeatForm reads the optional repeat fields of a reservation form.
+// It returns a nil rule when the reservation does not repeat.
+func parseRepeatForm(c *gin.Context) (*services.RecurrenceRule, []time.Time, error) {
+	freq := strings.ToUpper(c.PostForm("repeat"))
+	if freq == "" {
+		return nil, nil, nil
+	}
+	parts := []string{"FREQ=" + freq}
+	if v := c.PostForm("repeat_interval"); v != "" {
+		parts = append(parts, "INTERVAL="+v)
+	}
+	if days := c.PostFormArray("repeat_days"); len(days) > 0 && freq == "WEEKLY" {
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if v := c.PostForm("repeat_count"); v != "" {
+		parts = append(parts, "COUNT="+v)
+	}
+	if v := c.PostForm("repeat_until"); v != "" {
+		parts = append(parts, "UNTIL="+v)
+	}
+	rule, err := services.ParseRecurrenceRule(strings.Join(parts, ";"))
+	if err != nil {
+		return nil, nil, err
+	}
+	exceptions, err := parseDateList(c.PostForm("repeat_except"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &rule, exceptions, nil
+}
+
+// parseDateList parses comma- or space-separated YYYY-MM-DD dates
+func parseDateList(s string) ([]time.Time, error) {
+	var dates []time.Time
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' }) {
+		t, err := time.Parse("2006-01-02", f)
+		if err != nil {
+			return nil, errors.New("invalid exception date " + f + " (use YYYY-MM-DD)")
+		}
+		dates = append(dates, t)
+	}
+	return dates, nil
+}
+
 // parseDateTimeUTC parses datetime-local value as UTC
 func parseDateTimeUTC(s string) (time.Time, error) {
 	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02T15:04:05"} {
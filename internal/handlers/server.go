@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rusik69/serverscheduler/internal/config"
@@ -18,6 +20,7 @@ type ServerWithUsers struct {
 	models.Server
 	Users               []string
 	CurrentReservation  *models.ReservationWithDetails
+	OwnerUserID         int64 // 0 when the server has no owner
 }
 
 // ServerHandler handles server endpoints
@@ -26,12 +29,15 @@ type ServerHandler struct {
 	reservation services.ReservationService
 	ssh        services.SSHService
 	user       services.UserService
+	policy     services.PolicyService
+	reconciler *services.Reconciler
+	ca         *services.CertificateAuthority
 	config     config.Config
 }
 
 // NewServerHandler creates a ServerHandler
-func NewServerHandler(server services.ServerService, res services.ReservationService, ssh services.SSHService, user services.UserService, cfg config.Config) *ServerHandler {
-	return &ServerHandler{server: server, reservation: res, ssh: ssh, user: user, config: cfg}
+func NewServerHandler(server services.ServerService, res services.ReservationService, ssh services.SSHService, user services.UserService, policy services.PolicyService, reconciler *services.Reconciler, ca *services.CertificateAuthority, cfg config.Config) *ServerHandler {
+	return &ServerHandler{server: server, reservation: res, ssh: ssh, user: user, policy: policy, reconciler: reconciler, ca: ca, config: cfg}
 }
 
 // ServersPage renders the servers list
@@ -50,14 +56,36 @@ func (h *ServerHandler) ServersPage(c *gin.Context) {
 			Users:               usersByServer[s.ID],
 			CurrentReservation:  currentByServer[s.ID],
 		}
+		if s.OwnerID != nil {
+			serversWithUsers[i].OwnerUserID = *s.OwnerID
+		}
 	}
 	bd := baseData(c, h.user, h.config, "Servers", "servers")
+	var utilization []models.ServerUtilization
+	var users []models.UserPublic
+	var policies []models.BookingPolicyWithDetails
+	var drift []models.DriftReport
+	if bd.IsAdmin {
+		policies, _ = h.policy.List(c.Request.Context())
+		drift, _ = h.reconciler.Reports(c.Request.Context(), driftPageSize)
+		now := time.Now().UTC()
+		utilization, _ = h.reservation.Utilization(c.Request.Context(), now.Add(-defaultReportPeriod), now)
+		users, _ = h.user.List(c.Request.Context())
+	}
 	data := struct {
 		templates.BaseData
-		Servers []ServerWithUsers
-		Error   string
-		Success string
-	}{BaseData: bd, Servers: serversWithUsers, Error: c.Query("error"), Success: c.Query("success")}
+		Servers     []ServerWithUsers
+		Utilization []models.ServerUtilization
+		Users       []models.UserPublic
+		Policies    []models.BookingPolicyWithDetails
+		Drift       []models.DriftReport
+		CAPublicKey string
+		Error       string
+		Success     string
+	}{BaseData: bd, Servers: serversWithUsers, Utilization: utilization, Users: users, Policies: policies, Drift: drift, Error: c.Query("error"), Success: c.Query("success")}
+	if bd.IsAdmin {
+		data.CAPublicKey = h.ca.PublicKey()
+	}
 	render(c, "servers", data)
 }
 
@@ -71,7 +99,11 @@ func (h *ServerHandler) AddServer(c *gin.Context) {
 	hostname := c.PostForm("hostname")
 	sshUser := c.PostForm("ssh_user")
 	sshKey := c.PostForm("ssh_private_key")
-	if name == "" || hostname == "" || sshUser == "" || sshKey == "" {
+	generateKey := c.PostForm("generate_key") != ""
+	if generateKey {
+		sshKey = ""
+	}
+	if name == "" || hostname == "" || sshUser == "" || (sshKey == "" && !generateKey) {
 		c.Redirect(http.StatusFound, "/servers?error=name+hostname+ssh_user+and+ssh_private_key+required")
 		return
 	}
@@ -89,21 +121,227 @@ func (h *ServerHandler) AddServer(c *gin.Context) {
 		SSHPrivateKey: sshKey,
 		Description:   c.PostForm("description"),
 	}
-	if err := h.ssh.TestConnection(c.Request.Context(), hostname, port, sshUser, sshKey); err != nil {
-		logger.FromContext(c.Request.Context()).Error("add server SSH test failed", "name", name, "error", err)
-		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape("SSH connection failed: "+err.Error()))
+	ownerID, err := parseOptionalID(c.PostForm("owner_id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+owner")
 		return
 	}
+	srv.RequiresApproval = c.PostForm("requires_approval") != ""
+	srv.OwnerID = ownerID
+	srv.AccessMode = c.PostForm("access_mode")
+	if srv.AccessMode != "" && !services.ValidAccessMode(srv.AccessMode) {
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(services.ErrInvalidAccessMode.Error()))
+		return
+	}
+	// A generated key cannot work before the admin installs it, so it is
+	// tested later with the Test button
+	if !generateKey {
+		if err := h.ssh.TestConnection(c.Request.Context(), hostname, port, sshUser, sshKey); err != nil {
+			logger.FromContext(c.Request.Context()).Error("add server SSH test failed", "name", name, "error", err)
+			c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape("SSH connection failed: "+err.Error()))
+			return
+		}
+	}
 	created, err := h.server.Create(c.Request.Context(), srv)
 	if err != nil {
 		logger.FromContext(c.Request.Context()).Error("add server failed", "name", name, "error", err)
 		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(err.Error()))
 		return
 	}
-	logger.FromContext(c.Request.Context()).Info("server added", "server_id", created.ID, "name", name)
+	logger.FromContext(c.Request.Context()).Info("server added", "server_id", created.ID, "name", name, "generated_key", generateKey)
+	if generateKey {
+		c.Redirect(http.StatusFound, "/servers?success="+url.QueryEscape("Server added. Append its public key to ~"+sshUser+"/.ssh/authorized_keys on "+hostname+", then click Test: "+created.SSHPublicKey))
+		return
+	}
 	c.Redirect(http.StatusFound, "/servers")
 }
 
+// EditServer handles form POST - changes a server's name, connection settings,
+// description or key (admin only). The connection is tested again when any of
+// those change, and access of active reservations follows a new host or SSH user.
+func (h *ServerHandler) EditServer(c *gin.Context) {
+	if !isAdmin(c, h.user, h.config) {
+		c.Redirect(http.StatusFound, "/servers?error=admin+required")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+id")
+		return
+	}
+	srv, err := h.server.Get(c.Request.Context(), id)
+	if err != nil || srv == nil {
+		c.Redirect(http.StatusFound, "/servers?error=server+not+found")
+		return
+	}
+	updated := *srv
+	updated.Name = c.PostForm("name")
+	updated.Hostname = c.PostForm("hostname")
+	updated.SSHUser = c.PostForm("ssh_user")
+	updated.Description = c.PostForm("description")
+	if updated.Name == "" || updated.Hostname == "" || updated.SSHUser == "" {
+		c.Redirect(http.StatusFound, "/servers?error=name+hostname+and+ssh_user+required")
+		return
+	}
+	if updated.Port, err = strconv.Atoi(c.PostForm("port")); err != nil || updated.Port < 1 || updated.Port > 65535 {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+port")
+		return
+	}
+	newKey := c.PostForm("ssh_private_key")
+	if newKey != "" {
+		updated.SSHPrivateKey = newKey
+	}
+	if newKey != "" || !services.SameTarget(srv, &updated) {
+		if err := h.ssh.TestConnection(c.Request.Context(), updated.Hostname, updated.Port, updated.SSHUser, updated.SSHPrivateKey); err != nil {
+			logger.FromContext(c.Request.Context()).Error("edit server SSH test failed", "server_id", id, "error", err)
+			c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape("SSH connection failed, server not changed: "+err.Error()))
+			return
+		}
+	}
+	// Only a new key is written; an empty one keeps the stored key
+	updated.SSHPrivateKey = newKey
+	saved, err := h.server.Update(c.Request.Context(), &updated)
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Error("edit server failed", "server_id", id, "error", err)
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("server updated", "server_id", id, "name", saved.Name, "new_key", newKey != "")
+	msg := "Server " + saved.Name + " saved"
+	if err := services.MoveActiveKeys(c.Request.Context(), h.reservation, h.user, h.ssh, srv, saved); err != nil {
+		logger.FromContext(c.Request.Context()).Warn("moving active reservation access failed", "server_id", id, "error", err)
+		msg += "; moving access of active reservations failed: " + err.Error()
+	}
+	c.Redirect(http.StatusFound, "/servers?success="+url.QueryEscape(msg))
+}
+
+// RotateKey handles form POST - replaces the scheduler's key for a server
+// with a newly generated one (admin only)
+func (h *ServerHandler) RotateKey(c *gin.Context) {
+	if !isAdmin(c, h.user, h.config) {
+		c.Redirect(http.StatusFound, "/servers?error=admin+required")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+id")
+		return
+	}
+	srv, err := h.server.Get(c.Request.Context(), id)
+	if err != nil || srv == nil {
+		c.Redirect(http.StatusFound, "/servers?error=server+not+found")
+		return
+	}
+	removed, err := services.RotateServerKey(c.Request.Context(), h.server, h.ssh, srv)
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Error("server key rotation failed", "server_id", id, "name", srv.Name, "error", err)
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape("Key rotation failed, the old key is still in use: "+err.Error()))
+		return
+	}
+	msg := "Key of " + srv.Name + " rotated"
+	if !removed {
+		msg += "; the old key is still in authorized_keys, remove it by hand"
+	}
+	c.Redirect(http.StatusFound, "/servers?success="+url.QueryEscape(msg))
+}
+
+// SetApproval handles form POST - toggles the approval requirement and owner (admin only)
+func (h *ServerHandler) SetApproval(c *gin.Context) {
+	if !isAdmin(c, h.user, h.config) {
+		c.Redirect(http.StatusFound, "/servers?error=admin+required")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+id")
+		return
+	}
+	srv, err := h.server.Get(c.Request.Context(), id)
+	if err != nil || srv == nil {
+		c.Redirect(http.StatusFound, "/servers?error=server+not+found")
+		return
+	}
+	ownerID, err := parseOptionalID(c.PostForm("owner_id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+owner")
+		return
+	}
+	requiresApproval := c.PostForm("requires_approval") != ""
+	if err := h.server.SetApproval(c.Request.Context(), id, requiresApproval, ownerID); err != nil {
+		logger.FromContext(c.Request.Context()).Error("set server approval failed", "server_id", id, "error", err)
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("server approval updated", "server_id", id, "requires_approval", requiresApproval)
+	c.Redirect(http.StatusFound, "/servers?success="+url.QueryEscape("Approval settings saved for "+srv.Name))
+}
+
+// SetAccessMode handles form POST - switches between authorized_keys and
+// certificate access (admin only)
+func (h *ServerHandler) SetAccessMode(c *gin.Context) {
+	if !isAdmin(c, h.user, h.config) {
+		c.Redirect(http.StatusFound, "/servers?error=admin+required")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+id")
+		return
+	}
+	srv, err := h.server.Get(c.Request.Context(), id)
+	if err != nil || srv == nil {
+		c.Redirect(http.StatusFound, "/servers?error=server+not+found")
+		return
+	}
+	mode := c.PostForm("access_mode")
+	if err := h.server.SetAccessMode(c.Request.Context(), id, mode); err != nil {
+		logger.FromContext(c.Request.Context()).Error("set server access mode failed", "server_id", id, "access_mode", mode, "error", err)
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("server access mode updated", "server_id", id, "access_mode", mode)
+	c.Redirect(http.StatusFound, "/servers?success="+url.QueryEscape("Access mode of "+srv.Name+" set to "+mode))
+}
+
+// SetSessionPolicy handles form POST - sets what happens to live SSH sessions
+// when a reservation on the server ends (admin only)
+func (h *ServerHandler) SetSessionPolicy(c *gin.Context) {
+	if !isAdmin(c, h.user, h.config) {
+		c.Redirect(http.StatusFound, "/servers?error=admin+required")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+id")
+		return
+	}
+	srv, err := h.server.Get(c.Request.Context(), id)
+	if err != nil || srv == nil {
+		c.Redirect(http.StatusFound, "/servers?error=server+not+found")
+		return
+	}
+	policy := c.PostForm("session_policy")
+	if err := h.server.SetSessionPolicy(c.Request.Context(), id, policy); err != nil {
+		logger.FromContext(c.Request.Context()).Error("set server session policy failed", "server_id", id, "session_policy", policy, "error", err)
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("server session policy updated", "server_id", id, "session_policy", policy)
+	c.Redirect(http.StatusFound, "/servers?success="+url.QueryEscape("Session policy of "+srv.Name+" set to "+policy))
+}
+
+// parseOptionalID reads an optional ID form value; empty means none
+func parseOptionalID(s string) (*int64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
 // TestServer handles form POST - tests SSH connection
 func (h *ServerHandler) TestServer(c *gin.Context) {
 	if !isAdmin(c, h.user, h.config) {
@@ -129,8 +367,9 @@ func (h *ServerHandler) TestServer(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/servers?success=SSH+connection+OK")
 }
 
-// DeleteServer handles form POST
-func (h *ServerHandler) DeleteServer(c *gin.Context) {
+// CheckHostKey handles form POST - shows the host key the server presents now
+// next to the trusted one (admin only)
+func (h *ServerHandler) CheckHostKey(c *gin.Context) {
 	if !isAdmin(c, h.user, h.config) {
 		c.Redirect(http.StatusFound, "/servers?error=admin+required")
 		return
@@ -140,11 +379,84 @@ func (h *ServerHandler) DeleteServer(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/servers?error=invalid+id")
 		return
 	}
-	if err := h.server.Delete(c.Request.Context(), id); err != nil {
-		logger.FromContext(c.Request.Context()).Error("delete server failed", "server_id", id, "error", err)
-		c.Redirect(http.StatusFound, "/servers?error="+err.Error())
+	srv, err := h.server.Get(c.Request.Context(), id)
+	if err != nil || srv == nil {
+		c.Redirect(http.StatusFound, "/servers?error=server+not+found")
 		return
 	}
-	logger.FromContext(c.Request.Context()).Info("server deleted", "server_id", id)
-	c.Redirect(http.StatusFound, "/servers")
+	presented, err := h.ssh.HostKey(c.Request.Context(), srv.Hostname, srv.Port)
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Error("host key check failed", "server_id", id, "name", srv.Name, "error", err)
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape("Host key check failed: "+err.Error()))
+		return
+	}
+	msg := srv.Name + " presents host key " + presented
+	switch srv.HostKeyFingerprint {
+	case presented:
+		msg += ", which is the trusted key"
+	case "":
+		msg += "; no key is trusted yet"
+	default:
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(msg+", but "+srv.HostKeyFingerprint+" is trusted. Re-trust it only if the server was rebuilt."))
+		return
+	}
+	c.Redirect(http.StatusFound, "/servers?success="+url.QueryEscape(msg))
+}
+
+// TrustHostKey handles form POST - trusts the host key the server presents now
+// after a rebuild. The admin must enter its fingerprint (admin only).
+func (h *ServerHandler) TrustHostKey(c *gin.Context) {
+	if !isAdmin(c, h.user, h.config) {
+		c.Redirect(http.StatusFound, "/servers?error=admin+required")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+id")
+		return
+	}
+	srv, err := h.server.Get(c.Request.Context(), id)
+	if err != nil || srv == nil {
+		c.Redirect(http.StatusFound, "/servers?error=server+not+found")
+		return
+	}
+	fingerprint := c.PostForm("fingerprint")
+	if fingerprint == "" {
+		c.Redirect(http.StatusFound, "/servers?error=fingerprint+required")
+		return
+	}
+	if err := h.ssh.TrustHostKey(c.Request.Context(), srv.Hostname, srv.Port, fingerprint); err != nil {
+		logger.FromContext(c.Request.Context()).Error("trust host key failed", "server_id", id, "name", srv.Name, "error", err)
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("host key re-trusted", "server_id", id, "name", srv.Name, "fingerprint", fingerprint, "previous", srv.HostKeyFingerprint)
+	c.Redirect(http.StatusFound, "/servers?success="+url.QueryEscape("Host key "+fingerprint+" is now trusted for "+srv.Name))
+}
+
+// DecommissionServer handles form POST - retires a server, cancelling its
+// open reservations and revoking active access (admin only)
+func (h *ServerHandler) DecommissionServer(c *gin.Context) {
+	if !isAdmin(c, h.user, h.config) {
+		c.Redirect(http.StatusFound, "/servers?error=admin+required")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/servers?error=invalid+id")
+		return
+	}
+	srv, err := h.server.Get(c.Request.Context(), id)
+	if err != nil || srv == nil {
+		c.Redirect(http.StatusFound, "/servers?error=server+not+found")
+		return
+	}
+	cancelled, err := services.DecommissionServer(c.Request.Context(), h.server, h.reservation, h.user, h.ssh, srv)
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Error("decommission server failed", "server_id", id, "error", err)
+		c.Redirect(http.StatusFound, "/servers?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("server decommissioned", "server_id", id, "cancelled", len(cancelled))
+	c.Redirect(http.StatusFound, "/servers?success="+url.QueryEscape(fmt.Sprintf("%s decommissioned; %d reservation(s) cancelled", srv.Name, len(cancelled))))
 }
@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rusik69/serverscheduler/internal/config"
@@ -20,19 +23,21 @@ type UserHandler struct {
 	reservation services.ReservationService
 	server      services.ServerService
 	ssh         services.SSHService
+	tokens      services.APITokenService
+	waitlist    services.WaitlistService
 	config      config.Config
 }
 
 // NewUserHandler creates a UserHandler
-func NewUserHandler(user services.UserService, res services.ReservationService, srv services.ServerService, ssh services.SSHService, cfg config.Config) *UserHandler {
-	return &UserHandler{user: user, reservation: res, server: srv, ssh: ssh, config: cfg}
+func NewUserHandler(user services.UserService, res services.ReservationService, srv services.ServerService, ssh services.SSHService, tokens services.APITokenService, waitlist services.WaitlistService, cfg config.Config) *UserHandler {
+	return &UserHandler{user: user, reservation: res, server: srv, ssh: ssh, tokens: tokens, waitlist: waitlist, config: cfg}
 }
 
 // ProfileData for template
 type ProfileData struct {
-	Username     string
-	Role         string
-	SSHPublicKey string
+	Username string
+	Role     string
+	SSHKeys  []models.SSHKey
 }
 
 // ProfilePage renders the profile page
@@ -43,27 +48,130 @@ func (h *UserHandler) ProfilePage(c *gin.Context) {
 		return
 	}
 	logger.FromContext(c.Request.Context()).Debug("profile page load", "username", username)
+	h.renderProfile(c, username, "")
+}
+
+// renderProfile renders the profile page. newToken is shown once right after creation.
+func (h *UserHandler) renderProfile(c *gin.Context, username, newToken string) {
 	bd := baseData(c, h.user, h.config, "Profile", "profile")
 	profile := ProfileData{Username: username, Role: "admin"}
+	var tokens []models.APIToken
+	canUseTokens := false
 	if username != h.config.AdminUsername {
 		u, err := h.user.GetByUsername(c.Request.Context(), username)
 		if err != nil || u == nil {
 			c.Redirect(http.StatusFound, "/login")
 			return
 		}
-		profile = ProfileData{Username: u.Username, Role: u.Role, SSHPublicKey: u.SSHPublicKey}
+		keys, _ := h.user.ListSSHKeys(c.Request.Context(), u.ID)
+		profile = ProfileData{Username: u.Username, Role: u.Role, SSHKeys: keys}
+		tokens, _ = h.tokens.List(c.Request.Context(), u.ID)
+		canUseTokens = true
+	}
+	currentSessionID := ""
+	if cookie, _ := c.Cookie("session_id"); cookie != "" {
+		currentSessionID = middleware.HashSessionID(cookie)
 	}
 	data := struct {
 		templates.BaseData
-		Profile ProfileData
-		Error   string
-		Success string
-	}{BaseData: bd, Profile: profile, Error: c.Query("error"), Success: c.Query("success")}
+		Profile          ProfileData
+		Tokens           []models.APIToken
+		CanUseTokens     bool
+		NewToken         string
+		Sessions         []middleware.Session
+		CurrentSessionID string
+		Error            string
+		Success          string
+	}{
+		BaseData: bd, Profile: profile, Tokens: tokens, CanUseTokens: canUseTokens, NewToken: newToken,
+		Sessions: middleware.GetSessionStore().ListUserSessions(username), CurrentSessionID: currentSessionID,
+		Error: c.Query("error"), Success: c.Query("success"),
+	}
 	render(c, "profile", data)
 }
 
-// UpdateSSHKey handles form POST
-func (h *UserHandler) UpdateSSHKey(c *gin.Context) {
+// CreateToken handles form POST - issues a personal API token and shows it once
+func (h *UserHandler) CreateToken(c *gin.Context) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	if username == h.config.AdminUsername {
+		c.Redirect(http.StatusFound, "/profile?error=admin+cannot+create+API+tokens")
+		return
+	}
+	u, err := h.user.GetByUsername(c.Request.Context(), username)
+	if err != nil || u == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	var expiresAt *time.Time
+	if days := c.PostForm("expires_in_days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			c.Redirect(http.StatusFound, "/profile?error=invalid+expiry")
+			return
+		}
+		t := time.Now().UTC().AddDate(0, 0, n)
+		expiresAt = &t
+	}
+	token, t, err := h.tokens.Create(c.Request.Context(), u.ID, c.PostForm("name"), expiresAt)
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Warn("create API token failed", "username", username, "error", err)
+		c.Redirect(http.StatusFound, "/profile?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("API token created", "username", username, "token_id", t.ID)
+	h.renderProfile(c, username, token)
+}
+
+// RevokeToken handles form POST - deletes one of the current user's API tokens
+func (h *UserHandler) RevokeToken(c *gin.Context) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/profile?error=invalid+id")
+		return
+	}
+	u, err := h.user.GetByUsername(c.Request.Context(), username)
+	if err != nil || u == nil {
+		c.Redirect(http.StatusFound, "/profile?error=token+not+found")
+		return
+	}
+	if err := h.tokens.Revoke(c.Request.Context(), id, u.ID); err != nil {
+		c.Redirect(http.StatusFound, "/profile?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("API token revoked", "username", username, "token_id", id)
+	c.Redirect(http.StatusFound, "/profile?success=Token+revoked")
+}
+
+// RevokeSession handles form POST - signs out one of the current user's sessions
+func (h *UserHandler) RevokeSession(c *gin.Context) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	id := c.Param("id")
+	for _, session := range middleware.GetSessionStore().ListUserSessions(username) {
+		if session.ID == id {
+			middleware.GetSessionStore().RevokeSession(id)
+			logger.FromContext(c.Request.Context()).Info("session revoked", "username", username)
+			c.Redirect(http.StatusFound, "/profile?success=Session+signed+out")
+			return
+		}
+	}
+	c.Redirect(http.StatusFound, "/profile?error=session+not+found")
+}
+
+// AddSSHKey handles form POST - registers another SSH key for the current user
+func (h *UserHandler) AddSSHKey(c *gin.Context) {
 	username, ok := middleware.GetCurrentUser(c)
 	if !ok {
 		c.Redirect(http.StatusFound, "/login")
@@ -73,14 +181,69 @@ func (h *UserHandler) UpdateSSHKey(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/profile?error=admin+cannot+set+SSH+key")
 		return
 	}
-	sshKey := c.PostForm("ssh_public_key")
-	if err := h.user.UpdateSSHKey(c.Request.Context(), username, sshKey); err != nil {
-		logger.FromContext(c.Request.Context()).Error("update SSH key failed", "username", username, "error", err)
-		c.Redirect(http.StatusFound, "/profile?error="+err.Error())
+	u, err := h.user.GetByUsername(c.Request.Context(), username)
+	if err != nil || u == nil {
+		c.Redirect(http.StatusFound, "/profile?error=user+not+found")
+		return
+	}
+	before, err := h.user.ListSSHKeys(c.Request.Context(), u.ID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/profile?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	k, err := h.user.AddSSHKey(c.Request.Context(), u.ID, c.PostForm("name"), c.PostForm("ssh_public_key"))
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Warn("add SSH key failed", "username", username, "error", err)
+		c.Redirect(http.StatusFound, "/profile?error="+url.QueryEscape(err.Error()))
 		return
 	}
-	logger.FromContext(c.Request.Context()).Info("SSH key updated", "username", username)
-	c.Redirect(http.StatusFound, "/profile?success=Saved")
+	logger.FromContext(c.Request.Context()).Info("SSH key added", "username", username, "key_id", k.ID, "fingerprint", k.Fingerprint)
+	h.syncActiveKeys(c, u, before, "Key added")
+}
+
+// DeleteSSHKey handles form POST - removes one of the current user's SSH keys
+func (h *UserHandler) DeleteSSHKey(c *gin.Context) {
+	username, ok := middleware.GetCurrentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/profile?error=invalid+id")
+		return
+	}
+	u, err := h.user.GetByUsername(c.Request.Context(), username)
+	if err != nil || u == nil {
+		c.Redirect(http.StatusFound, "/profile?error=SSH+key+not+found")
+		return
+	}
+	before, err := h.user.ListSSHKeys(c.Request.Context(), u.ID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/profile?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	if _, err := h.user.DeleteSSHKey(c.Request.Context(), id, u.ID); err != nil {
+		c.Redirect(http.StatusFound, "/profile?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	logger.FromContext(c.Request.Context()).Info("SSH key removed", "username", username, "key_id", id)
+	h.syncActiveKeys(c, u, before, "Key removed")
+}
+
+// syncActiveKeys applies a change to the user's keys to their active
+// reservations and redirects back to the profile
+func (h *UserHandler) syncActiveKeys(c *gin.Context, u *models.User, before []models.SSHKey, success string) {
+	after, err := h.user.ListSSHKeys(c.Request.Context(), u.ID)
+	if err == nil {
+		err = services.SyncActiveKeys(c.Request.Context(), h.reservation, h.server, h.user, h.ssh, u, before, after)
+	}
+	if err != nil {
+		logger.FromContext(c.Request.Context()).Warn("updating keys on active reservations failed", "username", u.Username, "error", err)
+		c.Redirect(http.StatusFound, "/profile?error="+url.QueryEscape(success+", but your active reservations were not fully updated: "+err.Error()))
+		return
+	}
+	c.Redirect(http.StatusFound, "/profile?success="+url.QueryEscape(success))
 }
 
 // UsersPage renders the users list (admin only)
@@ -95,14 +258,29 @@ func (h *UserHandler) UsersPage(c *gin.Context) {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	sessionCounts := make(map[string]int, len(list))
+	for _, u := range list {
+		sessionCounts[u.Username] = len(middleware.GetSessionStore().ListUserSessions(u.Username))
+	}
+	keys, err := h.user.ListAllSSHKeys(c.Request.Context())
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	sshKeys := make(map[int64][]models.SSHKey)
+	for _, k := range keys {
+		sshKeys[k.UserID] = append(sshKeys[k.UserID], k)
+	}
 	bd := baseData(c, h.user, h.config, "Users", "users")
 	data := struct {
 		templates.BaseData
 		Users         interface{}
+		SessionCounts map[string]int
+		SSHKeys       map[int64][]models.SSHKey
 		AdminUsername string
 		Error         string
 		Success       string
-	}{BaseData: bd, Users: list, AdminUsername: h.config.AdminUsername, Error: c.Query("error"), Success: c.Query("success")}
+	}{BaseData: bd, Users: list, SessionCounts: sessionCounts, SSHKeys: sshKeys, AdminUsername: h.config.AdminUsername, Error: c.Query("error"), Success: c.Query("success")}
 	render(c, "users", data)
 }
 
@@ -131,32 +309,54 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/users?error=cannot+delete+yourself")
 		return
 	}
-	// Revoke SSH access for active reservations, then delete all reservations for this user
+	// Delete all reservations for this user, then revoke SSH access of the
+	// active ones, which the reconciler no longer restores by then
 	userID := &u.ID
 	reservations, _ := h.reservation.List(c.Request.Context(), userID)
+	_ = h.reservation.DeleteByUserID(c.Request.Context(), u.ID)
+	freed := map[int64]bool{}
 	for _, r := range reservations {
 		if r.Status == "active" {
 			h.revokeAccess(c.Request.Context(), &r.Reservation)
 		}
+		if r.Status == "active" || r.Status == "pending" {
+			freed[r.ServerID] = true
+		}
 	}
-	_ = h.reservation.DeleteByUserID(c.Request.Context(), u.ID)
 	if err := h.user.Delete(c.Request.Context(), id); err != nil {
 		logger.FromContext(c.Request.Context()).Error("delete user failed", "user_id", id, "username", u.Username, "error", err)
 		c.Redirect(http.StatusFound, "/users?error="+err.Error())
 		return
 	}
-	logger.FromContext(c.Request.Context()).Info("user deleted", "user_id", id, "username", u.Username)
+	for serverID := range freed {
+		promoteWaitlist(c.Request.Context(), h.waitlist, serverID)
+	}
+	killed := middleware.GetSessionStore().DeleteUserSessions(u.Username)
+	logger.FromContext(c.Request.Context()).Info("user deleted", "user_id", id, "username", u.Username, "sessions_revoked", killed)
 	c.Redirect(http.StatusFound, "/users?success=User+removed")
 }
 
-func (h *UserHandler) revokeAccess(ctx context.Context, r *models.Reservation) {
-	usr, _ := h.user.GetByID(ctx, r.UserID)
-	if usr == nil || usr.SSHPublicKey == "" {
+// LogoutUser handles form POST (admin only) - signs a user out of every session
+func (h *UserHandler) LogoutUser(c *gin.Context) {
+	if !isAdmin(c, h.user, h.config) {
+		c.Redirect(http.StatusFound, "/users?error=admin+required")
 		return
 	}
-	srv, _ := h.server.Get(ctx, r.ServerID)
-	if srv == nil {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/users?error=invalid+id")
 		return
 	}
-	_ = h.ssh.RemoveKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, usr.SSHPublicKey)
+	u, err := h.user.GetByID(c.Request.Context(), id)
+	if err != nil || u == nil {
+		c.Redirect(http.StatusFound, "/users?error=user+not+found")
+		return
+	}
+	killed := middleware.GetSessionStore().DeleteUserSessions(u.Username)
+	logger.FromContext(c.Request.Context()).Info("user sessions revoked by admin", "user_id", id, "username", u.Username, "sessions_revoked", killed)
+	c.Redirect(http.StatusFound, "/users?success="+url.QueryEscape(fmt.Sprintf("Signed out %d session(s) of %s", killed, u.Username)))
+}
+
+func (h *UserHandler) revokeAccess(ctx context.Context, r *models.Reservation) {
+	revokeAccess(ctx, h.user, h.server, h.ssh, h.reservation, r)
 }
@@ -1,113 +1,86 @@
 package middleware
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"net/http"
-	"sync"
-	"time"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/logger"
+	"github.com/rusik69/serverscheduler/internal/services"
 )
 
-// Session represents an authenticated session
-type Session struct {
-	Username  string
-	ExpiresAt time.Time
-}
-
-// SessionStore manages active sessions
-type SessionStore struct {
-	sessions map[string]*Session
-	mu       sync.RWMutex
-}
-
-var globalSessionStore = &SessionStore{sessions: make(map[string]*Session)}
-
-// GetSessionStore returns the global session store
-func GetSessionStore() *SessionStore {
-	return globalSessionStore
-}
-
-func init() {
-	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
-		for range ticker.C {
-			globalSessionStore.Cleanup()
-		}
-	}()
-}
-
-// GenerateSessionID generates a random session ID
-func GenerateSessionID() string {
-	b := make([]byte, 32)
-	rand.Read(b)
-	return base64.URLEncoding.EncodeToString(b)
-}
-
-// SetSession creates a new session
-func (s *SessionStore) SetSession(sessionID string, username string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.sessions[sessionID] = &Session{Username: username, ExpiresAt: time.Now().Add(24 * time.Hour)}
-}
-
-// GetSession retrieves a session by ID
-func (s *SessionStore) GetSession(sessionID string) (*Session, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	session, exists := s.sessions[sessionID]
-	if !exists || time.Now().After(session.ExpiresAt) {
-		return nil, false
-	}
-	return session, true
-}
-
-// DeleteSession removes a session
-func (s *SessionStore) DeleteSession(sessionID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.sessions, sessionID)
-}
-
-// Cleanup removes expired sessions
-func (s *SessionStore) Cleanup() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	now := time.Now()
-	for id, session := range s.sessions {
-		if now.After(session.ExpiresAt) {
-			delete(s.sessions, id)
-		}
-	}
-}
-
-// AuthMiddleware handles session-based authentication
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware handles session-based and API token authentication.
+// A request carrying "Authorization: Bearer <token>" is resolved through tokens
+// and never falls back to the session cookie.
+func AuthMiddleware(tokens services.APITokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if token, ok := bearerToken(c); ok {
+			u, err := tokens.Authenticate(c.Request.Context(), token)
+			if err != nil {
+				if isPublicEndpoint(c.Request.URL.Path, c.Request.Method) {
+					c.Next()
+					return
+				}
+				logger.FromContext(c.Request.Context()).Warn("API token rejected", "path", c.Request.URL.Path, "error", err)
+				unauthorized(c)
+				return
+			}
+			c.Set("username", u.Username)
+			c.Set("authenticated", true)
+			c.Set("auth_method", "token")
+			c.Next()
+			return
+		}
 		if isPublicEndpoint(c.Request.URL.Path, c.Request.Method) {
 			c.Next()
 			return
 		}
 		sessionID, err := c.Cookie("session_id")
 		if err != nil || sessionID == "" {
-			c.Redirect(http.StatusFound, "/login")
-			c.Abort()
+			unauthorized(c)
 			return
 		}
 		session, exists := globalSessionStore.GetSession(sessionID)
 		if !exists {
-			c.Redirect(http.StatusFound, "/login")
-			c.Abort()
+			unauthorized(c)
 			return
 		}
+		// Keep the cookie in step with the sliding server-side expiry
+		c.SetCookie("session_id", sessionID, int(SessionTTL.Seconds()), "/", "", false, false)
 		c.Set("username", session.Username)
 		c.Set("authenticated", true)
+		c.Set("auth_method", "session")
 		c.Next()
 	}
 }
 
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) (string, bool) {
+	h := c.GetHeader("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(h[7:])
+	return token, token != ""
+}
+
+// unauthorized redirects browsers to the login page and answers API clients with 401
+func unauthorized(c *gin.Context) {
+	if IsAPIRequest(c) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": gin.H{"code": "unauthorized", "message": "authentication required"},
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, "/login")
+	c.Abort()
+}
+
+// IsAPIRequest reports whether the request targets the JSON API
+func IsAPIRequest(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, "/api/")
+}
+
 func isPublicEndpoint(path, method string) bool {
 	_ = method
 	public := []string{"/", "/servers", "/login", "/register", "/logout", "/toggle-theme", "/ping", "/health"}
@@ -0,0 +1,258 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionTTL is how long a session stays valid without activity
+const SessionTTL = 24 * time.Hour
+
+// sessionTouchInterval limits how often a session's sliding expiry is written back
+const sessionTouchInterval = time.Minute
+
+// Session represents an authenticated session.
+// ID is a hash of the cookie value, so it is safe to list and pass around.
+type Session struct {
+	ID         string
+	Username   string
+	ClientIP   string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// SessionBackend persists sessions for a SessionStore
+type SessionBackend interface {
+	Save(s *Session) error
+	Get(id string) (*Session, error)
+	Touch(id string, lastSeenAt, expiresAt time.Time) error
+	Delete(id string) error
+	DeleteByUsername(username string) (int, error)
+	ListByUsername(username string) ([]Session, error)
+	DeleteExpired(now time.Time) error
+}
+
+// SessionStore manages active sessions on top of a SessionBackend
+type SessionStore struct {
+	backend SessionBackend
+	mu      sync.RWMutex
+}
+
+var globalSessionStore = &SessionStore{backend: NewMemorySessionBackend()}
+
+// GetSessionStore returns the global session store
+func GetSessionStore() *SessionStore {
+	return globalSessionStore
+}
+
+// SetSessionBackend replaces the backend of the global session store
+func SetSessionBackend(b SessionBackend) {
+	globalSessionStore.mu.Lock()
+	defer globalSessionStore.mu.Unlock()
+	globalSessionStore.backend = b
+}
+
+func init() {
+	go func() {
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			globalSessionStore.Cleanup()
+		}
+	}()
+}
+
+// GenerateSessionID generates a random session ID
+func GenerateSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+// HashSessionID returns the stored ID of a session cookie value
+func HashSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *SessionStore) getBackend() SessionBackend {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.backend
+}
+
+// SetSession creates a new session
+func (s *SessionStore) SetSession(sessionID, username, clientIP, userAgent string) {
+	now := time.Now().UTC()
+	session := &Session{
+		ID:         HashSessionID(sessionID),
+		Username:   username,
+		ClientIP:   clientIP,
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(SessionTTL),
+	}
+	if err := s.getBackend().Save(session); err != nil {
+		slog.Error("session save failed", "username", username, "error", err)
+	}
+}
+
+// GetSession retrieves a session by cookie value and slides its expiry forward
+func (s *SessionStore) GetSession(sessionID string) (*Session, bool) {
+	b := s.getBackend()
+	session, err := b.Get(HashSessionID(sessionID))
+	if err != nil {
+		slog.Error("session lookup failed", "error", err)
+		return nil, false
+	}
+	now := time.Now().UTC()
+	if session == nil || now.After(session.ExpiresAt) {
+		return nil, false
+	}
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		session.LastSeenAt = now
+		session.ExpiresAt = now.Add(SessionTTL)
+		if err := b.Touch(session.ID, session.LastSeenAt, session.ExpiresAt); err != nil {
+			slog.Warn("session touch failed", "username", session.Username, "error", err)
+		}
+	}
+	return session, true
+}
+
+// DeleteSession removes a session by cookie value
+func (s *SessionStore) DeleteSession(sessionID string) {
+	s.RevokeSession(HashSessionID(sessionID))
+}
+
+// RevokeSession removes a session by its stored ID
+func (s *SessionStore) RevokeSession(id string) {
+	if err := s.getBackend().Delete(id); err != nil {
+		slog.Error("session delete failed", "error", err)
+	}
+}
+
+// DeleteUserSessions logs a user out everywhere and returns how many sessions were removed
+func (s *SessionStore) DeleteUserSessions(username string) int {
+	n, err := s.getBackend().DeleteByUsername(username)
+	if err != nil {
+		slog.Error("session delete by user failed", "username", username, "error", err)
+	}
+	return n
+}
+
+// ListUserSessions returns a user's unexpired sessions, most recently used first
+func (s *SessionStore) ListUserSessions(username string) []Session {
+	list, err := s.getBackend().ListByUsername(username)
+	if err != nil {
+		slog.Error("session list failed", "username", username, "error", err)
+		return nil
+	}
+	now := time.Now().UTC()
+	active := list[:0]
+	for _, session := range list {
+		if now.Before(session.ExpiresAt) {
+			active = append(active, session)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].LastSeenAt.After(active[j].LastSeenAt) })
+	return active
+}
+
+// Cleanup removes expired sessions
+func (s *SessionStore) Cleanup() {
+	if err := s.getBackend().DeleteExpired(time.Now().UTC()); err != nil {
+		slog.Error("session cleanup failed", "error", err)
+	}
+}
+
+// MemorySessionBackend keeps sessions in process memory. Sessions are lost on restart.
+type MemorySessionBackend struct {
+	sessions map[string]*Session
+	mu       sync.RWMutex
+}
+
+// NewMemorySessionBackend creates a MemorySessionBackend
+func NewMemorySessionBackend() *MemorySessionBackend {
+	return &MemorySessionBackend{sessions: make(map[string]*Session)}
+}
+
+func (m *MemorySessionBackend) Save(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *s
+	m.sessions[s.ID] = &cp
+	return nil
+}
+
+func (m *MemorySessionBackend) Get(id string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	cp := *s
+	return &cp, nil
+}
+
+func (m *MemorySessionBackend) Touch(id string, lastSeenAt, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[id]; ok {
+		s.LastSeenAt = lastSeenAt
+		s.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+func (m *MemorySessionBackend) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemorySessionBackend) DeleteByUsername(username string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, s := range m.sessions {
+		if s.Username == username {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemorySessionBackend) ListByUsername(username string) ([]Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var list []Session
+	for _, s := range m.sessions {
+		if s.Username == username {
+			list = append(list, *s)
+		}
+	}
+	return list, nil
+}
+
+func (m *MemorySessionBackend) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		if now.After(s.ExpiresAt) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
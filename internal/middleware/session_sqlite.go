@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SQLiteSessionBackend stores sessions in the sessions table so they survive
+// restarts and can be shared by replicas using the same database.
+type SQLiteSessionBackend struct {
+	db *sql.DB
+}
+
+// NewSQLiteSessionBackend creates a SQLiteSessionBackend
+func NewSQLiteSessionBackend(db *sql.DB) *SQLiteSessionBackend {
+	return &SQLiteSessionBackend{db: db}
+}
+
+func (b *SQLiteSessionBackend) Save(s *Session) error {
+	_, err := b.db.Exec(
+		`INSERT INTO sessions (id, username, client_ip, user_agent, created_at, last_seen_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		s.ID, s.Username, s.ClientIP, s.UserAgent, s.CreatedAt, s.LastSeenAt, s.ExpiresAt,
+	)
+	return err
+}
+
+func (b *SQLiteSessionBackend) Get(id string) (*Session, error) {
+	var s Session
+	err := b.db.QueryRow(
+		`SELECT id, username, client_ip, user_agent, created_at, last_seen_at, expires_at FROM sessions WHERE id = ?`,
+		id,
+	).Scan(&s.ID, &s.Username, &s.ClientIP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (b *SQLiteSessionBackend) Touch(id string, lastSeenAt, expiresAt time.Time) error {
+	_, err := b.db.Exec(`UPDATE sessions SET last_seen_at = ?, expires_at = ? WHERE id = ?`, lastSeenAt, expiresAt, id)
+	return err
+}
+
+func (b *SQLiteSessionBackend) Delete(id string) error {
+	_, err := b.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	return err
+}
+
+func (b *SQLiteSessionBackend) DeleteByUsername(username string) (int, error) {
+	res, err := b.db.Exec(`DELETE FROM sessions WHERE username = ?`, username)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+func (b *SQLiteSessionBackend) ListByUsername(username string) ([]Session, error) {
+	rows, err := b.db.Query(
+		`SELECT id, username, client_ip, user_agent, created_at, last_seen_at, expires_at FROM sessions WHERE username = ?`,
+		username,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.Username, &s.ClientIP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func (b *SQLiteSessionBackend) DeleteExpired(now time.Time) error {
+	_, err := b.db.Exec(`DELETE FROM sessions WHERE expires_at < ?`, now)
+	return err
+}
@@ -8,10 +8,21 @@ type User struct {
 	Username     string    `json:"username"`
 	PasswordHash string    `json:"-"`
 	Role         string    `json:"role"`
-	SSHPublicKey string   `json:"ssh_public_key,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+// SSHKey is one of a user's SSH public keys
+type SSHKey struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	Name        string     `json:"name"`
+	PublicKey   string     `json:"public_key"`
+	Fingerprint string     `json:"fingerprint"` // SHA256:...
+	KeyType     string     `json:"key_type"`    // e.g. ssh-ed25519
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"` // last installed or certified for a reservation
+}
+
 // UserPublic is a user without sensitive fields
 type UserPublic struct {
 	ID        int64     `json:"id"`
@@ -28,10 +39,37 @@ type Server struct {
 	Port           int       `json:"port"`
 	SSHUser        string    `json:"ssh_user"`
 	SSHPrivateKey  string    `json:"-"` // never expose to API
+	SSHPublicKey   string    `json:"ssh_public_key,omitempty"` // of a keypair generated by the app; empty for a pasted private key
 	Description    string    `json:"description"`
+	RequiresApproval bool    `json:"requires_approval"`
+	OwnerID        *int64    `json:"owner_id,omitempty"` // may approve bookings besides admins
+	HostKeyFingerprint string `json:"host_key_fingerprint,omitempty"` // trusted SSH host key, empty until first contact
+	AccessMode     string    `json:"access_mode"` // AccessModeAuthorizedKeys or AccessModeCertificate
+	SessionPolicy  string    `json:"session_policy"` // what happens to live SSH sessions when access ends, SessionPolicy*
 	CreatedAt      time.Time `json:"created_at"`
+	// DecommissionedAt is set once the server is retired; it is kept for reservation history
+	DecommissionedAt *time.Time `json:"decommissioned_at,omitempty"`
 }
 
+// How users get SSH access to a server during a reservation
+const (
+	// AccessModeAuthorizedKeys adds the user's key to authorized_keys while the reservation is active
+	AccessModeAuthorizedKeys = "authorized_keys"
+	// AccessModeCertificate signs the user's key into a certificate valid for the
+	// reservation; the server trusts the CA through TrustedUserCAKeys
+	AccessModeCertificate = "certificate"
+)
+
+// What happens to a user's live SSH sessions when their reservation ends
+const (
+	// SessionPolicyTerminate tells the user's sessions that access ended and hangs them up
+	SessionPolicyTerminate = "terminate"
+	// SessionPolicyWarn only tells the user's sessions that access ended
+	SessionPolicyWarn = "warn"
+	// SessionPolicyIgnore leaves sessions alone
+	SessionPolicyIgnore = "ignore"
+)
+
 // Reservation represents a scheduled access window
 type Reservation struct {
 	ID        int64     `json:"id"`
@@ -39,8 +77,25 @@ type Reservation struct {
 	ServerID  int64     `json:"server_id"`
 	StartTime time.Time `json:"start_time"`
 	EndTime   time.Time `json:"end_time"`
-	Status    string    `json:"status"` // pending, active, expired, cancelled
+	Status    string    `json:"status"` // requested, pending, active, expired, released, rejected, cancelled, activation_failed
+	SeriesID  *int64    `json:"series_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+	// ActualEndTime is when access really ended (released, expired or cancelled while active)
+	ActualEndTime *time.Time `json:"actual_end_time,omitempty"`
+	// DecidedBy and DecisionReason record the review of a booking on a server that requires approval
+	DecidedBy      string `json:"decided_by,omitempty"`
+	DecisionReason string `json:"decision_reason,omitempty"`
+	// ActivationAttempts, LastError and NextRetryAt track failed attempts to grant SSH access
+	ActivationAttempts int        `json:"activation_attempts,omitempty"`
+	LastError          string     `json:"last_error,omitempty"`
+	NextRetryAt        *time.Time `json:"next_retry_at,omitempty"`
+	// SSHKeyIDs are the user's keys chosen for this reservation; empty means all of them
+	SSHKeyIDs []int64 `json:"ssh_key_ids,omitempty"`
+	// InstalledKeys are the public keys placed in authorized_keys for this
+	// reservation; they are what gets removed when access ends
+	InstalledKeys []string `json:"installed_keys,omitempty"`
+	// SessionsKilled is how many live SSH sessions were terminated when access ended
+	SessionsKilled int `json:"sessions_killed,omitempty"`
 }
 
 // ReservationWithDetails includes server and user info
@@ -48,4 +103,124 @@ type ReservationWithDetails struct {
 	Reservation
 	ServerName string `json:"server_name"`
 	Username   string `json:"username"`
+	AccessMode string `json:"access_mode"` // of the server
+}
+
+// APIToken is a personal access token for non-browser clients. The secret itself is never stored.
+type APIToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ReservationSeries is a recurring booking. Its occurrences are materialized as reservations.
+type ReservationSeries struct {
+	ID         int64       `json:"id"`
+	UserID     int64       `json:"user_id"`
+	ServerID   int64       `json:"server_id"`
+	StartTime  time.Time   `json:"start_time"` // first occurrence
+	EndTime    time.Time   `json:"end_time"`
+	Rule       string      `json:"rule"` // RRULE-like, e.g. FREQ=WEEKLY;BYDAY=MO,TU;COUNT=10
+	Exceptions []time.Time `json:"exceptions"`
+	Status     string      `json:"status"` // active, cancelled
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// ReservationSeriesWithDetails includes server and user info
+type ReservationSeriesWithDetails struct {
+	ReservationSeries
+	ServerName string `json:"server_name"`
+	Username   string `json:"username"`
+}
+
+// SeriesOccurrence is the outcome of materializing one occurrence of a series.
+// Error is set when the occurrence could not be booked, e.g. because it overlaps.
+type SeriesOccurrence struct {
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	ReservationID int64     `json:"reservation_id,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// WaitlistEntry is a request to be booked on a server when a conflicting reservation frees up
+type WaitlistEntry struct {
+	ID            int64     `json:"id"`
+	UserID        int64     `json:"user_id"`
+	ServerID      int64     `json:"server_id"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	Status        string    `json:"status"` // waiting, promoted, cancelled, expired
+	ReservationID *int64    `json:"reservation_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// WaitlistEntryWithDetails includes server and user info
+type WaitlistEntryWithDetails struct {
+	WaitlistEntry
+	ServerName string `json:"server_name"`
+	Username   string `json:"username"`
+}
+
+// ServerUtilization compares booked and used time on a server over a period.
+// Booked time is the reserved window of every reservation that became active;
+// used time ends early when a reservation was released or cancelled.
+type ServerUtilization struct {
+	ServerID     int64   `json:"server_id"`
+	ServerName   string  `json:"server_name"`
+	Reservations int     `json:"reservations"`
+	Released     int     `json:"released"`
+	BookedHours  float64 `json:"booked_hours"`
+	UsedHours    float64 `json:"used_hours"`
+	UsedPercent  float64 `json:"used_percent"`
+}
+
+// BookingPolicy limits reservations globally (ServerID nil) or on one server.
+// A zero limit means the rule is off. Global and server policies both apply.
+type BookingPolicy struct {
+	ID                 int64     `json:"id"`
+	ServerID           *int64    `json:"server_id"`
+	MaxDurationMinutes int       `json:"max_duration_minutes"`
+	MaxConcurrent      int       `json:"max_concurrent"`
+	MaxHoursPerWeek    int       `json:"max_hours_per_week"`
+	MaxAdvanceDays     int       `json:"max_advance_days"`
+	MinGapMinutes      int       `json:"min_gap_minutes"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// BookingPolicyWithDetails includes the server name (empty for the global policy)
+type BookingPolicyWithDetails struct {
+	BookingPolicy
+	ServerName string `json:"server_name,omitempty"`
+}
+
+// KeyRemoval is a revoked SSH key that could not be removed from a server yet
+// and is retried with backoff until it is gone
+type KeyRemoval struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	ServerID    int64     `json:"server_id"`
+	PublicKey   string    `json:"public_key"`
+	Marker      string    `json:"marker"` // KeyMarker of the line to remove
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	NextRetryAt time.Time `json:"next_retry_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// DriftReport is a difference between a server's authorized_keys and the
+// access granted by reservations, found by the reconciler
+type DriftReport struct {
+	ID         int64     `json:"id"`
+	ServerID   int64     `json:"server_id"`
+	ServerName string    `json:"server_name"`
+	Kind       string    `json:"kind"` // stray, missing, unreachable
+	UserID     *int64    `json:"user_id,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	Detail     string    `json:"detail"`
+	Repaired   bool      `json:"repaired"`
+	CreatedAt  time.Time `json:"created_at"`
 }
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/rusik69/serverscheduler/internal/config"
+	"github.com/rusik69/serverscheduler/internal/handlers"
+	"github.com/rusik69/serverscheduler/internal/middleware"
+	"github.com/rusik69/serverscheduler/internal/services"
+)
+
+// Server wires HTTP routes and the background scheduler and reconciler
+type Server struct {
+	config      config.Config
+	user        services.UserService
+	server      services.ServerService
+	reservation services.ReservationService
+	ssh         services.SSHService
+	slack       services.SlackService
+	tokens      services.APITokenService
+	waitlist    services.WaitlistService
+	policy      services.PolicyService
+	ca          *services.CertificateAuthority
+	scheduler   *services.Scheduler
+	reconciler  *services.Reconciler
+}
+
+// NewServer creates a Server
+func NewServer(cfg config.Config, user services.UserService, srv services.ServerService, res services.ReservationService, ssh services.SSHService, slack services.SlackService, tokens services.APITokenService, waitlist services.WaitlistService, policy services.PolicyService, drift services.DriftService, ca *services.CertificateAuthority) *Server {
+	return &Server{
+		config:      cfg,
+		user:        user,
+		server:      srv,
+		reservation: res,
+		ssh:         ssh,
+		slack:       slack,
+		tokens:      tokens,
+		waitlist:    waitlist,
+		policy:      policy,
+		ca:          ca,
+		scheduler:   services.NewScheduler(res, srv, user, ssh, slack, ca, cfg.SchedulerSweepInterval, cfg.ExpiryReminders, cfg.BaseURL),
+		reconciler:  services.NewReconciler(res, srv, user, ssh, slack, drift, cfg.ReconcileInterval),
+	}
+}
+
+// Start runs the scheduler and the HTTP server until ctx is cancelled
+func (s *Server) Start(ctx context.Context) error {
+	if strings.ToLower(s.config.LogLevel) != "debug" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	go s.scheduler.Start(ctx)
+	go s.reconciler.Start(ctx)
+
+	httpServer := &http.Server{
+		Addr:    ":" + s.config.Port,
+		Handler: s.routes(),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("server listening", "port", s.config.Port)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) routes() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.Use(middleware.RequestIDMiddleware())
+	r.Use(middleware.RequestLoggingMiddleware())
+	r.Use(cors.Default())
+	r.Use(middleware.AuthMiddleware(s.tokens))
+
+	authH := handlers.NewAuthHandler(s.user, s.config)
+	serverH := handlers.NewServerHandler(s.server, s.reservation, s.ssh, s.user, s.policy, s.reconciler, s.ca, s.config)
+	resH := handlers.NewReservationHandler(s.reservation, s.server, s.user, s.ssh, s.waitlist, s.ca, s.config)
+	userH := handlers.NewUserHandler(s.user, s.reservation, s.server, s.ssh, s.tokens, s.waitlist, s.config)
+	apiH := handlers.NewAPIHandler(s.user, s.server, s.reservation, s.ssh, s.tokens, s.waitlist, s.policy, s.reconciler, s.ca, s.config)
+
+	r.GET("/", func(c *gin.Context) { c.Redirect(http.StatusFound, "/servers") })
+	r.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+	r.GET("/health", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok"}) })
+	r.GET("/toggle-theme", toggleTheme)
+
+	r.GET("/login", authH.LoginPage)
+	r.POST("/login", authH.Login)
+	r.GET("/register", authH.RegisterPage)
+	r.POST("/register", authH.Register)
+	r.POST("/logout", authH.Logout)
+
+	r.GET("/servers", serverH.ServersPage)
+	r.POST("/servers/add", serverH.AddServer)
+	r.POST("/servers/:id/test", serverH.TestServer)
+	r.POST("/servers/:id/decommission", serverH.DecommissionServer)
+	r.POST("/servers/:id/approval", serverH.SetApproval)
+	r.POST("/servers/:id/access-mode", serverH.SetAccessMode)
+	r.POST("/servers/:id/session-policy", serverH.SetSessionPolicy)
+	r.POST("/servers/:id/host-key/check", serverH.CheckHostKey)
+	r.POST("/servers/:id/host-key/trust", serverH.TrustHostKey)
+	r.POST("/servers/:id/rotate-key", serverH.RotateKey)
+	r.POST("/servers/:id/edit", serverH.EditServer)
+	r.POST("/policies", serverH.SavePolicy)
+	r.POST("/policies/:id/delete", serverH.DeletePolicy)
+	r.POST("/drift/check", serverH.CheckDrift)
+
+	r.GET("/reservations", resH.ReservationsPage)
+	r.GET("/reservations/data", resH.ReservationsData)
+	r.POST("/reservations/add", resH.AddReservation)
+	r.POST("/reservations/add-admin", resH.AdminAddReservation)
+	r.POST("/reservations/:id/cancel", resH.CancelReservation)
+	r.POST("/reservations/:id/end-time", resH.UpdateEndTime)
+	r.POST("/reservations/:id/release", resH.ReleaseReservation)
+	r.GET("/reservations/:id/certificate", resH.DownloadCertificate)
+	r.POST("/reservations/:id/approve", resH.ApproveReservation)
+	r.POST("/reservations/:id/reject", resH.RejectReservation)
+	r.POST("/reservations/series/:id/cancel", resH.CancelSeries)
+	r.POST("/waitlist/join", resH.JoinWaitlist)
+	r.POST("/waitlist/:id/leave", resH.LeaveWaitlist)
+
+	r.GET("/profile", userH.ProfilePage)
+	r.POST("/profile/ssh-keys", userH.AddSSHKey)
+	r.POST("/profile/ssh-keys/:id/delete", userH.DeleteSSHKey)
+	r.POST("/profile/tokens", userH.CreateToken)
+	r.POST("/profile/tokens/:id/revoke", userH.RevokeToken)
+	r.POST("/profile/sessions/:id/revoke", userH.RevokeSession)
+
+	r.GET("/users", userH.UsersPage)
+	r.POST("/users/add-user", authH.RegisterUser)
+	r.POST("/users/add-admin", authH.RegisterAdmin)
+	r.POST("/users/:id/delete", userH.DeleteUser)
+	r.POST("/users/:id/logout", userH.LogoutUser)
+
+	api := r.Group("/api/v1")
+	api.GET("/me", apiH.Me)
+	api.GET("/me/ssh-keys", apiH.ListMySSHKeys)
+	api.POST("/me/ssh-keys", apiH.AddMySSHKey)
+	api.DELETE("/me/ssh-keys/:id", apiH.DeleteMySSHKey)
+	api.GET("/me/tokens", apiH.ListTokens)
+	api.POST("/me/tokens", apiH.CreateToken)
+	api.DELETE("/me/tokens/:id", apiH.RevokeToken)
+	api.GET("/me/sessions", apiH.ListMySessions)
+
+	api.GET("/reservations", apiH.ListReservations)
+	api.POST("/reservations", apiH.CreateReservation)
+	api.GET("/reservations/:id", apiH.GetReservation)
+	api.PATCH("/reservations/:id", apiH.UpdateReservation)
+	api.POST("/reservations/:id/release", apiH.ReleaseReservation)
+	api.GET("/reservations/:id/certificate", apiH.GetReservationCertificate)
+	api.POST("/reservations/:id/approve", apiH.ApproveReservation)
+	api.POST("/reservations/:id/reject", apiH.RejectReservation)
+	api.POST("/reservations/:id/cancel", apiH.CancelReservation)
+	api.DELETE("/reservations/:id", apiH.CancelReservation)
+
+	api.GET("/series", apiH.ListSeries)
+	api.POST("/series", apiH.CreateSeries)
+	api.GET("/series/:id", apiH.GetSeries)
+	api.POST("/series/:id/cancel", apiH.CancelSeries)
+	api.GET("/reports/utilization", apiH.UtilizationReport)
+	api.GET("/drift", apiH.ListDrift)
+	api.POST("/drift/check", apiH.CheckDrift)
+	api.GET("/policies", apiH.ListPolicies)
+	api.PUT("/policies", apiH.SavePolicy)
+	api.DELETE("/policies/:id", apiH.DeletePolicy)
+	api.GET("/approvals", apiH.ListApprovals)
+	api.GET("/waitlist", apiH.ListWaitlist)
+	api.POST("/waitlist", apiH.JoinWaitlist)
+	api.DELETE("/waitlist/:id", apiH.LeaveWaitlist)
+
+	api.GET("/ssh-ca", apiH.GetSSHCA)
+	api.GET("/servers", apiH.ListServers)
+	api.POST("/servers", apiH.CreateServer)
+	api.GET("/servers/:id", apiH.GetServer)
+	api.PATCH("/servers/:id", apiH.UpdateServer)
+	api.PUT("/servers/:id/approval", apiH.SetServerApproval)
+	api.PUT("/servers/:id/access-mode", apiH.SetServerAccessMode)
+	api.PUT("/servers/:id/session-policy", apiH.SetServerSessionPolicy)
+	api.GET("/servers/:id/host-key", apiH.GetServerHostKey)
+	api.PUT("/servers/:id/host-key", apiH.TrustServerHostKey)
+	api.POST("/servers/:id/rotate-key", apiH.RotateServerKey)
+	api.DELETE("/servers/:id", apiH.DecommissionServer)
+
+	api.GET("/users", apiH.ListUsers)
+	api.POST("/users", apiH.CreateUser)
+	api.GET("/users/:id", apiH.GetUser)
+	api.DELETE("/users/:id", apiH.DeleteUser)
+	api.POST("/users/:id/logout", apiH.LogoutUser)
+
+	return r
+}
+
+// toggleTheme flips the theme cookie and returns to the page it was called from
+func toggleTheme(c *gin.Context) {
+	theme, _ := c.Cookie("theme")
+	next := "dark"
+	if theme == "dark" {
+		next = "light"
+	}
+	c.SetCookie("theme", next, int((365 * 24 * time.Hour).Seconds()), "/", "", false, false)
+	redirect := c.Query("redirect")
+	if redirect == "" || !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/servers"
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// InstalledKeys returns the public keys an active reservation put on its
+// server. Reservations activated before keys were recorded fall back to the
+// keys the reservation would install now.
+func InstalledKeys(r *models.Reservation, keys []models.SSHKey) []string {
+	if len(r.InstalledKeys) > 0 {
+		return r.InstalledKeys
+	}
+	return publicKeys(SelectedKeys(r, keys))
+}
+
+// SameTarget reports whether two versions of a server log in to the same
+// account, so keys granted on one are valid on the other
+func SameTarget(a, b *models.Server) bool {
+	return a.Hostname == b.Hostname && a.Port == b.Port && a.SSHUser == b.SSHUser
+}
+
+// MoveActiveKeys grants the users of active reservations on a server access at
+// its new address or SSH user (to) and then revokes it at the old one (from).
+// It does nothing in certificate mode, where certificates are re-issued for the
+// new SSH user on the next download. Failures are returned together; keys
+// missing on the new target are also restored by the reconciler.
+func MoveActiveKeys(ctx context.Context, res ReservationService, users UserService, ssh SSHService, from, to *models.Server) error {
+	if SameTarget(from, to) || to.AccessMode == models.AccessModeCertificate {
+		return nil
+	}
+	scheduled, err := res.GetScheduled(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, r := range scheduled {
+		if r.ServerID != to.ID || r.Status != "active" {
+			continue
+		}
+		usr, err := users.GetByID(ctx, r.UserID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if usr == nil {
+			continue
+		}
+		keys, err := users.ListSSHKeys(ctx, r.UserID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, key := range InstalledKeys(&r, keys) {
+			if err := ssh.AddKey(ctx, to.Hostname, to.Port, to.SSHUser, to.SSHPrivateKey, key, KeyMarker(r.ID, usr.Username)); err != nil {
+				errs = append(errs, fmt.Errorf("grant %s access on %s@%s: %w", usr.Username, to.SSHUser, to.Hostname, err))
+				continue
+			}
+			if err := ssh.RemoveKey(ctx, from.Hostname, from.Port, from.SSHUser, from.SSHPrivateKey, key, KeyMarker(r.ID, usr.Username)); err != nil {
+				errs = append(errs, fmt.Errorf("revoke %s access on %s@%s: %w", usr.Username, from.SSHUser, from.Hostname, err))
+			}
+		}
+		slog.Info("active reservation access moved", "reservation_id", r.ID, "server_id", to.ID, "user_id", r.UserID, "ssh_user", to.SSHUser)
+	}
+	return errors.Join(errs...)
+}
+
+// SyncActiveKeys brings the keys of usr's active reservations in line after
+// the user added or removed keys; before and after are the user's keys around
+// the change. New keys are added before old ones are removed, so access is
+// never interrupted. A key that could not be added is left out of the
+// recorded keys; one that could not be removed is queued for retry.
+// Certificate-mode servers need nothing here; the next certificate download
+// is signed for the current keys.
+func SyncActiveKeys(ctx context.Context, res ReservationService, servers ServerService, users UserService, ssh SSHService, usr *models.User, before, after []models.SSHKey) error {
+	scheduled, err := res.GetScheduled(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, r := range scheduled {
+		if r.UserID != usr.ID || r.Status != "active" {
+			continue
+		}
+		installed := InstalledKeys(&r, before)
+		wanted := SelectedKeys(&r, after)
+		srv, err := servers.Get(ctx, r.ServerID)
+		if err != nil || srv == nil || srv.AccessMode == models.AccessModeCertificate {
+			continue
+		}
+		have := make(map[string]bool, len(installed))
+		for _, key := range installed {
+			have[keyID(key)] = true
+		}
+		var keep []string
+		var added []models.SSHKey
+		want := make(map[string]bool, len(wanted))
+		for _, k := range wanted {
+			want[keyID(k.PublicKey)] = true
+			if have[keyID(k.PublicKey)] {
+				keep = append(keep, k.PublicKey)
+				continue
+			}
+			if err := ssh.AddKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, k.PublicKey, KeyMarker(r.ID, usr.Username)); err != nil {
+				errs = append(errs, fmt.Errorf("add key %s on %s: %w", k.Name, srv.Name, err))
+				continue
+			}
+			keep = append(keep, k.PublicKey)
+			added = append(added, k)
+		}
+		var stale []string
+		for _, key := range installed {
+			if !want[keyID(key)] {
+				stale = append(stale, key)
+			}
+		}
+		if len(added) == 0 && len(stale) == 0 {
+			continue
+		}
+		if err := res.SetInstalledKeys(ctx, r.ID, keep); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := users.MarkSSHKeysUsed(ctx, sshKeyIDs(added)); err != nil {
+			slog.Warn("recording SSH key use failed", "reservation_id", r.ID, "error", err)
+		}
+		for _, key := range stale {
+			if err := RemoveKeyOrQueue(ctx, res, ssh, srv, usr.ID, key, KeyMarker(r.ID, usr.Username)); err != nil {
+				slog.Warn("remove old key failed, queued for retry", "reservation_id", r.ID, "user_id", usr.ID, "server_id", srv.ID, "error", err)
+			}
+		}
+		slog.Info("active reservation keys updated", "reservation_id", r.ID, "user_id", usr.ID, "server_id", srv.ID, "added", len(added), "removed", len(stale))
+	}
+	return errors.Join(errs...)
+}
+
+// EndSessions applies the server's session policy to the live SSH sessions of
+// a reservation whose access just ended: they are told and hung up
+// (terminate), only told (warn) or left alone (ignore). Sessions are matched by
+// the keys the reservation granted access with and must have logged in since
+// it started. It returns the number of
+// sessions hung up, which is also recorded on the reservation.
+func EndSessions(ctx context.Context, res ReservationService, ssh SSHService, srv *models.Server, r *models.Reservation, keys []models.SSHKey) (int, error) {
+	if srv.SessionPolicy == models.SessionPolicyIgnore {
+		return 0, nil
+	}
+	granted := InstalledKeys(r, keys)
+	if len(granted) == 0 {
+		return 0, nil
+	}
+	if srv.SessionPolicy == models.SessionPolicyWarn {
+		msg := fmt.Sprintf("serverscheduler: reservation #%d on %s has ended; please log out", r.ID, srv.Name)
+		n, err := ssh.MessageSessions(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, granted, r.StartTime, msg)
+		if err != nil {
+			return 0, err
+		}
+		slog.Info("live sessions warned", "reservation_id", r.ID, "server_id", srv.ID, "sessions", n)
+		return 0, nil
+	}
+	msg := fmt.Sprintf("serverscheduler: reservation #%d on %s has ended; this session is being closed", r.ID, srv.Name)
+	n, err := ssh.TerminateSessions(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, granted, r.StartTime, msg)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		slog.Info("live sessions terminated", "reservation_id", r.ID, "server_id", srv.ID, "sessions", n)
+		if err := res.SetSessionsKilled(ctx, r.ID, n); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
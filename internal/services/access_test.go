@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// fakeSSH records the key changes made on servers
+type fakeSSH struct {
+	calls   []string
+	markers []string // of removed lines
+	lines   []string // returned by ListKeys
+}
+
+func (f *fakeSSH) AddKey(ctx context.Context, hostname string, port int, sshUser, privateKey, publicKey, marker string) error {
+	f.calls = append(f.calls, fmt.Sprintf("add %s@%s %s", sshUser, hostname, keyComment(publicKey)))
+	return nil
+}
+
+func (f *fakeSSH) RemoveKey(ctx context.Context, hostname string, port int, sshUser, privateKey, publicKey, marker string) error {
+	f.calls = append(f.calls, fmt.Sprintf("remove %s@%s %s", sshUser, hostname, keyComment(publicKey)))
+	f.markers = append(f.markers, marker)
+	return nil
+}
+
+func (f *fakeSSH) ListKeys(ctx context.Context, hostname string, port int, sshUser, privateKey string) ([]string, error) {
+	return f.lines, nil
+}
+
+func (f *fakeSSH) MessageSessions(ctx context.Context, hostname string, port int, sshUser, privateKey string, publicKeys []string, since time.Time, message string) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeSSH) TerminateSessions(ctx context.Context, hostname string, port int, sshUser, privateKey string, publicKeys []string, since time.Time, message string) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeSSH) TestConnection(ctx context.Context, hostname string, port int, sshUser, privateKey string) error {
+	return nil
+}
+
+func (f *fakeSSH) HostKey(ctx context.Context, hostname string, port int) (string, error) {
+	return "", nil
+}
+
+func (f *fakeSSH) TrustHostKey(ctx context.Context, hostname string, port int, fingerprint string) error {
+	return nil
+}
+
+// keyComment returns the comment of an authorized_keys line, which the tests
+// use as the key's name
+func keyComment(line string) string {
+	fields := strings.Fields(line)
+	return fields[len(fields)-1]
+}
+
+func TestInstalledKeys(t *testing.T) {
+	keys := []models.SSHKey{
+		{ID: 1, PublicKey: "ssh-ed25519 AAAA1 laptop"},
+		{ID: 2, PublicKey: "ssh-ed25519 AAAA2 desktop"},
+		{ID: 3, PublicKey: "ssh-ed25519 AAAA3 ci"},
+	}
+	tests := []struct {
+		name string
+		r    models.Reservation
+		keys []models.SSHKey
+		want []string
+	}{
+		{
+			name: "all keys when none are chosen",
+			keys: keys,
+			want: []string{"ssh-ed25519 AAAA1 laptop", "ssh-ed25519 AAAA2 desktop", "ssh-ed25519 AAAA3 ci"},
+		},
+		{
+			name: "chosen keys only",
+			r:    models.Reservation{SSHKeyIDs: []int64{3, 1}},
+			keys: keys,
+			want: []string{"ssh-ed25519 AAAA1 laptop", "ssh-ed25519 AAAA3 ci"},
+		},
+		{
+			name: "deleted chosen key is left out",
+			r:    models.Reservation{SSHKeyIDs: []int64{2, 4}},
+			keys: keys,
+			want: []string{"ssh-ed25519 AAAA2 desktop"},
+		},
+		{
+			name: "recorded keys win over the current ones",
+			r:    models.Reservation{SSHKeyIDs: []int64{1}, InstalledKeys: []string{"ssh-ed25519 AAAA9 old"}},
+			keys: keys,
+			want: []string{"ssh-ed25519 AAAA9 old"},
+		},
+		{
+			name: "recorded keys outlive the user's keys",
+			r:    models.Reservation{InstalledKeys: []string{"ssh-ed25519 AAAA1 laptop"}},
+			want: []string{"ssh-ed25519 AAAA1 laptop"},
+		},
+		{
+			name: "no keys",
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := InstalledKeys(&tt.r, tt.keys)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("InstalledKeys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncActiveKeys(t *testing.T) {
+	tests := []struct {
+		name          string
+		chosen        []string // names of the keys the reservation was booked with
+		before, after []string // names of the user's keys around the change
+		wantCalls     []string
+		wantInstalled []string
+	}{
+		{
+			name:          "added key is installed",
+			before:        []string{"laptop"},
+			after:         []string{"laptop", "desktop"},
+			wantCalls:     []string{"add root@lab desktop"},
+			wantInstalled: []string{"laptop", "desktop"},
+		},
+		{
+			name:          "deleted key is removed",
+			before:        []string{"laptop", "desktop"},
+			after:         []string{"desktop"},
+			wantCalls:     []string{"remove root@lab laptop"},
+			wantInstalled: []string{"desktop"},
+		},
+		{
+			name:          "swapped key is added before the old one is removed",
+			before:        []string{"laptop"},
+			after:         []string{"desktop"},
+			wantCalls:     []string{"add root@lab desktop", "remove root@lab laptop"},
+			wantInstalled: []string{"desktop"},
+		},
+		{
+			name:          "added key is not installed when keys were chosen",
+			chosen:        []string{"laptop"},
+			before:        []string{"laptop"},
+			after:         []string{"laptop", "desktop"},
+			wantInstalled: []string{"laptop"},
+		},
+		{
+			name:          "deleted chosen key is removed",
+			chosen:        []string{"laptop", "desktop"},
+			before:        []string{"laptop", "desktop"},
+			after:         []string{"desktop"},
+			wantCalls:     []string{"remove root@lab laptop"},
+			wantInstalled: []string{"desktop"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			ctx := context.Background()
+			servers := NewServerService(db, nil)
+			srv, err := servers.Create(ctx, &models.Server{Name: "lab", Hostname: "lab", Port: 22, SSHUser: "root"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			users := NewUserService(db).(*UserServiceDB)
+			usr := &models.User{ID: addTestUser(t, db, "alice"), Username: "alice"}
+			keys := map[string]models.SSHKey{}
+			for _, name := range []string{"laptop", "desktop"} {
+				k, err := users.AddSSHKey(ctx, usr.ID, name, testPublicKey(t, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				keys[name] = *k
+			}
+			pick := func(names []string) []models.SSHKey {
+				var list []models.SSHKey
+				for _, name := range names {
+					list = append(list, keys[name])
+				}
+				return list
+			}
+
+			res := NewReservationService(db, NewSlackService(""))
+			start := time.Now().UTC().Add(-time.Hour).Truncate(time.Minute)
+			r, err := res.CreateWithKeys(ctx, usr.ID, srv.ID, start, start.Add(2*time.Hour), sshKeyIDs(pick(tt.chosen)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := pick(tt.before)
+			if err := res.Activate(ctx, r.ID, InstalledKeys(r, before)); err != nil {
+				t.Fatal(err)
+			}
+
+			fake := &fakeSSH{}
+			if err := SyncActiveKeys(ctx, res, servers, users, fake, usr, before, pick(tt.after)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fake.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", fake.calls, tt.wantCalls)
+			}
+			r, err = res.Get(ctx, r.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var installed []string
+			for _, key := range r.InstalledKeys {
+				installed = append(installed, keyComment(key))
+			}
+			if !reflect.DeepEqual(installed, tt.wantInstalled) {
+				t.Errorf("installed keys = %v, want %v", installed, tt.wantInstalled)
+			}
+		})
+	}
+}
+
+func TestMoveActiveKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		to        models.Server // changes to the server
+		wantCalls []string
+	}{
+		{
+			name: "same target",
+			to:   models.Server{Name: "renamed", Hostname: "old", SSHUser: "root"},
+		},
+		{
+			name:      "new address",
+			to:        models.Server{Hostname: "new", SSHUser: "root"},
+			wantCalls: []string{"add root@new laptop", "remove root@old laptop"},
+		},
+		{
+			name:      "new SSH user",
+			to:        models.Server{Hostname: "old", SSHUser: "lab"},
+			wantCalls: []string{"add lab@old laptop", "remove root@old laptop"},
+		},
+		{
+			name: "certificate mode",
+			to:   models.Server{Hostname: "new", SSHUser: "root", AccessMode: models.AccessModeCertificate},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			ctx := context.Background()
+			from, err := NewServerService(db, nil).Create(ctx, &models.Server{Name: "lab", Hostname: "old", Port: 22, SSHUser: "root"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			users := NewUserService(db).(*UserServiceDB)
+			userID := addTestUser(t, db, "alice")
+			if _, err := users.AddSSHKey(ctx, userID, "laptop", testPublicKey(t, "laptop")); err != nil {
+				t.Fatal(err)
+			}
+			keys, err := users.ListSSHKeys(ctx, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := NewReservationService(db, NewSlackService(""))
+			start := time.Now().UTC().Add(-time.Hour).Truncate(time.Minute)
+			r, err := res.Create(ctx, userID, from.ID, start, start.Add(2*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := res.Activate(ctx, r.ID, InstalledKeys(r, keys)); err != nil {
+				t.Fatal(err)
+			}
+
+			to := *from
+			to.Hostname, to.SSHUser = tt.to.Hostname, tt.to.SSHUser
+			if tt.to.AccessMode != "" {
+				to.AccessMode = tt.to.AccessMode
+			}
+			fake := &fakeSSH{}
+			if err := MoveActiveKeys(ctx, res, users, fake, from, &to); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fake.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", fake.calls, tt.wantCalls)
+			}
+		})
+	}
+}
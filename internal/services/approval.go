@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// Approve moves a requested reservation to pending so the scheduler activates it
+func (s *ReservationServiceDB) Approve(ctx context.Context, id int64, decidedBy, reason string) (*models.Reservation, error) {
+	return s.decide(ctx, id, "pending", decidedBy, reason)
+}
+
+// Reject declines a requested reservation and frees its slot
+func (s *ReservationServiceDB) Reject(ctx context.Context, id int64, decidedBy, reason string) (*models.Reservation, error) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrReasonRequired
+	}
+	return s.decide(ctx, id, "rejected", decidedBy, reason)
+}
+
+func (s *ReservationServiceDB) decide(ctx context.Context, id int64, status, decidedBy, reason string) (*models.Reservation, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE reservations SET status = ?, decided_by = ?, decision_reason = ? WHERE id = ? AND status = 'requested'`,
+		status, decidedBy, strings.TrimSpace(reason), id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+	s.notifyChanged()
+	r, err := s.Get(ctx, id)
+	if err != nil || r == nil {
+		return r, err
+	}
+	s.notifyDecision(ctx, r)
+	return r, nil
+}
+
+// ListRequested returns reservations awaiting approval. With ownerID set, only
+// bookings on servers owned by that user are returned.
+func (s *ReservationServiceDB) ListRequested(ctx context.Context, ownerID *int64) ([]models.ReservationWithDetails, error) {
+	query := `SELECT ` + reservationColumns + `, s.name, u.username, s.access_mode
+		 FROM reservations r
+		 JOIN servers s ON r.server_id = s.id
+		 JOIN users u ON r.user_id = u.id
+		 WHERE r.status = 'requested'`
+	var args []interface{}
+	if ownerID != nil {
+		query += ` AND s.owner_id = ?`
+		args = append(args, *ownerID)
+	}
+	query += ` ORDER BY r.start_time`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []models.ReservationWithDetails
+	for rows.Next() {
+		var r models.ReservationWithDetails
+		res, err := scanReservation(rows, &r.ServerName, &r.Username, &r.AccessMode)
+		if err != nil {
+			return nil, err
+		}
+		r.Reservation = res
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// notifyRequested tells approvers about a booking that needs sign-off.
+// count > 1 summarizes the requested occurrences of a recurring series.
+func (s *ReservationServiceDB) notifyRequested(ctx context.Context, r *models.Reservation, count int) {
+	requester, serverName, owner, err := s.approvalNames(ctx, r)
+	if err != nil {
+		slog.Warn("approval notify lookup failed", "reservation_id", r.ID, "error", err)
+		return
+	}
+	approvers := "admins"
+	if owner != "" {
+		approvers = owner + " (owner) or admins"
+	}
+	what := fmt.Sprintf("%s to %s", r.StartTime.UTC().Format(time.RFC3339), r.EndTime.UTC().Format(time.RFC3339))
+	if count > 1 {
+		what = fmt.Sprintf("%d recurring slots starting %s", count, r.StartTime.UTC().Format(time.RFC3339))
+	}
+	msg := fmt.Sprintf("Approval needed: %s requested %s (%s). Approvers: %s", requester, serverName, what, approvers)
+	if err := s.slack.Notify(ctx, msg); err != nil {
+		slog.Warn("slack notify failed", "reservation_id", r.ID, "error", err)
+	}
+}
+
+// notifyDecision tells the requester how their booking was reviewed
+func (s *ReservationServiceDB) notifyDecision(ctx context.Context, r *models.Reservation) {
+	requester, serverName, _, err := s.approvalNames(ctx, r)
+	if err != nil {
+		slog.Warn("approval notify lookup failed", "reservation_id", r.ID, "error", err)
+		return
+	}
+	verb := "approved"
+	if r.Status == "rejected" {
+		verb = "rejected"
+	}
+	msg := fmt.Sprintf("Reservation %s: %s, your booking of %s (%s to %s) was %s by %s",
+		verb, requester, serverName, r.StartTime.UTC().Format(time.RFC3339), r.EndTime.UTC().Format(time.RFC3339), verb, r.DecidedBy)
+	if r.DecisionReason != "" {
+		msg += ". Reason: " + r.DecisionReason
+	}
+	if err := s.slack.Notify(ctx, msg); err != nil {
+		slog.Warn("slack notify failed", "reservation_id", r.ID, "error", err)
+	}
+}
+
+func (s *ReservationServiceDB) approvalNames(ctx context.Context, r *models.Reservation) (requester, serverName, owner string, err error) {
+	err = s.db.QueryRowContext(ctx,
+		`SELECT u.username, s.name, COALESCE(o.username, '')
+		 FROM reservations r
+		 JOIN users u ON r.user_id = u.id
+		 JOIN servers s ON r.server_id = s.id
+		 LEFT JOIN users o ON s.owner_id = o.id
+		 WHERE r.id = ?`,
+		r.ID,
+	).Scan(&requester, &serverName, &owner)
+	return requester, serverName, owner, err
+}
+
+var ErrReasonRequired = &reservationError{msg: "a reason is required"}
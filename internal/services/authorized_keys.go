@@ -0,0 +1,73 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keyMarkerPrefix starts the comment on every authorized_keys line the
+// scheduler writes. Lines without it are never touched.
+const keyMarkerPrefix = "serverscheduler:"
+
+var (
+	keyMarkerRe    = regexp.MustCompile(`serverscheduler:res=(\d+):user=(\S*)`)
+	markerUnsafeRe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+)
+
+// KeyMarker is the comment that tags a line added for a reservation, e.g.
+// serverscheduler:res=42:user=alice
+func KeyMarker(reservationID int64, username string) string {
+	return fmt.Sprintf("%sres=%d:user=%s", keyMarkerPrefix, reservationID, markerUnsafeRe.ReplaceAllString(username, "_"))
+}
+
+// ServerKeyMarker is the comment on the scheduler's own management key for a
+// server, e.g. serverscheduler:server=7. The reconciler does not treat it as a
+// reservation key.
+func ServerKeyMarker(serverID int64) string {
+	return fmt.Sprintf("%sserver=%d", keyMarkerPrefix, serverID)
+}
+
+// parseKeyMarker returns the reservation and username recorded on a managed line
+func parseKeyMarker(line string) (reservationID int64, username string, ok bool) {
+	m := keyMarkerRe.FindStringSubmatch(line)
+	if m == nil {
+		return 0, "", false
+	}
+	id, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return id, m[2], true
+}
+
+// keyMaterial returns the "type base64" part of a public key, without options
+// or comment. It is safe to embed in a shell command.
+func keyMaterial(publicKey string) (string, error) {
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pk))), nil
+}
+
+// keyID identifies the key on an authorized_keys line so the same key matches
+// however it was written. Unparsable lines are compared as they are.
+func keyID(line string) string {
+	if k, err := keyMaterial(line); err == nil {
+		return k
+	}
+	return strings.TrimSpace(line)
+}
+
+// shortKey abbreviates a key for reports: its type and the end of its data
+func shortKey(id string) string {
+	parts := strings.Fields(id)
+	if len(parts) < 2 || len(parts[1]) <= 12 {
+		return id
+	}
+	return parts[0] + " ..." + parts[1][len(parts[1])-12:]
+}
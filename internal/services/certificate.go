@@ -0,0 +1,290 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// CertificateAuthority signs users' SSH keys into certificates for reservations
+// on servers in certificate access mode. Servers trust it by listing PublicKey
+// in sshd's TrustedUserCAKeys.
+type CertificateAuthority struct {
+	signer      ssh.Signer
+	ttl         time.Duration
+	reservation ReservationService
+	server      ServerService
+	user        UserService
+}
+
+// IssuedCertificate is the certificate signed for one of a reservation's keys
+type IssuedCertificate struct {
+	SSHKeyID    int64  `json:"ssh_key_id"`
+	KeyName     string `json:"key_name"`
+	Fingerprint string `json:"fingerprint"`
+	Certificate string `json:"certificate"`
+}
+
+// NewCertificateAuthority loads the CA private key from path, generating an
+// ed25519 key there on first start. With a keyring the key is stored sealed
+// like the servers' private keys. Certificates are valid for at most ttl.
+func NewCertificateAuthority(path string, ttl time.Duration, keys *Keyring, res ReservationService, srv ServerService, usr UserService) (*CertificateAuthority, error) {
+	signer, err := loadOrCreateCAKey(path, keys)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{signer: signer, ttl: ttl, reservation: res, server: srv, user: usr}, nil
+}
+
+// PublicKey returns the CA public key in authorized_keys format
+func (ca *CertificateAuthority) PublicKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.signer.PublicKey()))) + " serverscheduler-ca"
+}
+
+// Issue returns a certificate for each of reservation r's keys and when they
+// stop being valid. The stored certificates are signed again unless every key
+// still has one that fits (its end is not past the reservation's and it is
+// not about to run out). Each certificate's only principal is the server's SSH
+// user and it is valid from the reservation's start for at most the CA's ttl
+// and never past its end, so users download it again to renew it during long
+// reservations.
+func (ca *CertificateAuthority) Issue(ctx context.Context, r *models.Reservation) ([]IssuedCertificate, time.Time, error) {
+	usr, err := ca.user.GetByID(ctx, r.UserID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if usr == nil {
+		return nil, time.Time{}, ErrNoSSHKey
+	}
+	keys, err := ca.user.ListSSHKeys(ctx, r.UserID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	keys = SelectedKeys(r, keys)
+	if len(keys) == 0 {
+		return nil, time.Time{}, ErrNoSSHKey
+	}
+	srv, err := ca.server.Get(ctx, r.ServerID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if srv == nil {
+		return nil, time.Time{}, fmt.Errorf("server %d not found", r.ServerID)
+	}
+	pubs := make([]ssh.PublicKey, len(keys))
+	for i, k := range keys {
+		pubs[i], _, _, _, err = ssh.ParseAuthorizedKey([]byte(k.PublicKey))
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("invalid SSH public key %s: %w", k.Name, err)
+		}
+	}
+
+	stored, err := ca.reservation.Certificate(ctx, r.ID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	now := time.Now().UTC()
+	if certs, validBefore, ok := ca.reuse(stored, keys, pubs, srv.SSHUser, r, now); ok {
+		return certs, validBefore, nil
+	}
+	validBefore := r.EndTime
+	if limit := now.Add(ca.ttl); limit.Before(validBefore) {
+		validBefore = limit
+	}
+
+	certs := make([]IssuedCertificate, len(keys))
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		cert := &ssh.Certificate{
+			Key:             pubs[i],
+			Serial:          uint64(r.ID),
+			CertType:        ssh.UserCert,
+			KeyId:           KeyMarker(r.ID, usr.Username),
+			ValidPrincipals: []string{srv.SSHUser},
+			ValidAfter:      uint64(r.StartTime.Unix()),
+			ValidBefore:     uint64(validBefore.Unix()),
+			Permissions: ssh.Permissions{Extensions: map[string]string{
+				"permit-X11-forwarding":   "",
+				"permit-agent-forwarding": "",
+				"permit-port-forwarding":  "",
+				"permit-pty":              "",
+				"permit-user-rc":          "",
+			}},
+		}
+		if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+			return nil, time.Time{}, fmt.Errorf("sign certificate: %w", err)
+		}
+		// The comment names the key, so users can tell the lines apart
+		lines[i] = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))) + " " + cert.KeyId + " " + k.Name
+		certs[i] = issued(k, lines[i])
+	}
+	if err := ca.reservation.SetCertificate(ctx, r.ID, strings.Join(lines, "\n")); err != nil {
+		return nil, time.Time{}, err
+	}
+	slog.Info("certificate issued", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "principal", srv.SSHUser, "keys", len(keys), "valid_before", validBefore)
+	return certs, validBefore, nil
+}
+
+// reuse returns the stored certificates, one per line, if every key has one
+// that still fits, with the earliest end among them
+func (ca *CertificateAuthority) reuse(stored string, keys []models.SSHKey, pubs []ssh.PublicKey, principal string, r *models.Reservation, now time.Time) ([]IssuedCertificate, time.Time, bool) {
+	if stored == "" {
+		return nil, time.Time{}, false
+	}
+	lines := strings.Split(stored, "\n")
+	certs := make([]IssuedCertificate, len(keys))
+	var earliest time.Time
+	for i, k := range keys {
+		found := false
+		for _, line := range lines {
+			validBefore, ok := ca.matches(line, pubs[i], principal, r, now)
+			if !ok {
+				continue
+			}
+			certs[i] = issued(k, line)
+			if earliest.IsZero() || validBefore.Before(earliest) {
+				earliest = validBefore
+			}
+			found = true
+			break
+		}
+		if !found {
+			return nil, time.Time{}, false
+		}
+	}
+	return certs, earliest, true
+}
+
+func issued(k models.SSHKey, cert string) IssuedCertificate {
+	return IssuedCertificate{SSHKeyID: k.ID, KeyName: k.Name, Fingerprint: k.Fingerprint, Certificate: cert}
+}
+
+// matches reports whether a stored certificate still fits the reservation and
+// returns when it runs out. One with less than half the ttl left is renewed
+// unless it already lasts until the reservation's end.
+func (ca *CertificateAuthority) matches(stored string, pub ssh.PublicKey, principal string, r *models.Reservation, now time.Time) (time.Time, bool) {
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(stored))
+	if err != nil {
+		return time.Time{}, false
+	}
+	cert, ok := parsed.(*ssh.Certificate)
+	if !ok {
+		return time.Time{}, false
+	}
+	validBefore := time.Unix(int64(cert.ValidBefore), 0).UTC()
+	fits := bytes.Equal(cert.Key.Marshal(), pub.Marshal()) &&
+		bytes.Equal(cert.SignatureKey.Marshal(), ca.signer.PublicKey().Marshal()) &&
+		len(cert.ValidPrincipals) == 1 && cert.ValidPrincipals[0] == principal &&
+		cert.ValidAfter == uint64(r.StartTime.Unix()) &&
+		cert.ValidBefore <= uint64(r.EndTime.Unix()) &&
+		(cert.ValidBefore == uint64(r.EndTime.Unix()) || validBefore.Sub(now) > ca.ttl/2)
+	return validBefore, fits
+}
+
+// loadOrCreateCAKey reads the CA key from path or generates it. With a keyring
+// the file holds the key sealed; a plaintext file, or one sealed with a master
+// key that is no longer the active one, is sealed again on load.
+func loadOrCreateCAKey(path string, keys *Keyring) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		value := strings.TrimSpace(string(data))
+		plaintext, err := keys.Decrypt(value)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt SSH CA key %s: %w", path, err)
+		}
+		signer, err := ssh.ParsePrivateKey([]byte(plaintext))
+		if err != nil {
+			return nil, fmt.Errorf("parse SSH CA key %s: %w", path, err)
+		}
+		if keys != nil && !strings.HasPrefix(value, encryptedPrefix+keys.ActiveKeyID()+":") {
+			if err := writeCAKey(path, plaintext, keys, true); err != nil {
+				return nil, err
+			}
+			slog.Info("SSH CA key sealed with the master key", "path", path, "key_id", keys.ActiveKeyID())
+		}
+		return signer, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "serverscheduler-ca")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := writeCAKey(path, string(pem.EncodeToMemory(block)), keys, false); err != nil {
+		return nil, err
+	}
+	slog.Info("generated SSH CA key", "path", path, "sealed", keys != nil)
+	return ssh.NewSignerFromKey(priv)
+}
+
+// writeCAKey stores the PEM-encoded CA key at path, sealed when there is a
+// keyring. An existing file is only replaced, through a rename, when replace is set.
+func writeCAKey(path, pemKey string, keys *Keyring, replace bool) error {
+	data := pemKey
+	if keys != nil {
+		sealed, err := keys.Encrypt(pemKey)
+		if err != nil {
+			return err
+		}
+		data = sealed + "\n"
+	}
+	target := path
+	if replace {
+		target = path + ".tmp"
+		os.Remove(target)
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if replace {
+		return os.Rename(target, path)
+	}
+	return nil
+}
+
+// Certificate returns the SSH certificates last issued for a reservation, one
+// per line, or ""
+func (s *ReservationServiceDB) Certificate(ctx context.Context, id int64) (string, error) {
+	var cert string
+	err := s.db.QueryRowContext(ctx, `SELECT certificate FROM reservations WHERE id = ?`, id).Scan(&cert)
+	if err != nil {
+		return "", err
+	}
+	return cert, nil
+}
+
+// SetCertificate stores the SSH certificates issued for a reservation, one per line
+func (s *ReservationServiceDB) SetCertificate(ctx context.Context, id int64, cert string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE reservations SET certificate = ? WHERE id = ?`, cert, id)
+	return err
+}
+
+var ErrNoSSHKey = &reservationError{msg: "the user has no SSH public key"}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertificateMatches(t *testing.T) {
+	const ttl = time.Hour
+	ca, err := NewCertificateAuthority(filepath.Join(t.TempDir(), "ca"), ttl, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCertificateAuthority(filepath.Join(t.TempDir(), "other"), ttl, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey(t, "laptop")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testPublicKey(t, "desktop")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	r := &models.Reservation{ID: 1, StartTime: now.Add(-time.Hour), EndTime: now.Add(5 * time.Hour)}
+	short := &models.Reservation{ID: 1, StartTime: r.StartTime, EndTime: now.Add(10 * time.Minute)}
+	tests := []struct {
+		name        string
+		signer      *CertificateAuthority
+		key         ssh.PublicKey
+		principal   string
+		validAfter  time.Time
+		validBefore time.Time
+		r           *models.Reservation
+		want        bool
+	}{
+		{name: "more than half the ttl left", validBefore: now.Add(50 * time.Minute), want: true},
+		{name: "less than half the ttl left", validBefore: now.Add(20 * time.Minute)},
+		{name: "runs until the reservation's end", validBefore: short.EndTime, r: short, want: true},
+		{name: "past a shortened reservation's end", validBefore: now.Add(50 * time.Minute), r: short},
+		{name: "past the reservation's end", validBefore: r.EndTime.Add(time.Minute)},
+		{name: "expired", validBefore: now.Add(-time.Minute)},
+		{name: "moved start", validBefore: now.Add(50 * time.Minute), validAfter: now},
+		{name: "other principal", validBefore: now.Add(50 * time.Minute), principal: "admin"},
+		{name: "other key", validBefore: now.Add(50 * time.Minute), key: otherPub},
+		{name: "other CA", validBefore: now.Add(50 * time.Minute), signer: other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, key, principal, validAfter, res := ca, pub, "root", r.StartTime, r
+			if tt.signer != nil {
+				signer = tt.signer
+			}
+			if tt.key != nil {
+				key = tt.key
+			}
+			if tt.principal != "" {
+				principal = tt.principal
+			}
+			if !tt.validAfter.IsZero() {
+				validAfter = tt.validAfter
+			}
+			if tt.r != nil {
+				res = tt.r
+			}
+			cert := &ssh.Certificate{
+				Key:             key,
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{principal},
+				ValidAfter:      uint64(validAfter.Unix()),
+				ValidBefore:     uint64(tt.validBefore.Unix()),
+			}
+			if err := cert.SignCert(rand.Reader, signer.signer); err != nil {
+				t.Fatal(err)
+			}
+			stored := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert)))
+			validBefore, ok := ca.matches(stored, pub, "root", res, now)
+			if ok != tt.want {
+				t.Errorf("matches = %v, want %v", ok, tt.want)
+			}
+			if ok && !validBefore.Equal(tt.validBefore) {
+				t.Errorf("valid before %s, want %s", validBefore, tt.validBefore)
+			}
+		})
+	}
+}
+
+func TestIssueValidityWindow(t *testing.T) {
+	const ttl = time.Hour
+	tests := []struct {
+		name     string
+		duration time.Duration // of a reservation that started ten minutes ago
+		chosen   bool          // booked with the laptop key only
+		want     time.Duration // validity left after issuing
+		wantKeys []string
+	}{
+		{name: "long reservation is capped at the ttl", duration: 4 * time.Hour, want: ttl, wantKeys: []string{"laptop", "desktop"}},
+		{name: "short reservation ends with the reservation", duration: 40 * time.Minute, want: 30 * time.Minute, wantKeys: []string{"laptop", "desktop"}},
+		{name: "chosen key only", duration: 4 * time.Hour, chosen: true, want: ttl, wantKeys: []string{"laptop"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			ctx := context.Background()
+			servers := NewServerService(db, nil)
+			srv, err := servers.Create(ctx, &models.Server{Name: "lab", Hostname: "127.0.0.1", Port: 22, SSHUser: "root", AccessMode: models.AccessModeCertificate})
+			if err != nil {
+				t.Fatal(err)
+			}
+			users := NewUserService(db)
+			userID := addTestUser(t, db, "alice")
+			publicKeys := map[int64]string{}
+			var laptop int64
+			for _, name := range []string{"laptop", "desktop"} {
+				k, err := users.(*UserServiceDB).AddSSHKey(ctx, userID, name, testPublicKey(t, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				publicKeys[k.ID] = k.PublicKey
+				if name == "laptop" {
+					laptop = k.ID
+				}
+			}
+			var keyIDs []int64
+			if tt.chosen {
+				keyIDs = []int64{laptop}
+			}
+			res := NewReservationService(db, NewSlackService(""))
+			start := time.Now().UTC().Add(-10 * time.Minute).Truncate(time.Second)
+			r, err := res.CreateWithKeys(ctx, userID, srv.ID, start, start.Add(tt.duration), keyIDs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ca, err := NewCertificateAuthority(filepath.Join(t.TempDir(), "ca"), ttl, nil, res, servers, users)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			certs, validBefore, err := ca.Issue(ctx, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if left := time.Until(validBefore); left > tt.want || left < tt.want-time.Minute {
+				t.Errorf("certificate valid for %s more, want %s", left, tt.want)
+			}
+			if validBefore.After(r.EndTime) {
+				t.Errorf("certificate valid until %s, after the reservation's end %s", validBefore, r.EndTime)
+			}
+			var names []string
+			for _, cert := range certs {
+				names = append(names, cert.KeyName)
+				parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cert.Certificate))
+				if err != nil {
+					t.Fatal(err)
+				}
+				c := parsed.(*ssh.Certificate)
+				if c.ValidAfter != uint64(start.Unix()) || c.ValidBefore != uint64(validBefore.Unix()) {
+					t.Errorf("%s: certificate window [%d, %d), want [%d, %d)", cert.KeyName, c.ValidAfter, c.ValidBefore, start.Unix(), validBefore.Unix())
+				}
+				pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKeys[cert.SSHKeyID]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(c.Key.Marshal(), pub.Marshal()) {
+					t.Errorf("%s: certificate signs another key", cert.KeyName)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.wantKeys) {
+				t.Errorf("certified keys %v, want %v", names, tt.wantKeys)
+			}
+
+			// A second download reuses the stored certificates
+			again, _, err := ca.Issue(ctx, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(again, certs) {
+				t.Error("certificates were signed again")
+			}
+		})
+	}
+}
+
+func TestCAKeySealed(t *testing.T) {
+	keyring := func(t *testing.T, keys ...string) *Keyring {
+		k, err := LoadKeyring(strings.Join(keys, ","), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	newKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	path := filepath.Join(t.TempDir(), "ca")
+
+	steps := []struct {
+		name       string
+		keys       *Keyring
+		wantSealed string // key ID the file is sealed with; empty for plaintext
+	}{
+		{name: "generated in plaintext without a master key"},
+		{name: "sealed once a master key is set", keys: keyring(t, oldKey), wantSealed: keyring(t, oldKey).ActiveKeyID()},
+		{name: "sealed again after rotation", keys: keyring(t, newKey, oldKey), wantSealed: keyring(t, newKey).ActiveKeyID()},
+		{name: "loaded with the rotated key", keys: keyring(t, newKey), wantSealed: keyring(t, newKey).ActiveKeyID()},
+	}
+	var first string
+	for _, step := range steps {
+		ca, err := NewCertificateAuthority(path, time.Hour, step.keys, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if first == "" {
+			first = ca.PublicKey()
+		} else if ca.PublicKey() != first {
+			t.Errorf("%s: CA key changed", step.name)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed := strings.HasPrefix(string(data), encryptedPrefix+step.wantSealed+":")
+		if step.wantSealed == "" {
+			sealed = !IsEncrypted(string(data)) && strings.Contains(string(data), "PRIVATE KEY")
+		}
+		if !sealed {
+			t.Errorf("%s: file is not stored as expected: %.40s", step.name, data)
+		}
+	}
+	if _, err := NewCertificateAuthority(path, time.Hour, nil, nil, nil, nil); err == nil {
+		t.Error("sealed CA key loaded without a master key")
+	}
+}
+
+// testPublicKey generates an ed25519 key in authorized_keys format
+func testPublicKey(t *testing.T, comment string) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " " + comment
+}
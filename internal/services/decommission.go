@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// DecommissionServer retires srv: it stops taking bookings, its open
+// reservations are cancelled (owners are told on Slack) and the keys of those
+// that were active are revoked, their live sessions handled by the server's
+// session policy. Reservations are cancelled before keys are revoked so that
+// the scheduler cannot activate one in between. Failed key removals are
+// queued and retried like any other. The host key is forgotten last, once no
+// removal is queued, so the connections that revoke access still check it.
+// The cancelled reservations are returned.
+func DecommissionServer(ctx context.Context, servers ServerService, res ReservationService, users UserService, ssh SSHService, srv *models.Server) ([]models.Reservation, error) {
+	if err := servers.Decommission(ctx, srv.ID); err != nil {
+		return nil, err
+	}
+	cancelled, err := res.CancelByServer(ctx, srv.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range cancelled {
+		if r.Status != "active" {
+			continue
+		}
+		usr, err := users.GetByID(ctx, r.UserID)
+		if err != nil || usr == nil {
+			continue
+		}
+		keys, err := users.ListSSHKeys(ctx, r.UserID)
+		if err != nil {
+			continue
+		}
+		// A certificate cannot be taken back; it runs out within the CA's ttl
+		if srv.AccessMode != models.AccessModeCertificate {
+			for _, key := range InstalledKeys(&r, keys) {
+				if err := RemoveKeyOrQueue(ctx, res, ssh, srv, r.UserID, key, KeyMarker(r.ID, usr.Username)); err != nil {
+					slog.Warn("remove key failed, queued for retry", "reservation_id", r.ID, "user_id", r.UserID, "server_id", srv.ID, "error", err)
+				}
+			}
+		}
+		if _, err := EndSessions(ctx, res, ssh, srv, &r, keys); err != nil {
+			slog.Warn("ending live sessions failed", "reservation_id", r.ID, "server_id", srv.ID, "error", err)
+		}
+	}
+	if err := servers.ForgetHostKey(ctx, srv.ID); err != nil {
+		slog.Warn("forgetting host key failed", "server_id", srv.ID, "error", err)
+	}
+	slog.Info("server decommissioned", "server_id", srv.ID, "name", srv.Name, "cancelled", len(cancelled))
+	return cancelled, nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// driftRetention is how long drift reports are kept
+const driftRetention = 30 * 24 * time.Hour
+
+// DriftServiceDB implements DriftService
+type DriftServiceDB struct {
+	db *sql.DB
+}
+
+// NewDriftService creates a DriftService
+func NewDriftService(db *sql.DB) DriftService {
+	return &DriftServiceDB{db: db}
+}
+
+// Record stores the reports of one reconcile run, filling in their ID and
+// CreatedAt, and prunes old ones
+func (s *DriftServiceDB) Record(ctx context.Context, reports []models.DriftReport) error {
+	now := time.Now().UTC()
+	for i := range reports {
+		r := &reports[i]
+		res, err := s.db.ExecContext(ctx,
+			`INSERT INTO drift_reports (server_id, kind, user_id, username, detail, repaired, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			r.ServerID, r.Kind, r.UserID, r.Username, r.Detail, r.Repaired, now,
+		)
+		if err != nil {
+			return err
+		}
+		r.ID, _ = res.LastInsertId()
+		r.CreatedAt = now
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM drift_reports WHERE created_at < ?`, now.Add(-driftRetention))
+	return err
+}
+
+// List returns the most recent reports first
+func (s *DriftServiceDB) List(ctx context.Context, limit int) ([]models.DriftReport, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT d.id, d.server_id, COALESCE(s.name, ''), d.kind, d.user_id, d.username, d.detail, d.repaired, d.created_at
+		 FROM drift_reports d
+		 LEFT JOIN servers s ON d.server_id = s.id
+		 ORDER BY d.created_at DESC, d.id DESC
+		 LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []models.DriftReport
+	for rows.Next() {
+		var r models.DriftReport
+		var userID sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.ServerID, &r.ServerName, &r.Kind, &userID, &r.Username, &r.Detail, &r.Repaired, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			r.UserID = &userID.Int64
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
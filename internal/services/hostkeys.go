@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// errHostKeyScanned aborts the handshake once scanHostKey has seen the key
+var errHostKeyScanned = errors.New("host key scanned")
+
+// HostKey returns the SHA256 fingerprint of the host key the server presents
+// now, without authenticating or checking it against the trusted key
+func (s *SSHServiceImpl) HostKey(ctx context.Context, hostname string, port int) (string, error) {
+	key, err := s.scanHostKey(ctx, hostname, port)
+	if err != nil {
+		return "", err
+	}
+	return ssh.FingerprintSHA256(key), nil
+}
+
+// TrustHostKey replaces the trusted host key of hostname:port with the key the
+// server presents now. fingerprint must match that key, so the admin confirms
+// which key they checked out of band.
+func (s *SSHServiceImpl) TrustHostKey(ctx context.Context, hostname string, port int, fingerprint string) error {
+	key, err := s.scanHostKey(ctx, hostname, port)
+	if err != nil {
+		return err
+	}
+	presented := ssh.FingerprintSHA256(key)
+	if strings.TrimSpace(fingerprint) != presented {
+		return &FingerprintMismatchError{Presented: presented}
+	}
+	addr := hostAddr(hostname, port)
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO known_hosts (address, host_key, fingerprint, updated_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(address) DO UPDATE SET host_key = excluded.host_key, fingerprint = excluded.fingerprint, updated_at = excluded.updated_at`,
+		addr, marshalHostKey(key), presented, time.Now().UTC(),
+	); err != nil {
+		return err
+	}
+	slog.Info("host key trusted", "address", addr, "fingerprint", presented)
+	return nil
+}
+
+// hostKeyCallback checks the server's key against known_hosts. The first key
+// seen for an address is recorded (trust on first use); afterwards any other
+// key fails the connection until an admin re-trusts it.
+func (s *SSHServiceImpl) hostKeyCallback(ctx context.Context, addr string) ssh.HostKeyCallback {
+	return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+		presented := marshalHostKey(key)
+		// INSERT OR IGNORE keeps the first key when two connections race
+		res, err := s.db.ExecContext(ctx,
+			`INSERT OR IGNORE INTO known_hosts (address, host_key, fingerprint, updated_at) VALUES (?, ?, ?, ?)`,
+			addr, presented, ssh.FingerprintSHA256(key), time.Now().UTC(),
+		)
+		if err != nil {
+			return fmt.Errorf("record host key: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			slog.Warn("host key recorded on first use", "address", addr, "fingerprint", ssh.FingerprintSHA256(key))
+			return nil
+		}
+		var trusted, fingerprint string
+		if err := s.db.QueryRowContext(ctx,
+			`SELECT host_key, fingerprint FROM known_hosts WHERE address = ?`, addr,
+		).Scan(&trusted, &fingerprint); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("host key for %s was removed concurrently", addr)
+			}
+			return fmt.Errorf("read host key: %w", err)
+		}
+		if trusted != presented {
+			slog.Error("host key mismatch", "address", addr, "trusted", fingerprint, "presented", ssh.FingerprintSHA256(key))
+			return &HostKeyMismatchError{Address: addr, Trusted: fingerprint, Presented: ssh.FingerprintSHA256(key)}
+		}
+		return nil
+	}
+}
+
+// scanHostKey completes just enough of the handshake to read the server's key
+func (s *SSHServiceImpl) scanHostKey(ctx context.Context, hostname string, port int) (ssh.PublicKey, error) {
+	var key ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: "serverscheduler",
+		HostKeyCallback: func(_ string, _ net.Addr, k ssh.PublicKey) error {
+			key = k
+			return errHostKeyScanned
+		},
+		Timeout: 10 * time.Second,
+	}
+	conn, err := ssh.Dial("tcp", hostAddr(hostname, port), config)
+	if err == nil {
+		conn.Close()
+	}
+	if key == nil {
+		return nil, fmt.Errorf("ssh scan host key: %w", err)
+	}
+	return key, nil
+}
+
+// hostAddr is the dial address and the known_hosts key of a server
+func hostAddr(hostname string, port int) string {
+	return fmt.Sprintf("%s:%d", hostname, port)
+}
+
+func marshalHostKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// HostKeyMismatchError means a server presented a different host key than the
+// one trusted for its address, e.g. after a rebuild or because of a man in the middle
+type HostKeyMismatchError struct {
+	Address   string
+	Trusted   string // SHA256 fingerprint on record
+	Presented string // SHA256 fingerprint the server sent
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key of %s changed: trusted %s, server presented %s; re-trust it only if the server was rebuilt", e.Address, e.Trusted, e.Presented)
+}
+
+// FingerprintMismatchError means TrustHostKey was given a fingerprint that is
+// not the one the server presents
+type FingerprintMismatchError struct {
+	Presented string
+}
+
+func (e *FingerprintMismatchError) Error() string {
+	return "the server presents host key " + e.Presented + ", not the fingerprint given"
+}
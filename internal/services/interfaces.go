@@ -13,9 +13,22 @@ type UserService interface {
 	GetByID(ctx context.Context, id int64) (*models.User, error)
 	Create(ctx context.Context, username, password string, role string) (*models.User, error)
 	CreateWithSSHKey(ctx context.Context, username, password, sshPublicKey string) (*models.User, error)
-	UpdateSSHKey(ctx context.Context, username, sshPublicKey string) error
 	List(ctx context.Context) ([]models.UserPublic, error)
 	Delete(ctx context.Context, id int64) error
+	AddSSHKey(ctx context.Context, userID int64, name, publicKey string) (*models.SSHKey, error)
+	ListSSHKeys(ctx context.Context, userID int64) ([]models.SSHKey, error)
+	ListAllSSHKeys(ctx context.Context) ([]models.SSHKey, error)
+	DeleteSSHKey(ctx context.Context, id, userID int64) (*models.SSHKey, error)
+	MarkSSHKeysUsed(ctx context.Context, ids []int64) error
+	MigrateSSHKeys(ctx context.Context) (int, []string, error)
+}
+
+// APITokenService manages personal API tokens
+type APITokenService interface {
+	Create(ctx context.Context, userID int64, name string, expiresAt *time.Time) (string, *models.APIToken, error)
+	List(ctx context.Context, userID int64) ([]models.APIToken, error)
+	Revoke(ctx context.Context, id, userID int64) error
+	Authenticate(ctx context.Context, token string) (*models.User, error)
 }
 
 // ServerService handles server operations
@@ -23,7 +36,14 @@ type ServerService interface {
 	List(ctx context.Context) ([]models.Server, error)
 	Get(ctx context.Context, id int64) (*models.Server, error)
 	Create(ctx context.Context, s *models.Server) (*models.Server, error)
-	Delete(ctx context.Context, id int64) error
+	Update(ctx context.Context, s *models.Server) (*models.Server, error)
+	SetApproval(ctx context.Context, id int64, requiresApproval bool, ownerID *int64) error
+	SetAccessMode(ctx context.Context, id int64, mode string) error
+	SetSessionPolicy(ctx context.Context, id int64, policy string) error
+	SetKeypair(ctx context.Context, id int64, privateKey, publicKey string) error
+	EncryptPrivateKeys(ctx context.Context, rotate bool) (int, error)
+	Decommission(ctx context.Context, id int64) error
+	ForgetHostKey(ctx context.Context, id int64) error
 }
 
 // ReservationService handles reservation operations
@@ -31,22 +51,74 @@ type ReservationService interface {
 	Get(ctx context.Context, id int64) (*models.Reservation, error)
 	List(ctx context.Context, userID *int64) ([]models.ReservationWithDetails, error)
 	Create(ctx context.Context, userID, serverID int64, start, end time.Time) (*models.Reservation, error)
+	CreateWithKeys(ctx context.Context, userID, serverID int64, start, end time.Time, keyIDs []int64) (*models.Reservation, error)
+	UpdateEndTime(ctx context.Context, id int64, end time.Time) (*models.Reservation, error)
 	Cancel(ctx context.Context, id, userID int64) error
 	CancelByAdmin(ctx context.Context, id int64) error
+	CancelByServer(ctx context.Context, serverID int64) ([]models.Reservation, error)
+	Release(ctx context.Context, id int64) error
+	Approve(ctx context.Context, id int64, decidedBy, reason string) (*models.Reservation, error)
+	Reject(ctx context.Context, id int64, decidedBy, reason string) (*models.Reservation, error)
+	ListRequested(ctx context.Context, ownerID *int64) ([]models.ReservationWithDetails, error)
 	DeleteByUserID(ctx context.Context, userID int64) error
 	GetPendingToActivate(ctx context.Context) ([]models.Reservation, error)
 	GetActiveToExpire(ctx context.Context) ([]models.Reservation, error)
-	Activate(ctx context.Context, id int64) error
+	GetScheduled(ctx context.Context) ([]models.Reservation, error)
+	Changes() <-chan struct{}
+	Activate(ctx context.Context, id int64, installedKeys []string) error
+	SetInstalledKeys(ctx context.Context, id int64, installedKeys []string) error
+	SetSessionsKilled(ctx context.Context, id int64, n int) error
+	ClaimReminder(ctx context.Context, id int64, lead time.Duration, endTime time.Time) (bool, error)
 	Expire(ctx context.Context, id int64) error
+	ActivationFailed(ctx context.Context, id int64, attempts int, lastErr string, nextRetryAt *time.Time) error
+	QueueKeyRemoval(ctx context.Context, userID, serverID int64, publicKey, marker, lastErr string, nextRetryAt time.Time) error
+	ListKeyRemovals(ctx context.Context) ([]models.KeyRemoval, error)
+	KeyRemovalFailed(ctx context.Context, id int64, attempts int, lastErr string, nextRetryAt time.Time) error
+	KeyRemovalDone(ctx context.Context, id int64) error
+	Certificate(ctx context.Context, id int64) (string, error)
+	SetCertificate(ctx context.Context, id int64, cert string) error
 	GetUsersByServer(ctx context.Context) (map[int64][]string, error)
 	GetCurrentByServer(ctx context.Context) (map[int64]*models.ReservationWithDetails, error)
+	CreateSeries(ctx context.Context, userID, serverID int64, start, end time.Time, rule RecurrenceRule, exceptions []time.Time) (*models.ReservationSeries, []models.SeriesOccurrence, error)
+	GetSeries(ctx context.Context, id int64) (*models.ReservationSeries, error)
+	ListSeries(ctx context.Context, userID *int64) ([]models.ReservationSeriesWithDetails, error)
+	CancelSeries(ctx context.Context, id int64) error
+	Utilization(ctx context.Context, from, to time.Time) ([]models.ServerUtilization, error)
+}
+
+// PolicyService manages booking policies enforced by ReservationService
+type PolicyService interface {
+	List(ctx context.Context) ([]models.BookingPolicyWithDetails, error)
+	Get(ctx context.Context, id int64) (*models.BookingPolicy, error)
+	Save(ctx context.Context, p *models.BookingPolicy) (*models.BookingPolicy, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+// WaitlistService queues users for busy servers and promotes them when slots free up
+type WaitlistService interface {
+	Join(ctx context.Context, userID, serverID int64, start, end time.Time) (*models.WaitlistEntry, error)
+	Get(ctx context.Context, id int64) (*models.WaitlistEntry, error)
+	List(ctx context.Context, userID *int64) ([]models.WaitlistEntryWithDetails, error)
+	Cancel(ctx context.Context, id int64) error
+	Promote(ctx context.Context, serverID int64) ([]models.WaitlistEntry, error)
+}
+
+// DriftService stores what the reconciler found on the servers
+type DriftService interface {
+	Record(ctx context.Context, reports []models.DriftReport) error
+	List(ctx context.Context, limit int) ([]models.DriftReport, error)
 }
 
 // SSHService manages SSH keys on remote servers
 type SSHService interface {
-	AddKey(ctx context.Context, hostname string, port int, sshUser, privateKey, publicKey string) error
-	RemoveKey(ctx context.Context, hostname string, port int, sshUser, privateKey, publicKey string) error
+	AddKey(ctx context.Context, hostname string, port int, sshUser, privateKey, publicKey, marker string) error
+	RemoveKey(ctx context.Context, hostname string, port int, sshUser, privateKey, publicKey, marker string) error
+	ListKeys(ctx context.Context, hostname string, port int, sshUser, privateKey string) ([]string, error)
+	MessageSessions(ctx context.Context, hostname string, port int, sshUser, privateKey string, publicKeys []string, since time.Time, message string) (int, error)
+	TerminateSessions(ctx context.Context, hostname string, port int, sshUser, privateKey string, publicKeys []string, since time.Time, message string) (int, error)
 	TestConnection(ctx context.Context, hostname string, port int, sshUser, privateKey string) error
+	HostKey(ctx context.Context, hostname string, port int) (string, error)
+	TrustHostKey(ctx context.Context, hostname string, port int, fingerprint string) error
 }
 
 // SlackService sends notifications to Slack
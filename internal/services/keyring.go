@@ -0,0 +1,173 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix marks a value sealed by a Keyring. The full format is
+// enc:v1:<key id>:<wrapped data key>:<ciphertext>, both base64.
+const encryptedPrefix = "enc:v1:"
+
+// keyringAAD binds ciphertexts to what they protect
+var keyringAAD = []byte("serverscheduler servers.ssh_private_key")
+
+// Keyring seals secrets with envelope encryption: every value gets its own
+// random AES-256-GCM data key, which is itself sealed with a master key. The
+// first master key encrypts; the others only decrypt, so old rows stay
+// readable during a rotation.
+type Keyring struct {
+	keys   map[string]cipher.AEAD
+	active string
+}
+
+// LoadKeyring reads master keys from keys (usually MASTER_KEY) and the file at
+// path (MASTER_KEY_FILE). Keys are base64-encoded 32 byte values separated by
+// commas or whitespace; the first one is active. With no keys it returns nil
+// and secrets are stored in plaintext.
+func LoadKeyring(keys, path string) (*Keyring, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read master key file: %w", err)
+		}
+		keys += "\n" + string(data)
+	}
+	fields := strings.FieldsFunc(keys, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for i, f := range fields {
+		raw, err := base64.StdEncoding.DecodeString(f)
+		if err != nil || len(raw) != 32 {
+			return nil, fmt.Errorf("master key %d must be 32 bytes, base64-encoded", i+1)
+		}
+		aead, err := newGCM(raw)
+		if err != nil {
+			return nil, err
+		}
+		id := masterKeyID(raw)
+		k.keys[id] = aead
+		if i == 0 {
+			k.active = id
+		}
+	}
+	return k, nil
+}
+
+// ActiveKeyID returns the ID of the master key new values are sealed with
+func (k *Keyring) ActiveKeyID() string { return k.active }
+
+// Encrypt seals plaintext under a fresh data key wrapped by the active master key
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.active], dataKey)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + k.active + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt opens a value sealed by Encrypt with any of the keyring's master
+// keys. Values without the encrypted prefix are returned unchanged.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformedSecret
+	}
+	if k == nil {
+		return "", ErrMasterKeyRequired
+	}
+	master, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("no master key with id %s is configured", parts[0])
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformedSecret
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformedSecret
+	}
+	dataKey, err := open(master, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("unwrap data key: %w", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted reports whether value was sealed by a Keyring
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// masterKeyID is a short, stable ID derived from the key itself so that
+// keys need no separate naming
+func masterKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce || ciphertext
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, keyringAAD), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformedSecret
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], keyringAAD)
+}
+
+var ErrMasterKeyRequired = &keyringError{msg: "server keys are encrypted; set MASTER_KEY or MASTER_KEY_FILE"}
+var ErrMalformedSecret = &keyringError{msg: "malformed encrypted value"}
+
+type keyringError struct{ msg string }
+
+func (e *keyringError) Error() string { return e.msg }
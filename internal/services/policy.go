@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// Policy rules reported in PolicyViolationError.Rule
+const (
+	RuleMaxDuration     = "max_duration"
+	RuleMaxConcurrent   = "max_concurrent"
+	RuleMaxHoursPerWeek = "max_hours_per_week"
+	RuleMaxAdvance      = "max_advance"
+	RuleMinGap          = "min_gap"
+)
+
+// PolicyServiceDB implements PolicyService
+type PolicyServiceDB struct {
+	db *sql.DB
+}
+
+// NewPolicyService creates a PolicyService
+func NewPolicyService(db *sql.DB) PolicyService {
+	return &PolicyServiceDB{db: db}
+}
+
+func (s *PolicyServiceDB) List(ctx context.Context) ([]models.BookingPolicyWithDetails, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+policyColumns+`, COALESCE(s.name, '')
+		 FROM booking_policies p
+		 LEFT JOIN servers s ON p.server_id = s.id
+		 ORDER BY p.server_id IS NOT NULL, s.name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []models.BookingPolicyWithDetails
+	for rows.Next() {
+		var p models.BookingPolicyWithDetails
+		policy, err := scanPolicy(rows, &p.ServerName)
+		if err != nil {
+			return nil, err
+		}
+		p.BookingPolicy = policy
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+func (s *PolicyServiceDB) Get(ctx context.Context, id int64) (*models.BookingPolicy, error) {
+	p, err := scanPolicy(s.db.QueryRowContext(ctx,
+		`SELECT `+policyColumns+` FROM booking_policies p WHERE p.id = ?`,
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Save creates or replaces the policy for p.ServerID (nil for the global
+// policy) in one upsert on the unique scope index, so concurrent saves cannot
+// create two policies for the same scope
+func (s *PolicyServiceDB) Save(ctx context.Context, p *models.BookingPolicy) (*models.BookingPolicy, error) {
+	for _, v := range []int{p.MaxDurationMinutes, p.MaxConcurrent, p.MaxHoursPerWeek, p.MaxAdvanceDays, p.MinGapMinutes} {
+		if v < 0 {
+			return nil, ErrInvalidPolicy
+		}
+	}
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO booking_policies (server_id, max_duration_minutes, max_concurrent, max_hours_per_week, max_advance_days, min_gap_minutes, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(IFNULL(server_id, 0)) DO UPDATE SET max_duration_minutes = excluded.max_duration_minutes,
+		 max_concurrent = excluded.max_concurrent, max_hours_per_week = excluded.max_hours_per_week,
+		 max_advance_days = excluded.max_advance_days, min_gap_minutes = excluded.min_gap_minutes, updated_at = excluded.updated_at`,
+		p.ServerID, p.MaxDurationMinutes, p.MaxConcurrent, p.MaxHoursPerWeek, p.MaxAdvanceDays, p.MinGapMinutes, now,
+	); err != nil {
+		return nil, err
+	}
+	saved, err := scanPolicy(s.db.QueryRowContext(ctx,
+		`SELECT `+policyColumns+` FROM booking_policies p WHERE IFNULL(p.server_id, 0) = IFNULL(?, 0)`,
+		p.ServerID,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func (s *PolicyServiceDB) Delete(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM booking_policies WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrPolicyNotFound
+	}
+	return nil
+}
+
+// checkPolicies enforces the global policy and the server's own policy on a booking
+// of [start, end). excludeID skips an existing reservation that is being changed.
+func checkPolicies(ctx context.Context, q querier, userID, serverID int64, start, end time.Time, excludeID int64) error {
+	rows, err := q.QueryContext(ctx,
+		`SELECT `+policyColumns+` FROM booking_policies p WHERE p.server_id IS NULL OR p.server_id = ?`,
+		serverID,
+	)
+	if err != nil {
+		return err
+	}
+	var policies []models.BookingPolicy
+	for rows.Next() {
+		p, err := scanPolicy(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		policies = append(policies, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, p := range policies {
+		if err := checkPolicy(ctx, q, p, userID, serverID, start, end, excludeID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPolicy(ctx context.Context, q querier, p models.BookingPolicy, userID, serverID int64, start, end time.Time, excludeID int64) error {
+	scope := "global policy"
+	// Per-user limits of a server policy count bookings on that server only
+	scopeSQL, scopeArgs := "", []interface{}{}
+	if p.ServerID != nil {
+		scope = "server policy"
+		scopeSQL, scopeArgs = " AND server_id = ?", []interface{}{*p.ServerID}
+	}
+	violation := func(rule, format string, args ...interface{}) error {
+		return &PolicyViolationError{Rule: rule, Scope: scope, msg: fmt.Sprintf(format, args...)}
+	}
+	now := time.Now().UTC()
+
+	if p.MaxDurationMinutes > 0 && end.Sub(start) > time.Duration(p.MaxDurationMinutes)*time.Minute {
+		return violation(RuleMaxDuration, "reservations may last at most %s", formatMinutes(p.MaxDurationMinutes))
+	}
+	if p.MaxAdvanceDays > 0 && start.After(now.AddDate(0, 0, p.MaxAdvanceDays)) {
+		return violation(RuleMaxAdvance, "reservations may start at most %d days ahead", p.MaxAdvanceDays)
+	}
+	if p.MinGapMinutes > 0 {
+		gap := time.Duration(p.MinGapMinutes) * time.Minute
+		var count int
+		if err := q.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM reservations WHERE server_id = ? AND id != ? AND status IN ('requested','pending','active')
+			 AND start_time < ? AND end_time > ?`,
+			serverID, excludeID, end.Add(gap), start.Add(-gap),
+		).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return violation(RuleMinGap, "bookings on this server need a gap of %s", formatMinutes(p.MinGapMinutes))
+		}
+	}
+	if p.MaxConcurrent > 0 && excludeID == 0 {
+		var count int
+		if err := q.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM reservations WHERE user_id = ? AND status IN ('requested','pending','active') AND end_time > ?`+scopeSQL,
+			append([]interface{}{userID, now}, scopeArgs...)...,
+		).Scan(&count); err != nil {
+			return err
+		}
+		if count >= p.MaxConcurrent {
+			return violation(RuleMaxConcurrent, "at most %d open reservations per user", p.MaxConcurrent)
+		}
+	}
+	if p.MaxHoursPerWeek > 0 {
+		limit := float64(p.MaxHoursPerWeek)
+		for week := weekStart(start); week.Before(end); week = week.AddDate(0, 0, 7) {
+			used, err := userHoursInWeek(ctx, q, userID, week, excludeID, scopeSQL, scopeArgs)
+			if err != nil {
+				return err
+			}
+			if used+overlapHours(start, end, week, week.AddDate(0, 0, 7)) > limit {
+				return violation(RuleMaxHoursPerWeek, "at most %d hours per user per week (week of %s)", p.MaxHoursPerWeek, week.Format("2006-01-02"))
+			}
+		}
+	}
+	return nil
+}
+
+// userHoursInWeek sums the user's booked hours within the week starting at week.
+// Reservations that ended early count only the time actually used.
+func userHoursInWeek(ctx context.Context, q querier, userID int64, week time.Time, excludeID int64, scopeSQL string, scopeArgs []interface{}) (float64, error) {
+	weekEnd := week.AddDate(0, 0, 7)
+	rows, err := q.QueryContext(ctx,
+		`SELECT start_time, end_time, actual_end_time FROM reservations
+		 WHERE user_id = ? AND id != ? AND start_time < ? AND end_time > ?
+		 AND (status IN ('requested','pending','active','expired','released') OR (status = 'cancelled' AND actual_end_time IS NOT NULL))`+scopeSQL,
+		append([]interface{}{userID, excludeID, weekEnd, week}, scopeArgs...)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var hours float64
+	for rows.Next() {
+		var start, end time.Time
+		var actualEnd sql.NullTime
+		if err := rows.Scan(&start, &end, &actualEnd); err != nil {
+			return 0, err
+		}
+		if actualEnd.Valid {
+			end = actualEnd.Time
+		}
+		hours += overlapHours(start, end, week, weekEnd)
+	}
+	return hours, rows.Err()
+}
+
+// weekStart returns Monday 00:00 UTC of t's week
+func weekStart(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+func formatMinutes(m int) string {
+	if m == 60 {
+		return "1 hour"
+	}
+	if m%60 == 0 {
+		return fmt.Sprintf("%d hours", m/60)
+	}
+	return fmt.Sprintf("%d minutes", m)
+}
+
+// policyColumns is the column list read by scanPolicy; queries alias booking_policies as p
+const policyColumns = `p.id, p.server_id, p.max_duration_minutes, p.max_concurrent, p.max_hours_per_week, p.max_advance_days, p.min_gap_minutes, p.updated_at`
+
+func scanPolicy(row rowScanner, extra ...interface{}) (models.BookingPolicy, error) {
+	var p models.BookingPolicy
+	var serverID sql.NullInt64
+	dest := append([]interface{}{&p.ID, &serverID, &p.MaxDurationMinutes, &p.MaxConcurrent, &p.MaxHoursPerWeek, &p.MaxAdvanceDays, &p.MinGapMinutes, &p.UpdatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return p, err
+	}
+	if serverID.Valid {
+		p.ServerID = &serverID.Int64
+	}
+	return p, nil
+}
+
+// PolicyViolationError reports which booking rule a reservation breaks
+type PolicyViolationError struct {
+	Rule  string // one of the Rule* constants
+	Scope string // "global policy" or "server policy"
+	msg   string
+}
+
+func (e *PolicyViolationError) Error() string { return "booking policy: " + e.msg }
+
+var ErrInvalidPolicy = &policyError{msg: "policy limits cannot be negative"}
+var ErrPolicyNotFound = &policyError{msg: "policy not found"}
+
+type policyError struct{ msg string }
+
+func (e *policyError) Error() string { return e.msg }
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	servers := NewServerService(db, nil)
+	srv, err := servers.Create(ctx, &models.Server{Name: "lab", Hostname: "10.0.0.1", Port: 22, SSHUser: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := servers.Create(ctx, &models.Server{Name: "other", Hostname: "10.0.0.2", Port: 22, SSHUser: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := addTestUser(t, db, "alice")
+
+	// The existing booking is 09:00 to 11:00 on a Monday one to two weeks ahead
+	base := weekStart(time.Now()).AddDate(0, 0, 14).Add(9 * time.Hour)
+	existing, err := NewReservationService(db, NewSlackService("")).Create(ctx, userID, srv.ID, base, base.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		policy     models.BookingPolicy
+		serverID   int64
+		start, end time.Duration // offsets from base
+		excludeID  int64
+		wantRule   string // empty means allowed
+	}{
+		{name: "no limits", start: 3 * time.Hour, end: 5 * time.Hour},
+		{name: "too long", policy: models.BookingPolicy{MaxDurationMinutes: 60}, start: 3 * time.Hour, end: 5 * time.Hour, wantRule: RuleMaxDuration},
+		{name: "within duration", policy: models.BookingPolicy{MaxDurationMinutes: 120}, start: 3 * time.Hour, end: 5 * time.Hour},
+		{name: "too far ahead", policy: models.BookingPolicy{MaxAdvanceDays: 3}, start: 3 * time.Hour, end: 4 * time.Hour, wantRule: RuleMaxAdvance},
+		{name: "within advance", policy: models.BookingPolicy{MaxAdvanceDays: 30}, start: 3 * time.Hour, end: 4 * time.Hour},
+		{name: "gap too short", policy: models.BookingPolicy{MinGapMinutes: 60}, start: 150 * time.Minute, end: 210 * time.Minute, wantRule: RuleMinGap},
+		{name: "gap kept", policy: models.BookingPolicy{MinGapMinutes: 60}, start: 3 * time.Hour, end: 4 * time.Hour},
+		{name: "gap on another server", policy: models.BookingPolicy{MinGapMinutes: 60}, serverID: other.ID, start: 150 * time.Minute, end: 210 * time.Minute},
+		{name: "gap to the booking being changed", policy: models.BookingPolicy{MinGapMinutes: 60}, start: 150 * time.Minute, end: 210 * time.Minute, excludeID: existing.ID},
+		{name: "too many open bookings", policy: models.BookingPolicy{MaxConcurrent: 1}, start: 3 * time.Hour, end: 4 * time.Hour, wantRule: RuleMaxConcurrent},
+		{name: "open bookings within limit", policy: models.BookingPolicy{MaxConcurrent: 2}, start: 3 * time.Hour, end: 4 * time.Hour},
+		{name: "open bookings ignored on change", policy: models.BookingPolicy{MaxConcurrent: 1}, start: 3 * time.Hour, end: 4 * time.Hour, excludeID: existing.ID},
+		{name: "weekly hours used up", policy: models.BookingPolicy{MaxHoursPerWeek: 3}, start: 3 * time.Hour, end: 5 * time.Hour, wantRule: RuleMaxHoursPerWeek},
+		{name: "weekly hours left", policy: models.BookingPolicy{MaxHoursPerWeek: 4}, start: 3 * time.Hour, end: 5 * time.Hour},
+		{name: "weekly hours in the next week", policy: models.BookingPolicy{MaxHoursPerWeek: 3}, start: 7 * 24 * time.Hour, end: 7*24*time.Hour + 2*time.Hour},
+		{name: "weekly hours of the booking being changed", policy: models.BookingPolicy{MaxHoursPerWeek: 3}, start: 0, end: 3 * time.Hour, excludeID: existing.ID},
+		{name: "server policy counts its server only", policy: models.BookingPolicy{ServerID: &other.ID, MaxHoursPerWeek: 3}, serverID: other.ID, start: 3 * time.Hour, end: 5 * time.Hour},
+		{name: "global policy counts every server", policy: models.BookingPolicy{MaxHoursPerWeek: 3}, serverID: other.ID, start: 3 * time.Hour, end: 5 * time.Hour, wantRule: RuleMaxHoursPerWeek},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverID := tt.serverID
+			if serverID == 0 {
+				serverID = srv.ID
+			}
+			err := checkPolicy(ctx, db, tt.policy, userID, serverID, base.Add(tt.start), base.Add(tt.end), tt.excludeID)
+			if tt.wantRule == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var violation *PolicyViolationError
+			if !errors.As(err, &violation) {
+				t.Fatalf("got %v, want a %s violation", err, tt.wantRule)
+			}
+			if violation.Rule != tt.wantRule {
+				t.Errorf("rule = %s, want %s", violation.Rule, tt.wantRule)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// restoreMargin keeps the reconciler from restoring a key of a reservation that
+// is about to expire, which would race with the scheduler revoking it
+const restoreMargin = time.Minute
+
+// Reconciler periodically compares each server's authorized_keys with the keys
+// that reservations say should be present. Stray lines the scheduler wrote
+// (see KeyMarker) are removed, missing keys of active reservations are
+// restored, and every difference is recorded as a drift report and posted to
+// Slack. Lines without the marker, such as the server's own admin keys or keys
+// users installed themselves, are left alone.
+type Reconciler struct {
+	reservation ReservationService
+	server      ServerService
+	user        UserService
+	ssh         SSHService
+	slack       SlackService
+	drift       DriftService
+	interval    time.Duration
+	mu          sync.Mutex // one run at a time
+}
+
+// NewReconciler creates a Reconciler that runs every interval; zero disables the loop
+func NewReconciler(res ReservationService, srv ServerService, usr UserService, ssh SSHService, slack SlackService, drift DriftService, interval time.Duration) *Reconciler {
+	return &Reconciler{
+		reservation: res,
+		server:      srv,
+		user:        usr,
+		ssh:         ssh,
+		slack:       slack,
+		drift:       drift,
+		interval:    interval,
+	}
+}
+
+// managedKey is the owner of a key the scheduler grants access with
+type managedKey struct {
+	userID   int64
+	username string
+}
+
+// userKeys are a user's registered keys
+type userKeys struct {
+	username string
+	keys     []models.SSHKey
+}
+
+// Start runs the reconcile loop until ctx is cancelled
+func (r *Reconciler) Start(ctx context.Context) {
+	if r.interval <= 0 {
+		slog.Info("reconciler disabled")
+		return
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Run(ctx); err != nil {
+				slog.Error("reconcile failed", "error", err)
+			}
+		}
+	}
+}
+
+// Reports returns the most recent drift reports
+func (r *Reconciler) Reports(ctx context.Context, limit int) ([]models.DriftReport, error) {
+	return r.drift.List(ctx, limit)
+}
+
+// Run checks every server once, repairs what it can and returns the drift found
+func (r *Reconciler) Run(ctx context.Context) ([]models.DriftReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	servers, err := r.server.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := r.user.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := r.user.ListAllSSHKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[int64]userKeys)
+	for _, u := range users {
+		byUser[u.ID] = userKeys{username: u.Username}
+	}
+	byKey := make(map[string]managedKey)
+	for _, k := range keys {
+		u := byUser[k.UserID]
+		u.keys = append(u.keys, k)
+		byUser[k.UserID] = u
+		byKey[keyID(k.PublicKey)] = managedKey{userID: k.UserID, username: u.username}
+	}
+
+	var reports []models.DriftReport
+	for _, listed := range servers {
+		// List leaves out the private key
+		srv, err := r.server.Get(ctx, listed.ID)
+		if err != nil || srv == nil {
+			continue
+		}
+		reports = append(reports, r.reconcileServer(ctx, *srv, byKey, byUser)...)
+	}
+	if err := r.drift.Record(ctx, reports); err != nil {
+		return reports, err
+	}
+	slog.Info("reconcile finished", "servers", len(servers), "drift", len(reports))
+	r.notify(ctx, reports)
+	return reports, nil
+}
+
+func (r *Reconciler) reconcileServer(ctx context.Context, srv models.Server, byKey map[string]managedKey, byUser map[int64]userKeys) []models.DriftReport {
+	report := func(kind string, k *managedKey, detail string, repaired bool) models.DriftReport {
+		d := models.DriftReport{ServerID: srv.ID, ServerName: srv.Name, Kind: kind, Detail: detail, Repaired: repaired}
+		if k != nil {
+			if k.userID != 0 {
+				id := k.userID
+				d.UserID = &id
+			}
+			d.Username = k.username
+		}
+		return d
+	}
+
+	lines, err := r.ssh.ListKeys(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey)
+	if err != nil {
+		slog.Warn("reconcile list keys failed", "server_id", srv.ID, "name", srv.Name, "error", err)
+		return []models.DriftReport{report("unreachable", nil, err.Error(), false)}
+	}
+	// Reservations are read after the keys so that a key added by an activation
+	// in between is already expected
+	scheduled, err := r.reservation.GetScheduled(ctx)
+	if err != nil {
+		slog.Error("reconcile get reservations failed", "server_id", srv.ID, "error", err)
+		return nil
+	}
+	now := time.Now().UTC()
+	expected := make(map[string]bool)
+	var restore []models.Reservation
+	for _, res := range scheduled {
+		// In certificate mode no reservation needs a key line, so any left over
+		// from authorized_keys mode is stray
+		if res.ServerID != srv.ID || srv.AccessMode == models.AccessModeCertificate {
+			continue
+		}
+		u := byUser[res.UserID]
+		marker := KeyMarker(res.ID, u.username)
+		switch {
+		case res.Status == "active":
+			// The user's current keys are accepted too while SyncActiveKeys
+			// brings the installed keys in line with them
+			for _, k := range SelectedKeys(&res, u.keys) {
+				expected[managedLine(k.PublicKey, marker)] = true
+			}
+			for _, key := range InstalledKeys(&res, u.keys) {
+				expected[managedLine(key, marker)] = true
+			}
+			restore = append(restore, res)
+		case res.Status == "pending" && !res.StartTime.After(now):
+			// The scheduler is activating it right now
+			for _, k := range SelectedKeys(&res, u.keys) {
+				expected[managedLine(k.PublicKey, marker)] = true
+			}
+		}
+	}
+
+	var reports []models.DriftReport
+	present := make(map[string]bool)
+	for _, line := range lines {
+		resID, username, ok := parseKeyMarker(line)
+		if !ok {
+			continue
+		}
+		// A line is expected only with the marker of a reservation that
+		// grants the key, so a copy left by an earlier reservation is stray
+		id := keyID(line)
+		marker := KeyMarker(resID, username)
+		if present[managedLine(id, marker)] {
+			continue
+		}
+		present[managedLine(id, marker)] = true
+		if expected[managedLine(id, marker)] {
+			continue
+		}
+		k := managedKey{username: username}
+		if known, ok := byKey[id]; ok {
+			k.userID = known.userID
+		}
+		what := fmt.Sprintf("stray key %s added for reservation #%d", shortKey(id), resID)
+		if err := r.ssh.RemoveKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, id, marker); err != nil {
+			reports = append(reports, report("stray", &k, what+" could not be removed: "+err.Error(), false))
+			continue
+		}
+		slog.Warn("reconcile removed stray key", "server_id", srv.ID, "reservation_id", resID, "username", username)
+		reports = append(reports, report("stray", &k, what+" removed", true))
+	}
+	for _, res := range restore {
+		u := byUser[res.UserID]
+		k := managedKey{userID: res.UserID, username: u.username}
+		marker := KeyMarker(res.ID, k.username)
+		for _, key := range InstalledKeys(&res, u.keys) {
+			id := keyID(key)
+			if present[managedLine(id, marker)] {
+				continue
+			}
+			present[managedLine(id, marker)] = true
+			if res.EndTime.Before(now.Add(restoreMargin)) {
+				reports = append(reports, report("missing", &k, "key "+shortKey(id)+" missing near the end of reservation #"+fmt.Sprint(res.ID), false))
+				continue
+			}
+			// The reservation may have been cancelled or released since the
+			// snapshot was read; its key must not come back then
+			if !r.stillActive(ctx, res.ID) {
+				continue
+			}
+			if err := r.ssh.AddKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, key, marker); err != nil {
+				reports = append(reports, report("missing", &k, "key "+shortKey(id)+" of reservation #"+fmt.Sprint(res.ID)+" could not be restored: "+err.Error(), false))
+				continue
+			}
+			// A revoke that ran while the key was being added has already
+			// removed its lines, so take the restored one back out
+			if !r.stillActive(ctx, res.ID) {
+				if err := RemoveKeyOrQueue(ctx, r.reservation, r.ssh, &srv, res.UserID, key, marker); err != nil {
+					slog.Warn("reconcile remove key of ended reservation failed, queued for retry", "server_id", srv.ID, "reservation_id", res.ID, "error", err)
+				}
+				continue
+			}
+			slog.Warn("reconcile restored missing key", "server_id", srv.ID, "user_id", k.userID, "reservation_id", res.ID)
+			reports = append(reports, report("missing", &k, "key "+shortKey(id)+" of reservation #"+fmt.Sprint(res.ID)+" restored", true))
+		}
+	}
+	return reports
+}
+
+// stillActive re-reads a reservation's status right before or after the
+// reconciler changes its keys
+func (r *Reconciler) stillActive(ctx context.Context, id int64) bool {
+	cur, err := r.reservation.Get(ctx, id)
+	if err != nil {
+		slog.Error("reconcile get reservation failed", "reservation_id", id, "error", err)
+		return false
+	}
+	return cur != nil && cur.Status == "active"
+}
+
+// managedLine identifies a line the scheduler wrote by its key and marker
+func managedLine(key, marker string) string {
+	return keyID(key) + " " + marker
+}
+
+// notify posts one Slack message per server with drift. Unreachable servers are
+// only recorded so that a server that is down does not alert on every run.
+func (r *Reconciler) notify(ctx context.Context, reports []models.DriftReport) {
+	var order []string
+	byServer := make(map[string][]string)
+	for _, d := range reports {
+		if d.Kind == "unreachable" {
+			continue
+		}
+		who := d.Username
+		if who == "" {
+			who = "a deleted user"
+		}
+		what := fmt.Sprintf("%s key of %s", d.Kind, who)
+		switch {
+		case !d.Repaired:
+			what += " (not repaired)"
+		case d.Kind == "stray":
+			what += " (removed)"
+		default:
+			what += " (restored)"
+		}
+		if _, ok := byServer[d.ServerName]; !ok {
+			order = append(order, d.ServerName)
+		}
+		byServer[d.ServerName] = append(byServer[d.ServerName], what)
+	}
+	for _, name := range order {
+		msg := fmt.Sprintf("Access drift on %s: %s", name, strings.Join(byServer[name], "; "))
+		if err := r.slack.Notify(ctx, msg); err != nil {
+			slog.Warn("slack notify failed", "server", name, "error", err)
+		}
+	}
+}
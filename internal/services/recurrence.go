@@ -0,0 +1,191 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxSeriesOccurrences caps how many reservations a single series may materialize
+const MaxSeriesOccurrences = 200
+
+// RecurrenceRule is a subset of the iCalendar RRULE: FREQ (DAILY or WEEKLY),
+// INTERVAL, BYDAY (weekly only), and COUNT or UNTIL. A rule must be bounded.
+type RecurrenceRule struct {
+	Freq     string // DAILY or WEEKLY
+	Interval int
+	ByDay    []time.Weekday
+	Count    int
+	Until    time.Time
+}
+
+var weekdayCodes = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// ParseRecurrenceRule parses e.g. "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR;COUNT=20"
+func ParseRecurrenceRule(s string) (RecurrenceRule, error) {
+	rule := RecurrenceRule{Interval: 1}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return rule, fmt.Errorf("invalid rule part %q", part)
+		}
+		key, val := strings.ToUpper(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+		switch key {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return rule, fmt.Errorf("invalid INTERVAL %q", val)
+			}
+			rule.Interval = n
+		case "BYDAY":
+			for _, code := range strings.Split(val, ",") {
+				d, ok := weekdayCodes[strings.ToUpper(strings.TrimSpace(code))]
+				if !ok {
+					return rule, fmt.Errorf("invalid BYDAY %q", code)
+				}
+				rule.ByDay = append(rule.ByDay, d)
+			}
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return rule, fmt.Errorf("invalid COUNT %q", val)
+			}
+			rule.Count = n
+		case "UNTIL":
+			t, err := parseRuleTime(val)
+			if err != nil {
+				return rule, fmt.Errorf("invalid UNTIL %q", val)
+			}
+			rule.Until = t
+		default:
+			return rule, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+	return rule, rule.Validate()
+}
+
+func parseRuleTime(s string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102", time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			if len(s) == len("20060102") || len(s) == len("2006-01-02") {
+				// A bare date includes the whole day
+				t = t.Add(24*time.Hour - time.Second)
+			}
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// Validate checks that the rule is supported and bounded
+func (r RecurrenceRule) Validate() error {
+	if r.Freq != "DAILY" && r.Freq != "WEEKLY" {
+		return fmt.Errorf("FREQ must be DAILY or WEEKLY")
+	}
+	if r.Interval < 1 {
+		return fmt.Errorf("INTERVAL must be at least 1")
+	}
+	if r.Freq == "DAILY" && len(r.ByDay) > 0 {
+		return fmt.Errorf("BYDAY is only supported with FREQ=WEEKLY")
+	}
+	if r.Count < 0 || r.Count > MaxSeriesOccurrences {
+		return fmt.Errorf("COUNT must be between 1 and %d", MaxSeriesOccurrences)
+	}
+	if r.Count == 0 && r.Until.IsZero() {
+		return fmt.Errorf("rule needs COUNT or UNTIL")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return fmt.Errorf("COUNT and UNTIL are mutually exclusive")
+	}
+	return nil
+}
+
+// String formats the rule in RRULE syntax
+func (r RecurrenceRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := append([]time.Weekday(nil), r.ByDay...)
+		sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+		codes := make([]string, len(days))
+		for i, d := range days {
+			codes[i] = strings.ToUpper(d.String()[:2])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Occurrence is one generated [Start, End) window of a series
+type Occurrence struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Occurrences expands the rule from the first window [start, end). As in RFC 5545,
+// COUNT is applied before exceptions are removed, and exceptions match by UTC date.
+// Expansion stops after MaxSeriesOccurrences windows.
+func (r RecurrenceRule) Occurrences(start, end time.Time, exceptions []time.Time) []Occurrence {
+	start, end = start.UTC(), end.UTC()
+	duration := end.Sub(start)
+	skip := make(map[string]bool, len(exceptions))
+	for _, e := range exceptions {
+		skip[e.UTC().Format("2006-01-02")] = true
+	}
+	byDay := make(map[time.Weekday]bool)
+	for _, d := range r.ByDay {
+		byDay[d] = true
+	}
+	if r.Freq == "WEEKLY" && len(byDay) == 0 {
+		byDay[start.Weekday()] = true
+	}
+	// Weeks are counted from the Monday of the first occurrence's week
+	weekStart := start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+
+	var out []Occurrence
+	generated := 0
+	for day := 0; generated < MaxSeriesOccurrences; day++ {
+		s := start.AddDate(0, 0, day)
+		if !r.Until.IsZero() && s.After(r.Until) {
+			break
+		}
+		switch r.Freq {
+		case "DAILY":
+			if day%r.Interval != 0 {
+				continue
+			}
+		case "WEEKLY":
+			week := int(s.Sub(weekStart).Hours()/24) / 7
+			if week%r.Interval != 0 || !byDay[s.Weekday()] {
+				continue
+			}
+		}
+		generated++
+		if !skip[s.Format("2006-01-02")] {
+			out = append(out, Occurrence{Start: s, End: s.Add(duration)})
+		}
+		if r.Count > 0 && generated >= r.Count {
+			break
+		}
+	}
+	return out
+}
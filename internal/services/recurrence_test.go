@@ -0,0 +1,138 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRecurrenceRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "FREQ=DAILY;COUNT=5", want: "FREQ=DAILY;COUNT=5"},
+		{in: "RRULE:freq=weekly;byday=fr,mo;interval=2;count=4", want: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;COUNT=4"},
+		{in: "FREQ=WEEKLY;UNTIL=20260131", want: "FREQ=WEEKLY;UNTIL=20260131T235959Z"},
+		{in: "FREQ=DAILY;UNTIL=2026-01-31T12:00", want: "FREQ=DAILY;UNTIL=20260131T120000Z"},
+		{in: "FREQ=MONTHLY;COUNT=3", wantErr: true},
+		{in: "FREQ=DAILY", wantErr: true},
+		{in: "FREQ=DAILY;COUNT=3;UNTIL=20260131", wantErr: true},
+		{in: "FREQ=DAILY;BYDAY=MO;COUNT=3", wantErr: true},
+		{in: "FREQ=WEEKLY;BYDAY=XX;COUNT=3", wantErr: true},
+		{in: "FREQ=DAILY;INTERVAL=0;COUNT=3", wantErr: true},
+		{in: "FREQ=DAILY;COUNT=201", wantErr: true},
+		{in: "FREQ=DAILY;COUNT=3;BYHOUR=9", wantErr: true},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRecurrenceRule(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRecurrenceRule(%q) = %s, want error", tt.in, rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRecurrenceRule(%q): %v", tt.in, err)
+			continue
+		}
+		if got := rule.String(); got != tt.want {
+			t.Errorf("ParseRecurrenceRule(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	// Monday 5 January 2026, 09:00 to 10:30 UTC
+	monday := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	wednesday := monday.AddDate(0, 0, 2)
+	tests := []struct {
+		name       string
+		rule       string
+		start      time.Time
+		exceptions []time.Time
+		want       []string
+	}{
+		{
+			name:  "daily",
+			rule:  "FREQ=DAILY;COUNT=3",
+			start: monday,
+			want:  []string{"2026-01-05", "2026-01-06", "2026-01-07"},
+		},
+		{
+			name:  "every other day",
+			rule:  "FREQ=DAILY;INTERVAL=2;COUNT=3",
+			start: monday,
+			want:  []string{"2026-01-05", "2026-01-07", "2026-01-09"},
+		},
+		{
+			name:  "weekly on the start's weekday",
+			rule:  "FREQ=WEEKLY;UNTIL=20260119",
+			start: monday,
+			want:  []string{"2026-01-05", "2026-01-12", "2026-01-19"},
+		},
+		{
+			name:  "weekly by day",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4",
+			start: monday,
+			want:  []string{"2026-01-05", "2026-01-07", "2026-01-12", "2026-01-14"},
+		},
+		{
+			name:  "by day before the start is skipped",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=3",
+			start: wednesday,
+			want:  []string{"2026-01-07", "2026-01-12", "2026-01-14"},
+		},
+		{
+			name:  "every other week",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO;COUNT=3",
+			start: monday,
+			want:  []string{"2026-01-05", "2026-01-19", "2026-02-02"},
+		},
+		{
+			name:       "count is applied before exceptions",
+			rule:       "FREQ=DAILY;COUNT=3",
+			start:      monday,
+			exceptions: []time.Time{time.Date(2026, 1, 6, 0, 0, 0, 0, time.UTC)},
+			want:       []string{"2026-01-05", "2026-01-07"},
+		},
+		{
+			name:       "exceptions match by UTC date",
+			rule:       "FREQ=DAILY;COUNT=2",
+			start:      monday,
+			exceptions: []time.Time{time.Date(2026, 1, 5, 23, 0, 0, 0, time.FixedZone("EST", -5*3600))},
+			want:       []string{"2026-01-05"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRecurrenceRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			occurrences := rule.Occurrences(tt.start, tt.start.Add(90*time.Minute), tt.exceptions)
+			var got []string
+			for _, o := range occurrences {
+				if o.End.Sub(o.Start) != 90*time.Minute {
+					t.Errorf("occurrence %s lasts %s, want 1h30m", o.Start, o.End.Sub(o.Start))
+				}
+				if o.Start.Hour() != 9 {
+					t.Errorf("occurrence %s does not start at 09:00", o.Start)
+				}
+				got = append(got, o.Start.Format("2006-01-02"))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Occurrences = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOccurrencesCapped(t *testing.T) {
+	rule := RecurrenceRule{Freq: "DAILY", Interval: 1, Until: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)}
+	start := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	if got := len(rule.Occurrences(start, start.Add(time.Hour), nil)); got != MaxSeriesOccurrences {
+		t.Errorf("got %d occurrences, want %d", got, MaxSeriesOccurrences)
+	}
+}
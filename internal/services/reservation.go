@@ -3,6 +3,10 @@ package services
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rusik69/serverscheduler/internal/models"
@@ -10,20 +14,35 @@ import (
 
 // ReservationServiceDB implements ReservationService
 type ReservationServiceDB struct {
-	db *sql.DB
+	db      *sql.DB
+	slack   SlackService
+	changed chan struct{}
 }
 
-// NewReservationService creates a ReservationService
-func NewReservationService(db *sql.DB) ReservationService {
-	return &ReservationServiceDB{db: db}
+// NewReservationService creates a ReservationService. Slack receives approval
+// requests and decisions for servers that require approval.
+func NewReservationService(db *sql.DB, slack SlackService) ReservationService {
+	return &ReservationServiceDB{db: db, slack: slack, changed: make(chan struct{}, 1)}
+}
+
+// Changes signals after reservations are created, cancelled or edited so the
+// scheduler can replan. Signals coalesce; a receiver should reload everything.
+func (s *ReservationServiceDB) Changes() <-chan struct{} {
+	return s.changed
+}
+
+func (s *ReservationServiceDB) notifyChanged() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
 }
 
 func (s *ReservationServiceDB) Get(ctx context.Context, id int64) (*models.Reservation, error) {
-	var r models.Reservation
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, user_id, server_id, start_time, end_time, status, created_at FROM reservations WHERE id = ?`,
+	r, err := scanReservation(s.db.QueryRowContext(ctx,
+		`SELECT `+reservationColumns+` FROM reservations r WHERE r.id = ?`,
 		id,
-	).Scan(&r.ID, &r.UserID, &r.ServerID, &r.StartTime, &r.EndTime, &r.Status, &r.CreatedAt)
+	))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -38,7 +57,7 @@ func (s *ReservationServiceDB) List(ctx context.Context, userID *int64) ([]model
 	var err error
 	if userID != nil {
 		rows, err = s.db.QueryContext(ctx,
-			`SELECT r.id, r.user_id, r.server_id, r.start_time, r.end_time, r.status, r.created_at, s.name, u.username
+			`SELECT `+reservationColumns+`, s.name, u.username, s.access_mode
 			 FROM reservations r
 			 JOIN servers s ON r.server_id = s.id
 			 JOIN users u ON r.user_id = u.id
@@ -48,7 +67,7 @@ func (s *ReservationServiceDB) List(ctx context.Context, userID *int64) ([]model
 		)
 	} else {
 		rows, err = s.db.QueryContext(ctx,
-			`SELECT r.id, r.user_id, r.server_id, r.start_time, r.end_time, r.status, r.created_at, s.name, u.username
+			`SELECT `+reservationColumns+`, s.name, u.username, s.access_mode
 			 FROM reservations r
 			 JOIN servers s ON r.server_id = s.id
 			 JOIN users u ON r.user_id = u.id
@@ -62,52 +81,179 @@ func (s *ReservationServiceDB) List(ctx context.Context, userID *int64) ([]model
 	var list []models.ReservationWithDetails
 	for rows.Next() {
 		var r models.ReservationWithDetails
-		if err := rows.Scan(&r.ID, &r.UserID, &r.ServerID, &r.StartTime, &r.EndTime, &r.Status, &r.CreatedAt, &r.ServerName, &r.Username); err != nil {
+		res, err := scanReservation(rows, &r.ServerName, &r.Username, &r.AccessMode)
+		if err != nil {
 			return nil, err
 		}
+		r.Reservation = res
 		list = append(list, r)
 	}
 	return list, rows.Err()
 }
 
 func (s *ReservationServiceDB) Create(ctx context.Context, userID, serverID int64, start, end time.Time) (*models.Reservation, error) {
+	return s.create(ctx, userID, serverID, start, end, nil, nil)
+}
+
+// CreateWithKeys books a reservation that grants access with only the given
+// keys of the user; no keys means all of them
+func (s *ReservationServiceDB) CreateWithKeys(ctx context.Context, userID, serverID int64, start, end time.Time, keyIDs []int64) (*models.Reservation, error) {
+	return s.create(ctx, userID, serverID, start, end, nil, keyIDs)
+}
+
+func (s *ReservationServiceDB) create(ctx context.Context, userID, serverID int64, start, end time.Time, seriesID *int64, keyIDs []int64) (*models.Reservation, error) {
+	// The overlap and policy checks and the insert run in one write transaction
+	// (BEGIN IMMEDIATE, see database.InitDB), so concurrent bookings for the same
+	// slot are serialized and only the first one succeeds.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	r, err := insertReservation(ctx, tx, userID, serverID, start, end, seriesID, keyIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.notifyChanged()
+	if r.Status == "requested" && seriesID == nil {
+		s.notifyRequested(ctx, r, 1)
+	}
+	return r, nil
+}
+
+// insertReservation checks and books [start, end) within tx. It writes nothing
+// when a check fails, so callers may go on using tx after ErrOverlap or a
+// policy violation.
+func insertReservation(ctx context.Context, tx *sql.Tx, userID, serverID int64, start, end time.Time, seriesID *int64, keyIDs []int64) (*models.Reservation, error) {
 	// Validate no overlap for same server (one user at a time per server)
+	if err := checkOverlap(ctx, tx, serverID, start, end, 0); err != nil {
+		return nil, err
+	}
+	if err := checkPolicies(ctx, tx, userID, serverID, start, end, 0); err != nil {
+		return nil, err
+	}
+	// Bookings on restricted servers wait for an approver instead of the scheduler
+	status := "pending"
+	var requiresApproval, decommissioned bool
+	err := tx.QueryRowContext(ctx, `SELECT requires_approval, decommissioned_at IS NOT NULL FROM servers WHERE id = ?`, serverID).Scan(&requiresApproval, &decommissioned)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if decommissioned {
+		return nil, ErrServerDecommissioned
+	}
+	if requiresApproval {
+		status = "requested"
+	}
+	if err := checkKeyOwner(ctx, tx, userID, keyIDs); err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO reservations (user_id, server_id, start_time, end_time, status, series_id, ssh_key_ids) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, serverID, start, end, status, seriesID, joinIDs(keyIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	r, err := scanReservation(tx.QueryRowContext(ctx,
+		`SELECT `+reservationColumns+` FROM reservations r WHERE r.id = ?`,
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// checkKeyOwner returns ErrSSHKeyNotFound unless every key belongs to the user
+func checkKeyOwner(ctx context.Context, q querier, userID int64, keyIDs []int64) error {
+	for _, id := range keyIDs {
+		var owned bool
+		if err := q.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM user_ssh_keys WHERE id = ? AND user_id = ?)`, id, userID).Scan(&owned); err != nil {
+			return err
+		}
+		if !owned {
+			return ErrSSHKeyNotFound
+		}
+	}
+	return nil
+}
+
+// checkOverlap returns ErrOverlap if another pending or active reservation on the
+// server intersects [start, end). Requested bookings hold their slot while they
+// await approval. excludeID skips the reservation being changed.
+func checkOverlap(ctx context.Context, q querier, serverID int64, start, end time.Time, excludeID int64) error {
 	var count int
-	err := s.db.QueryRowContext(ctx,
-		`SELECT COUNT(*) FROM reservations WHERE server_id = ? AND status IN ('pending','active')
+	err := q.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM reservations WHERE server_id = ? AND id != ? AND status IN ('requested','pending','active')
 		 AND ((start_time <= ? AND end_time > ?) OR (start_time < ? AND end_time >= ?))`,
-		serverID, end, start, end, start,
+		serverID, excludeID, end, start, end, start,
 	).Scan(&count)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if count > 0 {
-		return nil, ErrOverlap
+		return ErrOverlap
 	}
+	return nil
+}
 
-	res, err := s.db.ExecContext(ctx,
-		`INSERT INTO reservations (user_id, server_id, start_time, end_time, status) VALUES (?, ?, ?, ?, 'pending')`,
-		userID, serverID, start, end,
-	)
+// UpdateEndTime extends or shortens a requested, pending or active reservation.
+// Extending is checked against the next booking on the same server and against
+// the booking policies. Only the stored window changes; access on the server is
+// left as is.
+func (s *ReservationServiceDB) UpdateEndTime(ctx context.Context, id int64, end time.Time) (*models.Reservation, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	id, _ := res.LastInsertId()
-	var r models.Reservation
-	err = s.db.QueryRowContext(ctx,
-		`SELECT id, user_id, server_id, start_time, end_time, status, created_at FROM reservations WHERE id = ?`,
+	defer tx.Rollback()
+	r, err := scanReservation(tx.QueryRowContext(ctx,
+		`SELECT `+reservationColumns+` FROM reservations r WHERE r.id = ?`,
 		id,
-	).Scan(&r.ID, &r.UserID, &r.ServerID, &r.StartTime, &r.EndTime, &r.Status, &r.CreatedAt)
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &r, nil
+	if r.Status != "requested" && r.Status != "pending" && r.Status != "active" {
+		return nil, ErrNotFound
+	}
+	end = end.UTC()
+	if !end.After(r.StartTime) || !end.After(time.Now().UTC()) {
+		return nil, ErrInvalidEndTime
+	}
+	if end.After(r.EndTime) {
+		if err := checkOverlap(ctx, tx, r.ServerID, r.StartTime, end, r.ID); err != nil {
+			return nil, err
+		}
+		if err := checkPolicies(ctx, tx, r.UserID, r.ServerID, r.StartTime, end, r.ID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE reservations SET end_time = ? WHERE id = ?`, end, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.notifyChanged()
+	return s.Get(ctx, id)
 }
 
 func (s *ReservationServiceDB) Cancel(ctx context.Context, id, userID int64) error {
 	res, err := s.db.ExecContext(ctx,
-		`UPDATE reservations SET status = 'cancelled' WHERE id = ? AND user_id = ? AND status IN ('pending','active')`,
-		id, userID,
+		`UPDATE reservations SET status = 'cancelled',
+		 actual_end_time = CASE WHEN status = 'active' THEN ? ELSE actual_end_time END
+		 WHERE id = ? AND user_id = ? AND status IN ('requested','pending','active')`,
+		time.Now().UTC(), id, userID,
 	)
 	if err != nil {
 		return err
@@ -116,13 +262,100 @@ func (s *ReservationServiceDB) Cancel(ctx context.Context, id, userID int64) err
 	if n == 0 {
 		return ErrNotFound
 	}
+	s.notifyChanged()
 	return nil
 }
 
 func (s *ReservationServiceDB) CancelByAdmin(ctx context.Context, id int64) error {
 	res, err := s.db.ExecContext(ctx,
-		`UPDATE reservations SET status = 'cancelled' WHERE id = ? AND status IN ('pending','active')`,
-		id,
+		`UPDATE reservations SET status = 'cancelled',
+		 actual_end_time = CASE WHEN status = 'active' THEN ? ELSE actual_end_time END
+		 WHERE id = ? AND status IN ('requested','pending','active')`,
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	s.notifyChanged()
+	return nil
+}
+
+// CancelByServer cancels every requested, pending or active reservation and
+// every active series on a server, e.g. when it is decommissioned, and tells
+// the owners on Slack. The cancelled reservations are returned with the status
+// they had, so that callers can revoke access of those that were active.
+func (s *ReservationServiceDB) CancelByServer(ctx context.Context, serverID int64) ([]models.Reservation, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+reservationColumns+` FROM reservations r
+		 WHERE r.server_id = ? AND r.status IN ('requested','pending','active') ORDER BY r.start_time`,
+		serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	cancelled, err := scanReservations(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE reservations SET status = 'cancelled',
+		 actual_end_time = CASE WHEN status = 'active' THEN ? ELSE actual_end_time END
+		 WHERE server_id = ? AND status IN ('requested','pending','active')`,
+		time.Now().UTC(), serverID,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE reservation_series SET status = 'cancelled' WHERE server_id = ? AND status = 'active'`, serverID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.notifyChanged()
+	if len(cancelled) > 0 {
+		s.notifyServerCancelled(ctx, serverID, cancelled)
+	}
+	return cancelled, nil
+}
+
+// notifyServerCancelled tells the owners of reservations cancelled by
+// CancelByServer, in one message
+func (s *ReservationServiceDB) notifyServerCancelled(ctx context.Context, serverID int64, cancelled []models.Reservation) {
+	var serverName string
+	if err := s.db.QueryRowContext(ctx, `SELECT name FROM servers WHERE id = ?`, serverID).Scan(&serverName); err != nil {
+		slog.Warn("cancel notify lookup failed", "server_id", serverID, "error", err)
+		return
+	}
+	var lines []string
+	for _, r := range cancelled {
+		var username string
+		if err := s.db.QueryRowContext(ctx, `SELECT username FROM users WHERE id = ?`, r.UserID).Scan(&username); err != nil {
+			username = fmt.Sprintf("user #%d", r.UserID)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s to %s (%s)", username, r.StartTime.UTC().Format(time.RFC3339), r.EndTime.UTC().Format(time.RFC3339), r.Status))
+	}
+	msg := fmt.Sprintf("Server %s was decommissioned. These reservations were cancelled:\n%s", serverName, strings.Join(lines, "\n"))
+	if err := s.slack.Notify(ctx, msg); err != nil {
+		slog.Warn("slack notify failed", "server_id", serverID, "error", err)
+	}
+}
+
+// Release ends an active reservation early. The rest of the window is freed for
+// other bookings and the release time is kept as the actual end.
+func (s *ReservationServiceDB) Release(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE reservations SET status = 'released', actual_end_time = ? WHERE id = ? AND status = 'active'`,
+		time.Now().UTC(), id,
 	)
 	if err != nil {
 		return err
@@ -131,18 +364,32 @@ func (s *ReservationServiceDB) CancelByAdmin(ctx context.Context, id int64) erro
 	if n == 0 {
 		return ErrNotFound
 	}
+	s.notifyChanged()
 	return nil
 }
 
 func (s *ReservationServiceDB) DeleteByUserID(ctx context.Context, userID int64) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM reservations WHERE user_id = ?`, userID)
-	return err
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM expiry_reminders WHERE reservation_id IN (SELECT id FROM reservations WHERE user_id = ?)`, userID); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM reservations WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM reservation_series WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM waitlist WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	s.notifyChanged()
+	return nil
 }
 
 func (s *ReservationServiceDB) GetPendingToActivate(ctx context.Context) ([]models.Reservation, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, user_id, server_id, start_time, end_time, status, created_at FROM reservations
-		 WHERE status = 'pending' AND start_time <= datetime('now') ORDER BY id`,
+		`SELECT `+reservationColumns+` FROM reservations r
+		 WHERE r.status = 'pending' AND r.start_time <= ? AND (r.next_retry_at IS NULL OR r.next_retry_at <= ?) ORDER BY r.id`,
+		time.Now().UTC(), time.Now().UTC(),
 	)
 	if err != nil {
 		return nil, err
@@ -153,8 +400,9 @@ func (s *ReservationServiceDB) GetPendingToActivate(ctx context.Context) ([]mode
 
 func (s *ReservationServiceDB) GetActiveToExpire(ctx context.Context) ([]models.Reservation, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, user_id, server_id, start_time, end_time, status, created_at FROM reservations
-		 WHERE status = 'active' AND end_time <= datetime('now') ORDER BY id`,
+		`SELECT `+reservationColumns+` FROM reservations r
+		 WHERE r.status = 'active' AND r.end_time <= ? ORDER BY r.id`,
+		time.Now().UTC(),
 	)
 	if err != nil {
 		return nil, err
@@ -163,21 +411,139 @@ func (s *ReservationServiceDB) GetActiveToExpire(ctx context.Context) ([]models.
 	return scanReservations(rows)
 }
 
-func (s *ReservationServiceDB) Activate(ctx context.Context, id int64) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE reservations SET status = 'active' WHERE id = ?`, id)
+// GetScheduled returns the pending and active reservations whose start or end
+// the scheduler still has to act on
+func (s *ReservationServiceDB) GetScheduled(ctx context.Context) ([]models.Reservation, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+reservationColumns+` FROM reservations r
+		 WHERE r.status IN ('pending','active') ORDER BY r.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanReservations(rows)
+}
+
+// Activate marks a reservation active once installedKeys are on the server
+// (none if no key was installed, e.g. in certificate mode). Queued removals of
+// those keys on the server are dropped, since they are meant to be there again.
+// It returns ErrStatusChanged if the reservation is no longer pending, e.g.
+// because it was cancelled while the keys were being added.
+func (s *ReservationServiceDB) Activate(ctx context.Context, id int64, installedKeys []string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE reservations SET status = 'active', next_retry_at = NULL, installed_key = ? WHERE id = ? AND status = 'pending'`, strings.Join(installedKeys, "\n"), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrStatusChanged
+	}
+	return s.dropKeyRemovals(ctx, id, installedKeys)
+}
+
+// SetInstalledKeys records that an active reservation's access now uses
+// installedKeys, after the user's keys changed on the server
+func (s *ReservationServiceDB) SetInstalledKeys(ctx context.Context, id int64, installedKeys []string) error {
+	if _, err := s.db.ExecContext(ctx, `UPDATE reservations SET installed_key = ? WHERE id = ?`, strings.Join(installedKeys, "\n"), id); err != nil {
+		return err
+	}
+	return s.dropKeyRemovals(ctx, id, installedKeys)
+}
+
+// SetSessionsKilled records how many live SSH sessions were terminated when
+// the reservation's access ended
+func (s *ReservationServiceDB) SetSessionsKilled(ctx context.Context, id int64, n int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE reservations SET sessions_killed = sessions_killed + ? WHERE id = ?`, n, id)
 	return err
 }
 
+// ClaimReminder records that the reminder lead before endTime is being sent
+// for a reservation. It returns false if it was already sent, so a reminder
+// goes out once across ticks and restarts. Reminders are tied to the end time,
+// so extending a reservation schedules them again.
+func (s *ReservationServiceDB) ClaimReminder(ctx context.Context, id int64, lead time.Duration, endTime time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO expiry_reminders (reservation_id, lead_seconds, end_time) VALUES (?, ?, ?)`,
+		id, int64(lead/time.Second), endTime.UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// dropKeyRemovals forgets queued removals of key lines that a reservation put
+// back on its server. Lines of other reservations are still removed.
+func (s *ReservationServiceDB) dropKeyRemovals(ctx context.Context, id int64, publicKeys []string) error {
+	for _, publicKey := range publicKeys {
+		_, err := s.db.ExecContext(ctx,
+			`DELETE FROM key_removals WHERE public_key = ? AND marker LIKE ? AND (user_id, server_id) IN (SELECT user_id, server_id FROM reservations WHERE id = ?)`,
+			publicKey, fmt.Sprintf("%sres=%d:user=%%", keyMarkerPrefix, id), id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expire marks an active reservation expired. It returns ErrStatusChanged if
+// the reservation was cancelled or released in the meantime.
 func (s *ReservationServiceDB) Expire(ctx context.Context, id int64) error {
-	_, err := s.db.ExecContext(ctx, `UPDATE reservations SET status = 'expired' WHERE id = ?`, id)
-	return err
+	res, err := s.db.ExecContext(ctx, `UPDATE reservations SET status = 'expired', actual_end_time = end_time WHERE id = ? AND status = 'active'`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrStatusChanged
+	}
+	return nil
+}
+
+// reservationColumns is the column list read by scanReservation; queries alias reservations as r
+const reservationColumns = `r.id, r.user_id, r.server_id, r.start_time, r.end_time, r.status, r.created_at, r.series_id, r.actual_end_time, r.decided_by, r.decision_reason,
+	r.activation_attempts, r.last_error, r.next_retry_at, r.installed_key, r.ssh_key_ids, r.sessions_killed`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// scanReservation scans reservationColumns followed by any extra destinations
+func scanReservation(row rowScanner, extra ...interface{}) (models.Reservation, error) {
+	var r models.Reservation
+	var seriesID sql.NullInt64
+	var actualEnd, nextRetry sql.NullTime
+	var installedKeys, keyIDs string
+	dest := append([]interface{}{&r.ID, &r.UserID, &r.ServerID, &r.StartTime, &r.EndTime, &r.Status, &r.CreatedAt, &seriesID, &actualEnd, &r.DecidedBy, &r.DecisionReason,
+		&r.ActivationAttempts, &r.LastError, &nextRetry, &installedKeys, &keyIDs, &r.SessionsKilled}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return r, err
+	}
+	if installedKeys != "" {
+		r.InstalledKeys = strings.Split(installedKeys, "\n")
+	}
+	r.SSHKeyIDs = splitIDs(keyIDs)
+	if seriesID.Valid {
+		r.SeriesID = &seriesID.Int64
+	}
+	r.ActualEndTime = nullTimePtr(actualEnd)
+	r.NextRetryAt = nullTimePtr(nextRetry)
+	return r, nil
 }
 
 func scanReservations(rows *sql.Rows) ([]models.Reservation, error) {
 	var list []models.Reservation
 	for rows.Next() {
-		var r models.Reservation
-		if err := rows.Scan(&r.ID, &r.UserID, &r.ServerID, &r.StartTime, &r.EndTime, &r.Status, &r.CreatedAt); err != nil {
+		r, err := scanReservation(rows)
+		if err != nil {
 			return nil, err
 		}
 		list = append(list, r)
@@ -189,7 +555,7 @@ func scanReservations(rows *sql.Rows) ([]models.Reservation, error) {
 // active first, then the nearest upcoming pending.
 func (s *ReservationServiceDB) GetCurrentByServer(ctx context.Context) (map[int64]*models.ReservationWithDetails, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT r.id, r.user_id, r.server_id, r.start_time, r.end_time, r.status, r.created_at, u.username
+		`SELECT `+reservationColumns+`, u.username
 		 FROM reservations r
 		 JOIN users u ON r.user_id = u.id
 		 WHERE r.status IN ('active','pending') AND r.end_time > datetime('now')
@@ -204,9 +570,11 @@ func (s *ReservationServiceDB) GetCurrentByServer(ctx context.Context) (map[int6
 	m := make(map[int64]*models.ReservationWithDetails)
 	for rows.Next() {
 		var r models.ReservationWithDetails
-		if err := rows.Scan(&r.ID, &r.UserID, &r.ServerID, &r.StartTime, &r.EndTime, &r.Status, &r.CreatedAt, &r.Username); err != nil {
+		res, err := scanReservation(rows, &r.Username)
+		if err != nil {
 			return nil, err
 		}
+		r.Reservation = res
 		if _, exists := m[r.ServerID]; !exists {
 			r2 := r
 			m[r.ServerID] = &r2
@@ -239,9 +607,31 @@ func (s *ReservationServiceDB) GetUsersByServer(ctx context.Context) (map[int64]
 	return m, rows.Err()
 }
 
+// joinIDs stores a list of IDs in one column, comma-separated
+func joinIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// splitIDs reads a list stored by joinIDs, skipping anything that is not an ID
+func splitIDs(s string) []int64 {
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		if id, err := strconv.ParseInt(part, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
 // ErrOverlap and ErrNotFound for reservation errors
 var ErrOverlap = &reservationError{msg: "reservation overlaps with existing one"}
 var ErrNotFound = &reservationError{msg: "reservation not found"}
+var ErrInvalidEndTime = &reservationError{msg: "end time must be after the start and in the future"}
+var ErrStatusChanged = &reservationError{msg: "reservation status changed"}
 
 type reservationError struct{ msg string }
 
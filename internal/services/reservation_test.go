@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/database"
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// TestCreateConcurrentOverlap fires parallel Create calls for the same slot and
+// expects exactly one of them to win.
+func TestCreateConcurrentOverlap(t *testing.T) {
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	srv, err := NewServerService(db, nil).Create(ctx, &models.Server{Name: "lab", Hostname: "127.0.0.1", Port: 22, SSHUser: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const callers = 20
+	users := make([]int64, callers)
+	for i := range users {
+		res, err := db.Exec(`INSERT INTO users (username, password_hash) VALUES (?, 'x')`, "user"+string(rune('a'+i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[i], _ = res.LastInsertId()
+	}
+
+	// Keep a connection per caller open so the calls really run in parallel
+	db.SetMaxIdleConns(callers)
+
+	svc := NewReservationService(db, NewSlackService(""))
+	base := time.Now().UTC().Add(time.Hour).Truncate(time.Minute)
+
+	// Each round races every caller for the same window; the window moves on
+	// between rounds so earlier winners do not decide later ones.
+	const rounds = 200
+	for round := 0; round < rounds; round++ {
+		start := base.Add(time.Duration(round) * 24 * time.Hour)
+		end := start.Add(time.Hour)
+
+		var wg sync.WaitGroup
+		errs := make([]error, callers)
+		ready := make(chan struct{})
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-ready
+				_, errs[i] = svc.Create(ctx, users[i], srv.ID, start, end)
+			}(i)
+		}
+		close(ready)
+		wg.Wait()
+
+		created := 0
+		for i, err := range errs {
+			switch {
+			case err == nil:
+				created++
+			case errors.Is(err, ErrOverlap):
+			default:
+				t.Errorf("round %d, caller %d: unexpected error: %v", round, i, err)
+			}
+		}
+		if created != 1 {
+			t.Fatalf("round %d: created %d reservations, want exactly 1", round, created)
+		}
+	}
+
+	var stored int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM reservations WHERE server_id = ?`, srv.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != rounds {
+		t.Fatalf("stored %d reservations, want %d", stored, rounds)
+	}
+}
+
+// TestClaimReminder checks that each reminder is sent once per lead time and
+// end time, and again after the reservation was extended.
+func TestClaimReminder(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	srv, err := NewServerService(db, nil).Create(ctx, &models.Server{Name: "lab", Hostname: "127.0.0.1", Port: 22, SSHUser: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewReservationService(db, NewSlackService(""))
+	start := time.Now().UTC().Add(time.Hour).Truncate(time.Minute)
+	end := start.Add(time.Hour)
+	r, err := svc.Create(ctx, addTestUser(t, db, "alice"), srv.ID, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		lead time.Duration
+		end  time.Time
+		want bool
+	}{
+		{"first 30m reminder", 30 * time.Minute, end, true},
+		{"same reminder again", 30 * time.Minute, end, false},
+		{"5m reminder", 5 * time.Minute, end, true},
+		{"30m reminder after extension", 30 * time.Minute, end.Add(time.Hour), true},
+		{"extended reminder again", 30 * time.Minute, end.Add(time.Hour), false},
+	}
+	for _, step := range steps {
+		got, err := svc.ClaimReminder(ctx, r.ID, step.lead, step.end)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: claimed = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+// TestActivateExpireStatus checks that the scheduler's status changes do not
+// overwrite a cancel or release that happened meanwhile.
+func TestActivateExpireStatus(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	srv, err := NewServerService(db, nil).Create(ctx, &models.Server{Name: "lab", Hostname: "127.0.0.1", Port: 22, SSHUser: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewReservationService(db, NewSlackService(""))
+	userID := addTestUser(t, db, "alice")
+	base := time.Now().UTC().Add(time.Hour).Truncate(time.Minute)
+
+	tests := []struct {
+		status     string
+		activate   bool // Activate instead of Expire
+		wantErr    error
+		wantStatus string
+	}{
+		{status: "pending", activate: true, wantStatus: "active"},
+		{status: "cancelled", activate: true, wantErr: ErrStatusChanged, wantStatus: "cancelled"},
+		{status: "released", activate: true, wantErr: ErrStatusChanged, wantStatus: "released"},
+		{status: "active", wantStatus: "expired"},
+		{status: "cancelled", wantErr: ErrStatusChanged, wantStatus: "cancelled"},
+		{status: "released", wantErr: ErrStatusChanged, wantStatus: "released"},
+	}
+	for i, tt := range tests {
+		start := base.Add(time.Duration(i) * 2 * time.Hour)
+		r, err := svc.Create(ctx, userID, srv.ID, start, start.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`UPDATE reservations SET status = ? WHERE id = ?`, tt.status, r.ID); err != nil {
+			t.Fatal(err)
+		}
+		op := "Expire"
+		if tt.activate {
+			op = "Activate"
+			err = svc.Activate(ctx, r.ID, []string{"ssh-ed25519 AAAA laptop"})
+		} else {
+			err = svc.Expire(ctx, r.ID)
+		}
+		if err != tt.wantErr {
+			t.Errorf("%s of a %s reservation = %v, want %v", op, tt.status, err, tt.wantErr)
+		}
+		r, err = svc.Get(ctx, r.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status != tt.wantStatus {
+			t.Errorf("%s of a %s reservation left it %s, want %s", op, tt.status, r.Status, tt.wantStatus)
+		}
+	}
+}
+
+// newTestDB opens a fresh database in the test's temp dir
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// addTestUser inserts a user and returns its ID
+func addTestUser(t *testing.T, db *sql.DB, username string) int64 {
+	t.Helper()
+	res, err := db.Exec(`INSERT INTO users (username, password_hash) VALUES (?, 'x')`, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
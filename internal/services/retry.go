@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+const (
+	// maxActivationAttempts is how often granting access is tried before a
+	// reservation gives up as activation_failed
+	maxActivationAttempts = 5
+	retryBaseDelay        = 30 * time.Second
+	retryMaxDelay         = 30 * time.Minute
+)
+
+// retryDelay is the exponential backoff after the given number of failed attempts
+func retryDelay(attempts int) time.Duration {
+	d := retryBaseDelay
+	for i := 1; i < attempts && d < retryMaxDelay; i++ {
+		d *= 2
+	}
+	if d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	return d
+}
+
+// RemoveKeyOrQueue removes a user's key line tagged with marker from a server.
+// If that fails the removal is queued and retried by the scheduler; the
+// original error is returned.
+func RemoveKeyOrQueue(ctx context.Context, res ReservationService, ssh SSHService, srv *models.Server, userID int64, publicKey, marker string) error {
+	err := ssh.RemoveKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, publicKey, marker)
+	if err == nil {
+		return nil
+	}
+	if qerr := res.QueueKeyRemoval(ctx, userID, srv.ID, publicKey, marker, err.Error(), time.Now().UTC().Add(retryDelay(1))); qerr != nil {
+		return qerr
+	}
+	return err
+}
+
+// ActivationFailed records a failed attempt to grant access. With nextRetryAt
+// set the reservation stays pending until then; without it the reservation
+// gives up as activation_failed and frees its slot.
+func (s *ReservationServiceDB) ActivationFailed(ctx context.Context, id int64, attempts int, lastErr string, nextRetryAt *time.Time) error {
+	status := "pending"
+	if nextRetryAt == nil {
+		status = "activation_failed"
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE reservations SET status = ?, activation_attempts = ?, last_error = ?, next_retry_at = ? WHERE id = ? AND status = 'pending'`,
+		status, attempts, lastErr, nextRetryAt, id,
+	)
+	return err
+}
+
+// QueueKeyRemoval records a key that could not be removed from a server so the
+// scheduler keeps retrying until it is gone
+func (s *ReservationServiceDB) QueueKeyRemoval(ctx context.Context, userID, serverID int64, publicKey, marker, lastErr string, nextRetryAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO key_removals (user_id, server_id, public_key, marker, attempts, last_error, next_retry_at) VALUES (?, ?, ?, ?, 1, ?, ?)`,
+		userID, serverID, publicKey, marker, lastErr, nextRetryAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	s.notifyChanged()
+	return nil
+}
+
+func (s *ReservationServiceDB) ListKeyRemovals(ctx context.Context) ([]models.KeyRemoval, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, user_id, server_id, public_key, marker, attempts, last_error, next_retry_at, created_at
+		 FROM key_removals ORDER BY next_retry_at`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []models.KeyRemoval
+	for rows.Next() {
+		var k models.KeyRemoval
+		if err := rows.Scan(&k.ID, &k.UserID, &k.ServerID, &k.PublicKey, &k.Marker, &k.Attempts, &k.LastError, &k.NextRetryAt, &k.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, k)
+	}
+	return list, rows.Err()
+}
+
+func (s *ReservationServiceDB) KeyRemovalFailed(ctx context.Context, id int64, attempts int, lastErr string, nextRetryAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE key_removals SET attempts = ?, last_error = ?, next_retry_at = ? WHERE id = ?`,
+		attempts, lastErr, nextRetryAt.UTC(), id,
+	)
+	return err
+}
+
+func (s *ReservationServiceDB) KeyRemovalDone(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM key_removals WHERE id = ?`, id)
+	return err
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"container/heap"
 	"context"
 	"fmt"
 	"log/slog"
@@ -9,42 +10,114 @@ import (
 	"github.com/rusik69/serverscheduler/internal/models"
 )
 
-// Scheduler runs background activation/expiration of reservations
+// Scheduler runs background activation/expiration of reservations. It sleeps
+// until the next start or end time on its timer heap and replans whenever the
+// reservation service reports a change. A periodic sweep catches anything the
+// heap missed, such as rows changed outside the service.
 type Scheduler struct {
 	reservation ReservationService
 	server     ServerService
 	user       UserService
 	ssh        SSHService
 	slack      SlackService
-	interval   time.Duration
+	ca         *CertificateAuthority
+	sweep      time.Duration
+	reminders  []time.Duration // before the end, longest first
+	baseURL    string
 }
 
-// NewScheduler creates a Scheduler
-func NewScheduler(res ReservationService, srv ServerService, usr UserService, ssh SSHService, slack SlackService) *Scheduler {
+// NewScheduler creates a Scheduler that sweeps every sweep interval and reminds
+// users of the end of their reservation at each of reminders before it, with
+// a link to the web UI at baseURL
+func NewScheduler(res ReservationService, srv ServerService, usr UserService, ssh SSHService, slack SlackService, ca *CertificateAuthority, sweep time.Duration, reminders []time.Duration, baseURL string) *Scheduler {
 	return &Scheduler{
 		reservation: res,
 		server:     srv,
 		user:       usr,
 		ssh:        ssh,
 		slack:      slack,
-		interval:   60 * time.Second,
+		ca:         ca,
+		sweep:      sweep,
+		reminders:  reminders,
+		baseURL:    baseURL,
 	}
 }
 
-// Start runs the scheduler loop
+// Start runs the scheduler loop. Every wake-up (timer, change or sweep) runs a
+// tick and then replans the timer heap.
 func (s *Scheduler) Start(ctx context.Context) {
-	ticker := time.NewTicker(s.interval)
-	defer ticker.Stop()
+	sweep := time.NewTicker(s.sweep)
+	defer sweep.Stop()
+	// The first timer fire handles anything that came due while we were down
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var events eventHeap
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			s.tick(ctx)
+		case <-timer.C:
+		case <-s.reservation.Changes():
+		case <-sweep.C:
+			slog.Debug("scheduler sweep")
 		}
+		// Events that come due while the tick runs stay planned and fire at once
+		now := time.Now()
+		s.tick(ctx)
+		events = s.plan(ctx, events, now)
+		if len(events) == 0 {
+			timer.Stop()
+			continue
+		}
+		timer.Reset(time.Until(events[0].at))
 	}
 }
 
+// plan rebuilds the timer heap from the upcoming starts, ends and retries.
+// Events due by now, when the tick that ran just before started, are left out
+// since that tick handled them. On error the previous heap is kept and the next
+// sweep tries again.
+func (s *Scheduler) plan(ctx context.Context, prev eventHeap, now time.Time) eventHeap {
+	list, err := s.reservation.GetScheduled(ctx)
+	if err != nil {
+		slog.Error("scheduler plan failed", "error", err)
+		prev.popDue(now)
+		return prev
+	}
+	removals, err := s.reservation.ListKeyRemovals(ctx)
+	if err != nil {
+		slog.Error("scheduler plan failed", "error", err)
+		prev.popDue(now)
+		return prev
+	}
+	events := make(eventHeap, 0, (2+len(s.reminders))*len(list)+len(removals))
+	add := func(at time.Time, kind string, id int64) {
+		if at.After(now) {
+			events = append(events, scheduledEvent{at: at, kind: kind, id: id})
+		}
+	}
+	for _, r := range list {
+		if r.Status == "pending" {
+			add(r.StartTime, "start", r.ID)
+			if r.NextRetryAt != nil {
+				add(*r.NextRetryAt, "retry", r.ID)
+			}
+		}
+		add(r.EndTime, "end", r.ID)
+		for _, lead := range s.reminders {
+			add(r.EndTime.Add(-lead), "reminder", r.ID)
+		}
+	}
+	for _, k := range removals {
+		add(k.NextRetryAt, "key_removal", k.ID)
+	}
+	heap.Init(&events)
+	if len(events) > 0 {
+		slog.Debug("scheduler planned", "events", len(events), "next", events[0].at, "kind", events[0].kind, "id", events[0].id)
+	}
+	return events
+}
+
 func (s *Scheduler) tick(ctx context.Context) {
 	slog.Debug("scheduler tick start")
 	pending, err := s.reservation.GetPendingToActivate(ctx)
@@ -70,53 +143,345 @@ func (s *Scheduler) tick(ctx context.Context) {
 			slog.Error("scheduler expire reservation failed", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "error", err)
 		}
 	}
+
+	s.sendReminders(ctx)
+	s.retryKeyRemovals(ctx)
 }
 
 func (s *Scheduler) activateReservation(ctx context.Context, r models.Reservation) error {
 	usr, err := s.user.GetByID(ctx, r.UserID)
-	if err != nil || usr == nil || usr.SSHPublicKey == "" {
+	if err != nil || usr == nil {
+		slog.Warn("scheduler user not found, skipping activation", "reservation_id", r.ID, "user_id", r.UserID)
+		return ignoreStatusChanged(s.reservation.Activate(ctx, r.ID, nil))
+	}
+	keys, err := s.user.ListSSHKeys(ctx, r.UserID)
+	if err != nil {
+		return err
+	}
+	keys = SelectedKeys(&r, keys)
+	if len(keys) == 0 {
 		slog.Warn("scheduler user has no SSH key, skipping activation", "reservation_id", r.ID, "user_id", r.UserID)
-		return s.reservation.Activate(ctx, r.ID)
+		return ignoreStatusChanged(s.reservation.Activate(ctx, r.ID, nil))
 	}
 	srv, err := s.server.Get(ctx, r.ServerID)
 	if err != nil || srv == nil {
 		return err
 	}
-	if err := s.ssh.AddKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, usr.SSHPublicKey); err != nil {
-		return err
+	msg := fmt.Sprintf("Reservation activated: user %s now has SSH access to %s (until %s)", usr.Username, srv.Name, r.EndTime.Format(time.RFC3339))
+	var installed []string
+	if srv.AccessMode == models.AccessModeCertificate {
+		// The server trusts the CA, so nothing has to change on it
+		if _, _, err := s.ca.Issue(ctx, &r); err != nil {
+			return s.activationFailed(ctx, r, usr, srv, err)
+		}
+		msg = fmt.Sprintf("Reservation activated: user %s can now download an SSH certificate for %s (valid until %s)", usr.Username, srv.Name, r.EndTime.Format(time.RFC3339))
+	} else {
+		for _, k := range keys {
+			if err := s.ssh.AddKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, k.PublicKey, KeyMarker(r.ID, usr.Username)); err != nil {
+				// Keys added so far are removed by the reconciler as stray
+				// lines if the activation ends up failing
+				return s.activationFailed(ctx, r, usr, srv, err)
+			}
+			installed = append(installed, k.PublicKey)
+		}
+	}
+	err = s.reservation.Activate(ctx, r.ID, installed)
+	if err == ErrStatusChanged {
+		// Cancelled or released while the keys were being added; a revoke
+		// that ran meanwhile did not see them, so take them out again now
+		for _, key := range installed {
+			if err := RemoveKeyOrQueue(ctx, s.reservation, s.ssh, srv, usr.ID, key, KeyMarker(r.ID, usr.Username)); err != nil {
+				slog.Warn("scheduler remove key of ended reservation failed, queued for retry", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "error", err)
+			}
+		}
+		slog.Info("reservation ended during activation, access removed", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID)
+		return nil
 	}
-	if err := s.reservation.Activate(ctx, r.ID); err != nil {
+	if err != nil {
 		return err
 	}
-	slog.Info("reservation activated", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "username", usr.Username)
-	msg := fmt.Sprintf("Reservation activated: user %s now has SSH access to %s (until %s)", usr.Username, srv.Name, r.EndTime.Format(time.RFC3339))
+	if err := s.user.MarkSSHKeysUsed(ctx, sshKeyIDs(keys)); err != nil {
+		slog.Warn("recording SSH key use failed", "reservation_id", r.ID, "error", err)
+	}
+	slog.Info("reservation activated", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "username", usr.Username, "access_mode", srv.AccessMode, "keys", len(keys), "attempts", r.ActivationAttempts+1)
 	if err := s.slack.Notify(ctx, msg); err != nil {
 		slog.Warn("slack notify failed", "reservation_id", r.ID, "error", err)
 	}
 	return nil
 }
 
+// activationFailed schedules the next attempt with exponential backoff, or
+// gives up after maxActivationAttempts or once the window has ended and alerts
+// the user and admins
+func (s *Scheduler) activationFailed(ctx context.Context, r models.Reservation, usr *models.User, srv *models.Server, cause error) error {
+	attempts := r.ActivationAttempts + 1
+	next := time.Now().UTC().Add(retryDelay(attempts))
+	if attempts >= maxActivationAttempts || !next.Before(r.EndTime) {
+		if err := s.reservation.ActivationFailed(ctx, r.ID, attempts, cause.Error(), nil); err != nil {
+			return err
+		}
+		slog.Error("reservation activation failed, giving up", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "attempts", attempts, "error", cause)
+		msg := fmt.Sprintf("Activation failed: could not grant %s SSH access to %s after %d attempts (%v). The reservation was marked activation_failed; admins, please check the server.",
+			usr.Username, srv.Name, attempts, cause)
+		if err := s.slack.Notify(ctx, msg); err != nil {
+			slog.Warn("slack notify failed", "reservation_id", r.ID, "error", err)
+		}
+		return nil
+	}
+	if err := s.reservation.ActivationFailed(ctx, r.ID, attempts, cause.Error(), &next); err != nil {
+		return err
+	}
+	slog.Warn("reservation activation failed, will retry", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "attempts", attempts, "next_retry_at", next, "error", cause)
+	return nil
+}
+
 func (s *Scheduler) expireReservation(ctx context.Context, r models.Reservation) error {
 	usr, err := s.user.GetByID(ctx, r.UserID)
 	if err != nil || usr == nil {
-		return s.reservation.Expire(ctx, r.ID)
+		return ignoreStatusChanged(s.reservation.Expire(ctx, r.ID))
 	}
 	srv, err := s.server.Get(ctx, r.ServerID)
 	if err != nil || srv == nil {
 		return err
 	}
-	if usr.SSHPublicKey != "" {
-		if err := s.ssh.RemoveKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, usr.SSHPublicKey); err != nil {
-			slog.Warn("scheduler remove key failed", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "error", err)
+	keys, err := s.user.ListSSHKeys(ctx, r.UserID)
+	if err != nil {
+		return err
+	}
+	queued := false
+	// Certificates expire on their own at the reservation's end
+	if srv.AccessMode != models.AccessModeCertificate {
+		for _, key := range InstalledKeys(&r, keys) {
+			if err := RemoveKeyOrQueue(ctx, s.reservation, s.ssh, srv, usr.ID, key, KeyMarker(r.ID, usr.Username)); err != nil {
+				slog.Warn("scheduler remove key failed, queued for retry", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "error", err)
+				queued = true
+			}
 		}
 	}
-	if err := s.reservation.Expire(ctx, r.ID); err != nil {
+	killed, err := EndSessions(ctx, s.reservation, s.ssh, srv, &r, keys)
+	if err != nil {
+		slog.Warn("scheduler ending live sessions failed", "reservation_id", r.ID, "server_id", r.ServerID, "error", err)
+	}
+	err = s.reservation.Expire(ctx, r.ID)
+	if err == ErrStatusChanged {
+		// Cancelled or released meanwhile, which revoked access and notified
+		slog.Info("reservation ended before it expired", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID)
+		return nil
+	}
+	if err != nil {
 		return err
 	}
-	slog.Info("reservation expired", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "username", usr.Username)
+	slog.Info("reservation expired", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "username", usr.Username, "sessions_killed", killed)
 	msg := fmt.Sprintf("Reservation expired: user %s SSH access to %s has been revoked", usr.Username, srv.Name)
+	if srv.AccessMode == models.AccessModeCertificate {
+		msg = fmt.Sprintf("Reservation expired: user %s SSH certificate for %s is no longer valid", usr.Username, srv.Name)
+	}
+	if queued {
+		msg = fmt.Sprintf("Reservation expired: user %s SSH access to %s could not be revoked yet; removal will be retried", usr.Username, srv.Name)
+	}
+	if killed > 0 {
+		msg += fmt.Sprintf(" (%d live session(s) terminated)", killed)
+	}
 	if err := s.slack.Notify(ctx, msg); err != nil {
 		slog.Warn("slack notify failed", "reservation_id", r.ID, "error", err)
 	}
 	return nil
 }
+
+// retryKeyRemovals retries queued key removals that are due. They are kept
+// until they succeed; admins are alerted once when one keeps failing.
+func (s *Scheduler) retryKeyRemovals(ctx context.Context) {
+	removals, err := s.reservation.ListKeyRemovals(ctx)
+	if err != nil {
+		slog.Error("scheduler list key removals failed", "error", err)
+		return
+	}
+	now := time.Now().UTC()
+	for _, k := range removals {
+		if k.NextRetryAt.After(now) {
+			continue
+		}
+		srv, err := s.server.Get(ctx, k.ServerID)
+		if err != nil {
+			slog.Error("scheduler key removal server lookup failed", "key_removal_id", k.ID, "server_id", k.ServerID, "error", err)
+			continue
+		}
+		switch {
+		case srv == nil:
+			// The server was deleted, and its authorized_keys with it
+		case k.Marker == "":
+			err = s.removeUnmarkedKey(ctx, srv, k)
+		default:
+			err = s.ssh.RemoveKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, k.PublicKey, k.Marker)
+		}
+		if err == nil {
+			if err := s.reservation.KeyRemovalDone(ctx, k.ID); err != nil {
+				slog.Error("scheduler key removal done failed", "key_removal_id", k.ID, "error", err)
+			}
+			if k.Marker == "" {
+				slog.Info("queued key removal without marker done, lines of ended reservations removed", "key_removal_id", k.ID, "user_id", k.UserID, "server_id", k.ServerID, "attempts", k.Attempts+1)
+			} else {
+				slog.Info("queued key removal succeeded", "key_removal_id", k.ID, "user_id", k.UserID, "server_id", k.ServerID, "attempts", k.Attempts+1)
+			}
+			// The last removal on a retired server lets its host key go
+			if srv != nil && srv.DecommissionedAt != nil {
+				if err := s.server.ForgetHostKey(ctx, srv.ID); err != nil {
+					slog.Warn("forgetting host key failed", "server_id", srv.ID, "error", err)
+				}
+			}
+			continue
+		}
+		attempts := k.Attempts + 1
+		next := now.Add(retryDelay(attempts))
+		if err := s.reservation.KeyRemovalFailed(ctx, k.ID, attempts, err.Error(), next); err != nil {
+			slog.Error("scheduler key removal update failed", "key_removal_id", k.ID, "error", err)
+		}
+		slog.Warn("queued key removal failed, will retry", "key_removal_id", k.ID, "user_id", k.UserID, "server_id", k.ServerID, "attempts", attempts, "next_retry_at", next, "error", err)
+		if attempts == maxActivationAttempts {
+			msg := fmt.Sprintf("Key removal failing: a revoked SSH key of user #%d is still on %s after %d attempts (%v). Retrying until it succeeds; admins, please check the server.",
+				k.UserID, srv.Name, attempts, err)
+			if err := s.slack.Notify(ctx, msg); err != nil {
+				slog.Warn("slack notify failed", "key_removal_id", k.ID, "error", err)
+			}
+		}
+	}
+}
+
+// removeUnmarkedKey carries out a removal queued before markers were recorded.
+// The markers are read back from the server: every line of the key written for
+// a reservation that no longer grants access is removed.
+func (s *Scheduler) removeUnmarkedKey(ctx context.Context, srv *models.Server, k models.KeyRemoval) error {
+	lines, err := s.ssh.ListKeys(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey)
+	if err != nil {
+		return err
+	}
+	id := keyID(k.PublicKey)
+	for _, line := range lines {
+		resID, username, ok := parseKeyMarker(line)
+		if !ok || keyID(line) != id {
+			continue
+		}
+		r, err := s.reservation.Get(ctx, resID)
+		if err != nil {
+			return err
+		}
+		if r != nil && (r.Status == "pending" || r.Status == "active") {
+			continue
+		}
+		if err := s.ssh.RemoveKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, k.PublicKey, KeyMarker(resID, username)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendReminders warns users whose active reservation ends within one of the
+// reminder leads, on Slack and in their terminals on the server. Leads that
+// would fall before the reservation started are skipped. When several came
+// due at once, such as after a restart, one reminder covers them.
+func (s *Scheduler) sendReminders(ctx context.Context) {
+	if len(s.reminders) == 0 {
+		return
+	}
+	list, err := s.reservation.GetScheduled(ctx)
+	if err != nil {
+		slog.Error("scheduler get reminders failed", "error", err)
+		return
+	}
+	now := time.Now().UTC()
+	for _, r := range list {
+		if r.Status != "active" || !r.EndTime.After(now) {
+			continue
+		}
+		due := false
+		for _, lead := range s.reminders {
+			at := r.EndTime.Add(-lead)
+			if at.After(now) || at.Before(r.StartTime) {
+				continue
+			}
+			claimed, err := s.reservation.ClaimReminder(ctx, r.ID, lead, r.EndTime)
+			if err != nil {
+				slog.Error("scheduler claim reminder failed", "reservation_id", r.ID, "error", err)
+				continue
+			}
+			due = due || claimed
+		}
+		if due {
+			s.remind(ctx, r, r.EndTime.Sub(now))
+		}
+	}
+}
+
+func (s *Scheduler) remind(ctx context.Context, r models.Reservation, left time.Duration) {
+	usr, err := s.user.GetByID(ctx, r.UserID)
+	if err != nil || usr == nil {
+		return
+	}
+	srv, err := s.server.Get(ctx, r.ServerID)
+	if err != nil || srv == nil {
+		return
+	}
+	minutes := int(left.Round(time.Minute) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	link := s.baseURL + "/reservations"
+	slog.Info("reservation expiry reminder", "reservation_id", r.ID, "user_id", r.UserID, "server_id", r.ServerID, "minutes_left", minutes)
+	msg := fmt.Sprintf("Reminder: %s's reservation of %s ends in %d minute(s) (%s). Extend it at %s",
+		usr.Username, srv.Name, minutes, r.EndTime.Format(time.RFC3339), link)
+	if err := s.slack.Notify(ctx, msg); err != nil {
+		slog.Warn("slack notify failed", "reservation_id", r.ID, "error", err)
+	}
+	keys, err := s.user.ListSSHKeys(ctx, r.UserID)
+	if err != nil {
+		return
+	}
+	granted := InstalledKeys(&r, keys)
+	if len(granted) == 0 {
+		return
+	}
+	msg = fmt.Sprintf("serverscheduler: your reservation #%d on %s ends in %d minute(s) at %s. Extend it at %s",
+		r.ID, srv.Name, minutes, r.EndTime.Format("15:04 MST"), link)
+	if _, err := s.ssh.MessageSessions(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, granted, r.StartTime, msg); err != nil {
+		slog.Warn("scheduler reminder to live sessions failed", "reservation_id", r.ID, "server_id", r.ServerID, "error", err)
+	}
+}
+
+// scheduledEvent is a start or end time the scheduler has to wake up for
+type scheduledEvent struct {
+	at   time.Time
+	kind string // start, end, retry, reminder or key_removal
+	id   int64  // reservation or key removal ID
+}
+
+// eventHeap is a min-heap of scheduled events ordered by time
+type eventHeap []scheduledEvent
+
+func (h eventHeap) Len() int           { return len(h) }
+func (h eventHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h eventHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *eventHeap) Push(x interface{}) { *h = append(*h, x.(scheduledEvent)) }
+
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// popDue drops every event at or before now
+func (h *eventHeap) popDue(now time.Time) {
+	for h.Len() > 0 && !(*h)[0].at.After(now) {
+		heap.Pop(h)
+	}
+}
+
+// ignoreStatusChanged drops ErrStatusChanged: the reservation was cancelled or
+// released meanwhile, so the scheduler has nothing left to do for it
+func ignoreStatusChanged(err error) error {
+	if err == ErrStatusChanged {
+		return nil
+	}
+	return err
+}
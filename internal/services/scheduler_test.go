@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// TestRetryUnmarkedKeyRemoval checks that a removal queued without a marker
+// removes the key's lines of ended reservations and keeps those still in use.
+func TestRetryUnmarkedKeyRemoval(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	servers := NewServerService(db, nil)
+	srv, err := servers.Create(ctx, &models.Server{Name: "lab", Hostname: "lab", Port: 22, SSHUser: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := NewUserService(db)
+	userID := addTestUser(t, db, "alice")
+	laptop := testPublicKey(t, "laptop")
+	if _, err := users.(*UserServiceDB).AddSSHKey(ctx, userID, "laptop", laptop); err != nil {
+		t.Fatal(err)
+	}
+	res := NewReservationService(db, NewSlackService(""))
+	start := time.Now().UTC().Add(time.Hour).Truncate(time.Minute)
+	ended, err := res.Create(ctx, userID, srv.ID, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.CancelByAdmin(ctx, ended.ID); err != nil {
+		t.Fatal(err)
+	}
+	active, err := res.Create(ctx, userID, srv.ID, start.Add(2*time.Hour), start.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Activate(ctx, active.ID, []string{laptop}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO key_removals (user_id, server_id, public_key, next_retry_at) VALUES (?, ?, ?, ?)`,
+		userID, srv.ID, laptop, time.Now().UTC().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeSSH{lines: []string{
+		"ssh-ed25519 AAAAadmin admin@host",
+		laptop + " " + KeyMarker(ended.ID, "alice"),
+		laptop + " " + KeyMarker(active.ID, "alice"),
+		testPublicKey(t, "desktop") + " " + KeyMarker(ended.ID, "alice"),
+	}}
+	s := NewScheduler(res, servers, users, fake, NewSlackService(""), nil, time.Minute, nil, "")
+	s.retryKeyRemovals(ctx)
+
+	if want := []string{KeyMarker(ended.ID, "alice")}; !reflect.DeepEqual(fake.markers, want) {
+		t.Errorf("removed lines %v, want %v", fake.markers, want)
+	}
+	if want := []string{"remove root@lab laptop"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("calls = %v, want %v", fake.calls, want)
+	}
+	queued, err := res.ListKeyRemovals(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 0 {
+		t.Errorf("%d removals still queued, want none", len(queued))
+	}
+}
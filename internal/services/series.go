@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// CreateSeries stores a recurring series and materializes its occurrences as pending
+// reservations. Occurrences that cannot be booked (e.g. ErrOverlap) are reported
+// individually in the result instead of failing the whole series, as are
+// occurrences that break a booking policy.
+func (s *ReservationServiceDB) CreateSeries(ctx context.Context, userID, serverID int64, start, end time.Time, rule RecurrenceRule, exceptions []time.Time) (*models.ReservationSeries, []models.SeriesOccurrence, error) {
+	if err := rule.Validate(); err != nil {
+		return nil, nil, &SeriesRuleError{msg: err.Error()}
+	}
+	var decommissioned bool
+	err := s.db.QueryRowContext(ctx, `SELECT decommissioned_at IS NOT NULL FROM servers WHERE id = ?`, serverID).Scan(&decommissioned)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+	if decommissioned {
+		return nil, nil, ErrServerDecommissioned
+	}
+	occurrences := rule.Occurrences(start, end, exceptions)
+	if len(occurrences) == 0 {
+		return nil, nil, &SeriesRuleError{msg: "rule produces no occurrences"}
+	}
+
+	// The series and its occurrences are stored together or not at all
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO reservation_series (user_id, server_id, start_time, end_time, rule, exceptions) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, serverID, start.UTC(), end.UTC(), rule.String(), formatSeriesExceptions(exceptions),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	seriesID, _ := res.LastInsertId()
+
+	now := time.Now().UTC()
+	results := make([]models.SeriesOccurrence, 0, len(occurrences))
+	var firstRequested *models.Reservation
+	requested := 0
+	for _, o := range occurrences {
+		result := models.SeriesOccurrence{StartTime: o.Start, EndTime: o.End}
+		if o.Start.Before(now) {
+			result.Error = "start time is in the past"
+			results = append(results, result)
+			continue
+		}
+		r, err := insertReservation(ctx, tx, userID, serverID, o.Start, o.End, &seriesID, nil)
+		switch {
+		case err == nil:
+			result.ReservationID = r.ID
+			if r.Status == "requested" {
+				if firstRequested == nil {
+					firstRequested = r
+				}
+				requested++
+			}
+		case errors.Is(err, ErrOverlap), errors.As(err, new(*PolicyViolationError)):
+			result.Error = err.Error()
+		default:
+			return nil, nil, err
+		}
+		results = append(results, result)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	s.notifyChanged()
+	if firstRequested != nil {
+		// One approval request for the whole series rather than one per occurrence
+		s.notifyRequested(ctx, firstRequested, requested)
+	}
+
+	series, err := s.GetSeries(ctx, seriesID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return series, results, nil
+}
+
+func (s *ReservationServiceDB) GetSeries(ctx context.Context, id int64) (*models.ReservationSeries, error) {
+	rs, err := scanSeries(s.db.QueryRowContext(ctx,
+		`SELECT `+seriesColumns+` FROM reservation_series rs WHERE rs.id = ?`,
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+func (s *ReservationServiceDB) ListSeries(ctx context.Context, userID *int64) ([]models.ReservationSeriesWithDetails, error) {
+	query := `SELECT ` + seriesColumns + `, s.name, u.username
+		 FROM reservation_series rs
+		 JOIN servers s ON rs.server_id = s.id
+		 JOIN users u ON rs.user_id = u.id`
+	var args []interface{}
+	if userID != nil {
+		query += ` WHERE rs.user_id = ?`
+		args = append(args, *userID)
+	}
+	query += ` ORDER BY rs.start_time DESC`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []models.ReservationSeriesWithDetails
+	for rows.Next() {
+		var rs models.ReservationSeriesWithDetails
+		series, err := scanSeries(rows, &rs.ServerName, &rs.Username)
+		if err != nil {
+			return nil, err
+		}
+		rs.ReservationSeries = series
+		list = append(list, rs)
+	}
+	return list, rows.Err()
+}
+
+// CancelSeries stops a series and cancels its occurrences that have not started yet
+// (pending or still awaiting approval).
+// An occurrence that is already active keeps running until it expires or is cancelled.
+func (s *ReservationServiceDB) CancelSeries(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `UPDATE reservation_series SET status = 'cancelled' WHERE id = ? AND status = 'active'`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE reservations SET status = 'cancelled' WHERE series_id = ? AND status IN ('requested','pending')`,
+		id,
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.notifyChanged()
+	return nil
+}
+
+// seriesColumns is the column list read by scanSeries; queries alias reservation_series as rs
+const seriesColumns = `rs.id, rs.user_id, rs.server_id, rs.start_time, rs.end_time, rs.rule, rs.exceptions, rs.status, rs.created_at`
+
+func scanSeries(row rowScanner, extra ...interface{}) (models.ReservationSeries, error) {
+	var rs models.ReservationSeries
+	var exceptions string
+	dest := append([]interface{}{&rs.ID, &rs.UserID, &rs.ServerID, &rs.StartTime, &rs.EndTime, &rs.Rule, &exceptions, &rs.Status, &rs.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return rs, err
+	}
+	rs.Exceptions = parseSeriesExceptions(exceptions)
+	return rs, nil
+}
+
+func formatSeriesExceptions(dates []time.Time) string {
+	parts := make([]string, len(dates))
+	for i, d := range dates {
+		parts[i] = d.UTC().Format("2006-01-02")
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseSeriesExceptions(s string) []time.Time {
+	dates := []time.Time{}
+	for _, part := range strings.Split(s, ",") {
+		if t, err := time.Parse("2006-01-02", strings.TrimSpace(part)); err == nil {
+			dates = append(dates, t)
+		}
+	}
+	return dates
+}
+
+// SeriesRuleError reports an invalid or empty recurrence rule
+type SeriesRuleError struct{ msg string }
+
+func (e *SeriesRuleError) Error() string { return "invalid recurrence: " + e.msg }
@@ -3,23 +3,33 @@ package services
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
 
 	"github.com/rusik69/serverscheduler/internal/models"
 )
 
-// ServerServiceDB implements ServerService
+// ServerServiceDB implements ServerService. With a keyring, SSH private keys
+// are encrypted at rest; Get decrypts them.
 type ServerServiceDB struct {
-	db *sql.DB
+	db   *sql.DB
+	keys *Keyring
 }
 
-// NewServerService creates a ServerService
-func NewServerService(db *sql.DB) ServerService {
-	return &ServerServiceDB{db: db}
+// NewServerService creates a ServerService; keys may be nil to store private
+// keys in plaintext
+func NewServerService(db *sql.DB, keys *Keyring) ServerService {
+	return &ServerServiceDB{db: db, keys: keys}
 }
 
 func (s *ServerServiceDB) List(ctx context.Context) ([]models.Server, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, name, hostname, port, ssh_user, description, created_at FROM servers ORDER BY name`,
+		`SELECT s.id, s.name, s.hostname, s.port, s.ssh_user, s.ssh_public_key, s.description, s.requires_approval, s.owner_id, s.access_mode, s.session_policy, COALESCE(k.fingerprint, ''), s.created_at
+		 FROM servers s
+		 LEFT JOIN known_hosts k ON k.address = s.hostname || ':' || s.port
+		 WHERE s.decommissioned_at IS NULL
+		 ORDER BY s.name`,
 	)
 	if err != nil {
 		return nil, err
@@ -28,9 +38,13 @@ func (s *ServerServiceDB) List(ctx context.Context) ([]models.Server, error) {
 	var list []models.Server
 	for rows.Next() {
 		var sv models.Server
-		if err := rows.Scan(&sv.ID, &sv.Name, &sv.Hostname, &sv.Port, &sv.SSHUser, &sv.Description, &sv.CreatedAt); err != nil {
+		var ownerID sql.NullInt64
+		if err := rows.Scan(&sv.ID, &sv.Name, &sv.Hostname, &sv.Port, &sv.SSHUser, &sv.SSHPublicKey, &sv.Description, &sv.RequiresApproval, &ownerID, &sv.AccessMode, &sv.SessionPolicy, &sv.HostKeyFingerprint, &sv.CreatedAt); err != nil {
 			return nil, err
 		}
+		if ownerID.Valid {
+			sv.OwnerID = &ownerID.Int64
+		}
 		list = append(list, sv)
 	}
 	return list, rows.Err()
@@ -38,32 +52,270 @@ func (s *ServerServiceDB) List(ctx context.Context) ([]models.Server, error) {
 
 func (s *ServerServiceDB) Get(ctx context.Context, id int64) (*models.Server, error) {
 	var sv models.Server
+	var ownerID sql.NullInt64
+	var decommissionedAt sql.NullTime
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, name, hostname, port, ssh_user, ssh_private_key, description, created_at FROM servers WHERE id = ?`,
+		`SELECT s.id, s.name, s.hostname, s.port, s.ssh_user, s.ssh_private_key, s.ssh_public_key, s.description, s.requires_approval, s.owner_id, s.access_mode, s.session_policy, COALESCE(k.fingerprint, ''), s.created_at, s.decommissioned_at
+		 FROM servers s
+		 LEFT JOIN known_hosts k ON k.address = s.hostname || ':' || s.port
+		 WHERE s.id = ?`,
 		id,
-	).Scan(&sv.ID, &sv.Name, &sv.Hostname, &sv.Port, &sv.SSHUser, &sv.SSHPrivateKey, &sv.Description, &sv.CreatedAt)
+	).Scan(&sv.ID, &sv.Name, &sv.Hostname, &sv.Port, &sv.SSHUser, &sv.SSHPrivateKey, &sv.SSHPublicKey, &sv.Description, &sv.RequiresApproval, &ownerID, &sv.AccessMode, &sv.SessionPolicy, &sv.HostKeyFingerprint, &sv.CreatedAt, &decommissionedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	if ownerID.Valid {
+		sv.OwnerID = &ownerID.Int64
+	}
+	sv.DecommissionedAt = nullTimePtr(decommissionedAt)
+	if sv.SSHPrivateKey, err = s.keys.Decrypt(sv.SSHPrivateKey); err != nil {
+		return nil, err
+	}
 	return &sv, nil
 }
 
+// Create adds a server. Without a private key the service generates an
+// ed25519 keypair for it; its public half is returned in SSHPublicKey.
 func (s *ServerServiceDB) Create(ctx context.Context, sv *models.Server) (*models.Server, error) {
-	res, err := s.db.ExecContext(ctx,
-		`INSERT INTO servers (name, hostname, port, ssh_user, ssh_private_key, description) VALUES (?, ?, ?, ?, ?, ?)`,
-		sv.Name, sv.Hostname, sv.Port, sv.SSHUser, sv.SSHPrivateKey, sv.Description,
+	mode := sv.AccessMode
+	if mode == "" {
+		mode = models.AccessModeAuthorizedKeys
+	}
+	if !ValidAccessMode(mode) {
+		return nil, ErrInvalidAccessMode
+	}
+	privateKey, err := s.sealPrivateKey(sv.SSHPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO servers (name, hostname, port, ssh_user, ssh_private_key, description, requires_approval, owner_id, access_mode) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sv.Name, sv.Hostname, sv.Port, sv.SSHUser, privateKey, sv.Description, sv.RequiresApproval, sv.OwnerID, mode,
 	)
 	if err != nil {
 		return nil, err
 	}
 	id, _ := res.LastInsertId()
+	if sv.SSHPrivateKey == "" {
+		// The key comment names the server, so the row must exist first
+		generated, publicKey, err := GenerateServerKeypair(id)
+		if err != nil {
+			return nil, err
+		}
+		if privateKey, err = s.sealPrivateKey(generated); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE servers SET ssh_private_key = ?, ssh_public_key = ? WHERE id = ?`, privateKey, publicKey, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return s.Get(ctx, id)
 }
 
-func (s *ServerServiceDB) Delete(ctx context.Context, id int64) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM servers WHERE id = ?`, id)
+// Update changes a server's name, connection settings and description. A
+// non-empty SSHPrivateKey replaces the stored key (and forgets a generated
+// public key); otherwise the key is kept.
+func (s *ServerServiceDB) Update(ctx context.Context, sv *models.Server) (*models.Server, error) {
+	var err error
+	if sv.SSHPrivateKey == "" {
+		_, err = s.db.ExecContext(ctx,
+			`UPDATE servers SET name = ?, hostname = ?, port = ?, ssh_user = ?, description = ? WHERE id = ?`,
+			sv.Name, sv.Hostname, sv.Port, sv.SSHUser, sv.Description, sv.ID,
+		)
+	} else {
+		var privateKey string
+		if privateKey, err = s.sealPrivateKey(sv.SSHPrivateKey); err != nil {
+			return nil, err
+		}
+		_, err = s.db.ExecContext(ctx,
+			`UPDATE servers SET name = ?, hostname = ?, port = ?, ssh_user = ?, description = ?, ssh_private_key = ?, ssh_public_key = '' WHERE id = ?`,
+			sv.Name, sv.Hostname, sv.Port, sv.SSHUser, sv.Description, privateKey, sv.ID,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, sv.ID)
+}
+
+// SetKeypair replaces the key the scheduler logs in to a server with
+func (s *ServerServiceDB) SetKeypair(ctx context.Context, id int64, privateKey, publicKey string) error {
+	sealed, err := s.sealPrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE servers SET ssh_private_key = ?, ssh_public_key = ? WHERE id = ?`, sealed, publicKey, id)
+	return err
+}
+
+// SetApproval changes whether bookings on a server need sign-off and who owns it
+func (s *ServerServiceDB) SetApproval(ctx context.Context, id int64, requiresApproval bool, ownerID *int64) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE servers SET requires_approval = ?, owner_id = ? WHERE id = ?`,
+		requiresApproval, ownerID, id,
+	)
 	return err
 }
+
+// SetAccessMode switches how users get access to a server. It is refused while
+// a reservation on the server is active, since its access was granted the other way.
+func (s *ServerServiceDB) SetAccessMode(ctx context.Context, id int64, mode string) error {
+	if !ValidAccessMode(mode) {
+		return ErrInvalidAccessMode
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE servers SET access_mode = ? WHERE id = ?
+		 AND (access_mode = ? OR NOT EXISTS (SELECT 1 FROM reservations WHERE server_id = ? AND status = 'active'))`,
+		mode, id, mode, id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAccessModeBusy
+	}
+	return nil
+}
+
+// SetSessionPolicy sets what happens to live SSH sessions on a server when a
+// reservation's access ends
+func (s *ServerServiceDB) SetSessionPolicy(ctx context.Context, id int64, policy string) error {
+	if !ValidSessionPolicy(policy) {
+		return ErrInvalidSessionPolicy
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE servers SET session_policy = ? WHERE id = ?`, policy, id)
+	return err
+}
+
+// EncryptPrivateKeys encrypts stored SSH private keys with the keyring's active
+// master key and returns how many rows were rewritten. Without rotate only
+// plaintext rows are encrypted (the startup migration); with rotate every row
+// is re-encrypted, so that old master keys can be retired afterwards.
+func (s *ServerServiceDB) EncryptPrivateKeys(ctx context.Context, rotate bool) (int, error) {
+	if s.keys == nil {
+		return 0, ErrMasterKeyRequired
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, `SELECT id, ssh_private_key FROM servers`)
+	if err != nil {
+		return 0, err
+	}
+	stored := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if rotate || !IsEncrypted(value) {
+			stored[id] = value
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for id, value := range stored {
+		plaintext, err := s.keys.Decrypt(value)
+		if err != nil {
+			return 0, fmt.Errorf("server %d: %w", id, err)
+		}
+		sealed, err := s.keys.Encrypt(plaintext)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE servers SET ssh_private_key = ? WHERE id = ?`, sealed, id); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if len(stored) > 0 {
+		slog.Info("server private keys encrypted", "rows", len(stored), "key_id", s.keys.ActiveKeyID(), "rotate", rotate)
+	}
+	return len(stored), nil
+}
+
+// sealPrivateKey encrypts a private key for storage when a keyring is configured
+func (s *ServerServiceDB) sealPrivateKey(privateKey string) (string, error) {
+	if s.keys == nil {
+		return privateKey, nil
+	}
+	return s.keys.Encrypt(privateKey)
+}
+
+// ValidSessionPolicy reports whether policy is one of the models.SessionPolicy* values
+func ValidSessionPolicy(policy string) bool {
+	return policy == models.SessionPolicyTerminate || policy == models.SessionPolicyWarn || policy == models.SessionPolicyIgnore
+}
+
+// ValidAccessMode reports whether mode is one of the models.AccessMode* values
+func ValidAccessMode(mode string) bool {
+	return mode == models.AccessModeAuthorizedKeys || mode == models.AccessModeCertificate
+}
+
+// Decommission retires a server: it disappears from List and takes no new
+// bookings, but its row stays for reservation history. Waiting users and the
+// server's booking policy are dropped. Reservations and the host key are left
+// to the caller (see DecommissionServer).
+func (s *ServerServiceDB) Decommission(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `UPDATE servers SET decommissioned_at = ? WHERE id = ? AND decommissioned_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrServerDecommissioned
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE waitlist SET status = 'cancelled' WHERE server_id = ? AND status = 'waiting'`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM booking_policies WHERE server_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ForgetHostKey drops the trusted host key of a decommissioned server, so a
+// machine rebuilt under its address can be added again. The key is kept while
+// another server in use has the same address or key removals on the server are
+// still queued, since those connections must keep checking it.
+func (s *ServerServiceDB) ForgetHostKey(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM known_hosts WHERE address = (SELECT hostname || ':' || port FROM servers WHERE id = ? AND decommissioned_at IS NOT NULL)
+		 AND NOT EXISTS (SELECT 1 FROM servers o, servers d WHERE d.id = ? AND o.id != d.id AND o.decommissioned_at IS NULL AND o.hostname = d.hostname AND o.port = d.port)
+		 AND NOT EXISTS (SELECT 1 FROM key_removals WHERE server_id = ?)`,
+		id, id, id,
+	)
+	return err
+}
+
+var ErrInvalidAccessMode = &serverError{msg: "access mode must be authorized_keys or certificate"}
+var ErrInvalidSessionPolicy = &serverError{msg: "session policy must be terminate, warn or ignore"}
+var ErrAccessModeBusy = &serverError{msg: "access mode cannot change while a reservation on the server is active"}
+var ErrServerDecommissioned = &serverError{msg: "server is decommissioned"}
+
+type serverError struct{ msg string }
+
+func (e *serverError) Error() string { return e.msg }
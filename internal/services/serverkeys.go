@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// GenerateServerKeypair creates an ed25519 keypair for the scheduler to log in
+// to a server with. The public key is in authorized_keys format and carries
+// ServerKeyMarker as its comment, so a later rotation can remove the line.
+func GenerateServerKeypair(serverID int64) (privateKey, publicKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	marker := ServerKeyMarker(serverID)
+	block, err := ssh.MarshalPrivateKey(priv, marker)
+	if err != nil {
+		return "", "", err
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", "", err
+	}
+	return string(pem.EncodeToMemory(block)), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + marker, nil
+}
+
+// RotateServerKey replaces the key the scheduler logs in to srv with. The new
+// public key is pushed over the current connection and verified with
+// TestConnection before it is stored; only then is the old key removed. The
+// old key stays in authorized_keys if it was not written by the scheduler
+// (e.g. a pasted key) or its removal failed, and oldKeyRemoved is false.
+func RotateServerKey(ctx context.Context, servers ServerService, ssh SSHService, srv *models.Server) (oldKeyRemoved bool, err error) {
+	oldPublic, err := publicKeyOf(srv.SSHPrivateKey)
+	if err != nil {
+		return false, err
+	}
+	privateKey, publicKey, err := GenerateServerKeypair(srv.ID)
+	if err != nil {
+		return false, err
+	}
+	if err := ssh.AddKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, publicKey, ServerKeyMarker(srv.ID)); err != nil {
+		return false, fmt.Errorf("install new key: %w", err)
+	}
+	if err := ssh.TestConnection(ctx, srv.Hostname, srv.Port, srv.SSHUser, privateKey); err != nil {
+		if rerr := ssh.RemoveKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, srv.SSHPrivateKey, publicKey, ServerKeyMarker(srv.ID)); rerr != nil {
+			slog.Warn("removing unverified server key failed", "server_id", srv.ID, "error", rerr)
+		}
+		return false, fmt.Errorf("verify new key: %w", err)
+	}
+	if err := servers.SetKeypair(ctx, srv.ID, privateKey, publicKey); err != nil {
+		return false, err
+	}
+	slog.Info("server key rotated", "server_id", srv.ID, "name", srv.Name)
+
+	if err := ssh.RemoveKey(ctx, srv.Hostname, srv.Port, srv.SSHUser, privateKey, oldPublic, ServerKeyMarker(srv.ID)); err != nil {
+		slog.Warn("removing old server key failed", "server_id", srv.ID, "error", err)
+		return false, nil
+	}
+	lines, err := ssh.ListKeys(ctx, srv.Hostname, srv.Port, srv.SSHUser, privateKey)
+	if err != nil {
+		slog.Warn("listing keys after rotation failed", "server_id", srv.ID, "error", err)
+		return false, nil
+	}
+	old := keyID(oldPublic)
+	for _, line := range lines {
+		if keyID(line) == old {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// publicKeyOf returns the public half of a private key in authorized_keys format
+func publicKeyOf(privateKey string) (string, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return "", fmt.Errorf("parse private key: %w", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
@@ -3,40 +3,48 @@ package services
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"fmt"
 	"log/slog"
-	"net"
+	"strconv"
 	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
-// SSHServiceImpl implements SSHService
-type SSHServiceImpl struct{}
+// SSHServiceImpl implements SSHService. Trusted host keys are kept in the
+// known_hosts table.
+type SSHServiceImpl struct {
+	db *sql.DB
+}
 
 // NewSSHService creates an SSHService
-func NewSSHService() SSHService {
-	return &SSHServiceImpl{}
+func NewSSHService(db *sql.DB) SSHService {
+	return &SSHServiceImpl{db: db}
 }
 
-func (s *SSHServiceImpl) AddKey(ctx context.Context, hostname string, port int, sshUser, privateKey, publicKey string) error {
+// AddKey appends publicKey to the SSH user's authorized_keys, tagged with
+// marker (see KeyMarker) so that only lines the scheduler wrote are removed later
+func (s *SSHServiceImpl) AddKey(ctx context.Context, hostname string, port int, sshUser, privateKey, publicKey, marker string) error {
+	key, err := keyMaterial(publicKey)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(marker, keyMarkerPrefix) {
+		return fmt.Errorf("invalid key marker %q", marker)
+	}
 	client, session, err := s.connect(ctx, hostname, port, sshUser, privateKey)
 	if err != nil {
 		slog.Error("SSH connect failed for AddKey", "hostname", hostname, "port", port, "error", err)
 		return err
 	}
 	defer client.Close()
-	slog.Debug("SSH AddKey", "hostname", hostname, "port", port)
-
-	publicKey = strings.TrimSpace(publicKey)
-	if publicKey == "" {
-		return fmt.Errorf("empty public key")
-	}
+	slog.Debug("SSH AddKey", "hostname", hostname, "port", port, "marker", marker)
 
-	cmd := fmt.Sprintf("mkdir -p ~/.ssh && chmod 700 ~/.ssh && grep -qF '%s' ~/.ssh/authorized_keys || echo '%s' >> ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys",
-		strings.ReplaceAll(publicKey, "'", "'\"'\"'"),
-		strings.ReplaceAll(publicKey, "'", "'\"'\"'"),
+	line := strings.ReplaceAll(key+" "+marker, "'", "'\"'\"'")
+	cmd := fmt.Sprintf("mkdir -p ~/.ssh && chmod 700 ~/.ssh && touch ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys && (grep -qxF '%s' ~/.ssh/authorized_keys || echo '%s' >> ~/.ssh/authorized_keys)",
+		line, line,
 	)
 	var stdout, stderr bytes.Buffer
 	session.Stdout = &stdout
@@ -48,7 +56,17 @@ func (s *SSHServiceImpl) AddKey(ctx context.Context, hostname string, port int,
 	return nil
 }
 
-func (s *SSHServiceImpl) RemoveKey(ctx context.Context, hostname string, port int, sshUser, privateKey, publicKey string) error {
+// RemoveKey deletes the lines for publicKey tagged with exactly marker (see
+// KeyMarker) from authorized_keys. The same key added for another reservation,
+// or installed by the user themselves, is kept.
+func (s *SSHServiceImpl) RemoveKey(ctx context.Context, hostname string, port int, sshUser, privateKey, publicKey, marker string) error {
+	key, err := keyMaterial(publicKey)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(marker, keyMarkerPrefix) || strings.ContainsAny(marker, "' \t\n") {
+		return fmt.Errorf("invalid key marker %q", marker)
+	}
 	client, session, err := s.connect(ctx, hostname, port, sshUser, privateKey)
 	if err != nil {
 		slog.Error("SSH connect failed for RemoveKey", "hostname", hostname, "port", port, "error", err)
@@ -57,19 +75,129 @@ func (s *SSHServiceImpl) RemoveKey(ctx context.Context, hostname string, port in
 	defer client.Close()
 	slog.Debug("SSH RemoveKey", "hostname", hostname, "port", port)
 
-	publicKey = strings.TrimSpace(publicKey)
-	if publicKey == "" {
-		return fmt.Errorf("empty public key")
+	cmd := fmt.Sprintf("if [ -f ~/.ssh/authorized_keys ]; then awk -v k='%s' -v m='%s' 'index($0, k) && $NF == m { next } { print }' ~/.ssh/authorized_keys > ~/.ssh/authorized_keys.tmp && mv ~/.ssh/authorized_keys.tmp ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys; fi",
+		key, marker,
+	)
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(cmd); err != nil {
+		slog.Error("SSH RemoveKey run failed", "hostname", hostname, "error", err)
+		return fmt.Errorf("ssh remove key: %w: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// ListKeys returns the entries of the SSH user's ~/.ssh/authorized_keys,
+// skipping blank lines and comments. A missing file yields no keys.
+func (s *SSHServiceImpl) ListKeys(ctx context.Context, hostname string, port int, sshUser, privateKey string) ([]string, error) {
+	client, session, err := s.connect(ctx, hostname, port, sshUser, privateKey)
+	if err != nil {
+		slog.Error("SSH connect failed for ListKeys", "hostname", hostname, "port", port, "error", err)
+		return nil, err
 	}
+	defer client.Close()
+	slog.Debug("SSH ListKeys", "hostname", hostname, "port", port)
 
-	// Use grep -v to remove the line containing the key
-	escaped := strings.ReplaceAll(publicKey, "'", "'\"'\"'")
-	cmd := fmt.Sprintf("grep -vF '%s' ~/.ssh/authorized_keys > ~/.ssh/authorized_keys.tmp 2>/dev/null && mv ~/.ssh/authorized_keys.tmp ~/.ssh/authorized_keys || true", escaped)
 	var stdout, stderr bytes.Buffer
 	session.Stdout = &stdout
 	session.Stderr = &stderr
-	_ = session.Run(cmd)
-	return nil
+	if err := session.Run("if [ -f ~/.ssh/authorized_keys ]; then cat ~/.ssh/authorized_keys; fi"); err != nil {
+		return nil, fmt.Errorf("ssh list keys: %w: %s", err, stderr.String())
+	}
+	var keys []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, nil
+}
+
+// MessageSessions writes message to the terminals of the SSH user's live
+// sessions that logged in with one of publicKeys since the given time and
+// returns how many there are
+func (s *SSHServiceImpl) MessageSessions(ctx context.Context, hostname string, port int, sshUser, privateKey string, publicKeys []string, since time.Time, message string) (int, error) {
+	return s.sessions(ctx, hostname, port, sshUser, privateKey, publicKeys, since, message, false)
+}
+
+// TerminateSessions writes message to the SSH user's live sessions that logged
+// in with one of publicKeys since the given time, hangs them up and returns how
+// many were ended
+func (s *SSHServiceImpl) TerminateSessions(ctx context.Context, hostname string, port int, sshUser, privateKey string, publicKeys []string, since time.Time, message string) (int, error) {
+	return s.sessions(ctx, hostname, port, sshUser, privateKey, publicKeys, since, message, true)
+}
+
+// sessions finds the sessions through sshd's "Accepted publickey" log lines
+// since the given time, which name the key's fingerprint and the pid of the
+// session's privileged sshd process. The journal is searched from that time
+// only; the syslog files are read when it has nothing. A pid is only trusted
+// if its process started before the line was logged, since a later one reuses
+// the pid of a session that has ended. Its children owned by the SSH user are
+// the sessions; their children's terminals get the message. Reading the logs
+// usually needs root or the adm/systemd-journal group, without which no
+// session is found.
+func (s *SSHServiceImpl) sessions(ctx context.Context, hostname string, port int, sshUser, privateKey string, publicKeys []string, since time.Time, message string, terminate bool) (int, error) {
+	var patterns []string
+	for _, key := range publicKeys {
+		pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			return 0, fmt.Errorf("invalid public key: %w", err)
+		}
+		patterns = append(patterns, fmt.Sprintf("-e '%s '", ssh.FingerprintSHA256(pk)))
+	}
+	if len(patterns) == 0 {
+		return 0, nil
+	}
+	client, session, err := s.connect(ctx, hostname, port, sshUser, privateKey)
+	if err != nil {
+		slog.Error("SSH connect failed for sessions", "hostname", hostname, "port", port, "error", err)
+		return 0, err
+	}
+	defer client.Close()
+	slog.Debug("SSH sessions", "hostname", hostname, "port", port, "terminate", terminate)
+
+	hangup := ""
+	if terminate {
+		hangup = "kill -HUP $c 2>/dev/null; "
+	}
+	cmd := fmt.Sprintf(`u=$(id -un); uid=$(id -u); since=%d; now=$(date +%%s); n=0
+logs=$(journalctl -q --no-pager -o short-unix --since @$since _COMM=sshd _COMM=sshd-session 2>/dev/null | grep -F "Accepted publickey for $u from")
+if [ -z "$logs" ]; then
+  logs=$(cat /var/log/auth.log /var/log/secure 2>/dev/null | grep -F "Accepted publickey for $u from" | while read -r a b c rest; do
+    case $a in *T*) ts=$a ;; *) ts="$a $b $c" ;; esac
+    t=$(date -d "$ts" +%%s 2>/dev/null) || continue
+    [ "$t" -ge "$since" ] && echo "$t $a $b $c $rest"
+  done)
+fi
+set -- $(printf '%%s\n' "$logs" | sed 's/$/ /' | grep -F %s | sed -n 's/^\([0-9]*\)[^ ]* .*sshd[^[]*\[\([0-9]*\)\]: Accepted.*/\1 \2/p' | sort -u -k2,2)
+while [ $# -ge 2 ]; do
+  t=$1; p=$2; shift 2
+  case "$(ps -o comm= -p $p 2>/dev/null)" in sshd*) ;; *) continue ;; esac
+  e=$(ps -o etimes= -p $p 2>/dev/null | tr -d ' ')
+  [ -n "$e" ] && [ $((now - e)) -le $((t + 2)) ] || continue
+  for c in $(ps -o pid=,uid= --ppid $p 2>/dev/null | awk -v u=$uid '$2 == u { print $1 }'); do
+    for y in $(ps -o tty= --ppid $c 2>/dev/null | sort -u); do
+      [ "$y" != "?" ] && printf '\r\n%%s\r\n' '%s' > /dev/$y 2>/dev/null
+    done
+    %sn=$((n + 1))
+  done
+done
+echo $n`, since.Unix(), strings.Join(patterns, " "), strings.ReplaceAll(message, "'", "'\"'\"'"), hangup)
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(cmd); err != nil {
+		slog.Error("SSH sessions run failed", "hostname", hostname, "error", err)
+		return 0, fmt.Errorf("ssh sessions: %w: %s", err, stderr.String())
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return 0, fmt.Errorf("ssh sessions: unexpected output %q", stdout.String())
+	}
+	return n, nil
 }
 
 func (s *SSHServiceImpl) TestConnection(ctx context.Context, hostname string, port int, sshUser, privateKey string) error {
@@ -90,15 +218,13 @@ func (s *SSHServiceImpl) connect(ctx context.Context, hostname string, port int,
 	}
 
 	config := &ssh.ClientConfig{
-		User: sshUser,
-		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil // skip host key verification for simplicity
-		},
+		User:    sshUser,
+		Auth:    []ssh.AuthMethod{ssh.PublicKeys(signer)},
 		Timeout: 10 * time.Second,
 	}
+	addr := hostAddr(hostname, port)
+	config.HostKeyCallback = s.hostKeyCallback(ctx, addr)
 
-	addr := fmt.Sprintf("%s:%d", hostname, port)
 	conn, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
 		return nil, nil, fmt.Errorf("ssh dial: %w", err)
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+	"golang.org/x/crypto/ssh"
+)
+
+const sshKeyColumns = `id, user_id, name, public_key, fingerprint, key_type, last_used_at, created_at`
+
+// minRSABits is the smallest RSA modulus accepted for user keys
+const minRSABits = 2048
+
+// allowedKeyTypes are the user key types accepted besides RSA. DSA is too weak
+// and certificates are issued by the scheduler itself, not registered.
+var allowedKeyTypes = map[string]bool{
+	ssh.KeyAlgoED25519:    true,
+	ssh.KeyAlgoSKED25519:  true,
+	ssh.KeyAlgoECDSA256:   true,
+	ssh.KeyAlgoECDSA384:   true,
+	ssh.KeyAlgoECDSA521:   true,
+	ssh.KeyAlgoSKECDSA256: true,
+}
+
+// parseSSHKey parses a single public key in authorized_keys format, checks its
+// type and strength and returns it in canonical form ("type base64 comment")
+// with its SHA256 fingerprint and type
+func parseSSHKey(publicKey string) (canonical, fingerprint, keyType, comment string, err error) {
+	pk, comment, _, rest, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil || strings.TrimSpace(string(rest)) != "" {
+		return "", "", "", "", ErrInvalidSSHKey
+	}
+	if err := checkKeyStrength(pk); err != nil {
+		return "", "", "", "", err
+	}
+	canonical = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pk)))
+	if comment != "" {
+		canonical += " " + comment
+	}
+	return canonical, ssh.FingerprintSHA256(pk), pk.Type(), comment, nil
+}
+
+// checkKeyStrength returns ErrWeakSSHKey for DSA keys, RSA keys under
+// minRSABits and any type not in allowedKeyTypes
+func checkKeyStrength(pk ssh.PublicKey) error {
+	if pk.Type() != ssh.KeyAlgoRSA {
+		if !allowedKeyTypes[pk.Type()] {
+			return ErrWeakSSHKey
+		}
+		return nil
+	}
+	crypto, ok := pk.(ssh.CryptoPublicKey)
+	if !ok {
+		return ErrWeakSSHKey
+	}
+	rsaKey, ok := crypto.CryptoPublicKey().(*rsa.PublicKey)
+	if !ok || rsaKey.N.BitLen() < minRSABits {
+		return ErrWeakSSHKey
+	}
+	return nil
+}
+
+// insertSSHKey adds a key for userID. A key is registered to one user only;
+// the owner is looked up before inserting, so q should be a transaction for
+// concurrent registrations to be refused too. An empty name falls back to the
+// key's comment, then its type.
+func insertSSHKey(ctx context.Context, q querier, userID int64, name, publicKey string) (int64, error) {
+	canonical, fingerprint, keyType, comment, err := parseSSHKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = comment
+	}
+	if name == "" {
+		name = keyType
+	}
+	// Keys shared before ownership was enforced may have several owners;
+	// a user holding one of the copies is told they already have it
+	var owner int64
+	err = q.QueryRowContext(ctx, `SELECT user_id FROM user_ssh_keys WHERE fingerprint = ? ORDER BY user_id = ? DESC LIMIT 1`, fingerprint, userID).Scan(&owner)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return 0, err
+	case owner == userID:
+		return 0, ErrDuplicateSSHKey
+	default:
+		return 0, ErrSSHKeyInUse
+	}
+	res, err := q.ExecContext(ctx,
+		`INSERT INTO user_ssh_keys (user_id, name, public_key, fingerprint, key_type) VALUES (?, ?, ?, ?, ?)`,
+		userID, name, canonical, fingerprint, keyType,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// AddSSHKey registers another public key for a user
+func (s *UserServiceDB) AddSSHKey(ctx context.Context, userID int64, name, publicKey string) (*models.SSHKey, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	id, err := insertSSHKey(ctx, tx, userID, name, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	k, err := scanSSHKey(tx.QueryRowContext(ctx, `SELECT `+sshKeyColumns+` FROM user_ssh_keys WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return k, tx.Commit()
+}
+
+// ListSSHKeys returns a user's keys, oldest first
+func (s *UserServiceDB) ListSSHKeys(ctx context.Context, userID int64) ([]models.SSHKey, error) {
+	return s.querySSHKeys(ctx, `SELECT `+sshKeyColumns+` FROM user_ssh_keys WHERE user_id = ? ORDER BY id`, userID)
+}
+
+// ListAllSSHKeys returns every registered key
+func (s *UserServiceDB) ListAllSSHKeys(ctx context.Context) ([]models.SSHKey, error) {
+	return s.querySSHKeys(ctx, `SELECT `+sshKeyColumns+` FROM user_ssh_keys ORDER BY id`)
+}
+
+// DeleteSSHKey removes one of a user's keys and returns it so that access
+// granted with it can be revoked
+func (s *UserServiceDB) DeleteSSHKey(ctx context.Context, id, userID int64) (*models.SSHKey, error) {
+	k, err := scanSSHKey(s.db.QueryRowContext(ctx, `SELECT `+sshKeyColumns+` FROM user_ssh_keys WHERE id = ? AND user_id = ?`, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrSSHKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM user_ssh_keys WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// MarkSSHKeysUsed records that keys were installed or certified for a reservation
+func (s *UserServiceDB) MarkSSHKeysUsed(ctx context.Context, ids []int64) error {
+	now := time.Now().UTC()
+	for _, id := range ids {
+		if _, err := s.db.ExecContext(ctx, `UPDATE user_ssh_keys SET last_used_at = ? WHERE id = ?`, now, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateSSHKeys moves keys from the single users.ssh_public_key column of
+// older versions into user_ssh_keys. Keys that would not be accepted today are
+// left in place and logged. It returns the number of keys moved and the users
+// whose key was refused; they have no key until they register a new one.
+func (s *UserServiceDB) MigrateSSHKeys(ctx context.Context) (int, []string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, username, ssh_public_key FROM users WHERE COALESCE(ssh_public_key, '') != ''`)
+	if err != nil {
+		return 0, nil, err
+	}
+	type legacyKey struct {
+		userID    int64
+		username  string
+		publicKey string
+	}
+	var legacy []legacyKey
+	for rows.Next() {
+		var k legacyKey
+		if err := rows.Scan(&k.userID, &k.username, &k.publicKey); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		legacy = append(legacy, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	moved := 0
+	var refused []string
+	for _, k := range legacy {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return moved, refused, err
+		}
+		_, err = insertSSHKey(ctx, tx, k.userID, "", k.publicKey)
+		if err == ErrInvalidSSHKey || err == ErrWeakSSHKey || err == ErrSSHKeyInUse {
+			tx.Rollback()
+			slog.Warn("legacy SSH key not accepted, left in users.ssh_public_key", "user_id", k.userID, "username", k.username, "error", err)
+			refused = append(refused, k.username)
+			continue
+		}
+		if err != nil && err != ErrDuplicateSSHKey {
+			tx.Rollback()
+			return moved, refused, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET ssh_public_key = NULL WHERE id = ?`, k.userID); err != nil {
+			tx.Rollback()
+			return moved, refused, err
+		}
+		if err := tx.Commit(); err != nil {
+			return moved, refused, err
+		}
+		moved++
+	}
+	return moved, refused, nil
+}
+
+func (s *UserServiceDB) querySSHKeys(ctx context.Context, query string, args ...interface{}) ([]models.SSHKey, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []models.SSHKey
+	for rows.Next() {
+		k, err := scanSSHKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *k)
+	}
+	return list, rows.Err()
+}
+
+func scanSSHKey(row rowScanner) (*models.SSHKey, error) {
+	var k models.SSHKey
+	var lastUsedAt sql.NullTime
+	if err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.PublicKey, &k.Fingerprint, &k.KeyType, &lastUsedAt, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	k.LastUsedAt = nullTimePtr(lastUsedAt)
+	return &k, nil
+}
+
+// SelectedKeys returns the keys a reservation grants access with: the ones
+// chosen for it, or all of the user's keys when none were chosen
+func SelectedKeys(r *models.Reservation, keys []models.SSHKey) []models.SSHKey {
+	if len(r.SSHKeyIDs) == 0 {
+		return keys
+	}
+	chosen := make(map[int64]bool, len(r.SSHKeyIDs))
+	for _, id := range r.SSHKeyIDs {
+		chosen[id] = true
+	}
+	var selected []models.SSHKey
+	for _, k := range keys {
+		if chosen[k.ID] {
+			selected = append(selected, k)
+		}
+	}
+	return selected
+}
+
+// publicKeys returns the public keys of keys
+func publicKeys(keys []models.SSHKey) []string {
+	list := make([]string, len(keys))
+	for i, k := range keys {
+		list[i] = k.PublicKey
+	}
+	return list
+}
+
+// sshKeyIDs returns the IDs of keys
+func sshKeyIDs(keys []models.SSHKey) []int64 {
+	ids := make([]int64, len(keys))
+	for i, k := range keys {
+		ids[i] = k.ID
+	}
+	return ids
+}
+
+var ErrInvalidSSHKey = &userError{msg: "invalid SSH public key"}
+var ErrWeakSSHKey = &userError{msg: "SSH key type not allowed: use ed25519, ECDSA or RSA of at least 2048 bits"}
+var ErrDuplicateSSHKey = &userError{msg: "you already registered this SSH key"}
+var ErrSSHKeyInUse = &userError{msg: "this SSH key is registered to another user"}
+var ErrSSHKeyNotFound = &userError{msg: "SSH key not found"}
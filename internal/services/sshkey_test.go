@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMigrateSSHKeys(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	users := NewUserService(db).(*UserServiceDB)
+	shared := testPublicKey(t, "shared")
+	own := testPublicKey(t, "own")
+	legacy := []struct {
+		user      string
+		key       string
+		wantMoved bool
+	}{
+		{user: "alice", key: testPublicKey(t, "laptop"), wantMoved: true},
+		{user: "bob", key: "ssh-rsa not-a-key"},
+		{user: "carol", key: shared},
+		{user: "dave", key: own, wantMoved: true},
+	}
+	for _, k := range legacy {
+		id := addTestUser(t, db, k.user)
+		if _, err := db.Exec(`UPDATE users SET ssh_public_key = ? WHERE id = ?`, k.key, id); err != nil {
+			t.Fatal(err)
+		}
+		if k.key == own {
+			// already registered again by its owner
+			if _, err := users.AddSSHKey(ctx, id, "", own); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := users.AddSSHKey(ctx, addTestUser(t, db, "erin"), "", shared); err != nil {
+		t.Fatal(err)
+	}
+
+	n, refused, err := users.MigrateSSHKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("moved %d keys, want 2", n)
+	}
+	if want := []string{"bob", "carol"}; !reflect.DeepEqual(refused, want) {
+		t.Errorf("refused %v, want %v", refused, want)
+	}
+	for _, k := range legacy {
+		var left string
+		if err := db.QueryRow(`SELECT COALESCE(ssh_public_key, '') FROM users WHERE username = ?`, k.user).Scan(&left); err != nil {
+			t.Fatal(err)
+		}
+		if moved := left == ""; moved != k.wantMoved {
+			t.Errorf("%s: legacy key moved = %v, want %v", k.user, moved, k.wantMoved)
+		}
+	}
+}
+
+func TestAddSSHKeyOwner(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	users := NewUserService(db).(*UserServiceDB)
+	alice := addTestUser(t, db, "alice")
+	bob := addTestUser(t, db, "bob")
+	laptop := testPublicKey(t, "laptop")
+	if _, err := users.AddSSHKey(ctx, alice, "", laptop); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		userID  int64
+		key     string
+		wantErr error
+	}{
+		{name: "own key again", userID: alice, key: laptop, wantErr: ErrDuplicateSSHKey},
+		{name: "another user's key", userID: bob, key: laptop, wantErr: ErrSSHKeyInUse},
+		{name: "new key", userID: bob, key: testPublicKey(t, "desktop")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := users.AddSSHKey(ctx, tt.userID, "", tt.key)
+			if err != tt.wantErr {
+				t.Errorf("AddSSHKey = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
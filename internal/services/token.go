@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// tokenPrefix marks scheduler-issued tokens so they are recognizable in logs and secret scanners
+const tokenPrefix = "ssk_"
+
+// APITokenServiceDB implements APITokenService
+type APITokenServiceDB struct {
+	db *sql.DB
+}
+
+// NewAPITokenService creates an APITokenService
+func NewAPITokenService(db *sql.DB) APITokenService {
+	return &APITokenServiceDB{db: db}
+}
+
+// Create issues a new token for userID and returns its plaintext value, which is shown only once
+func (s *APITokenServiceDB) Create(ctx context.Context, userID int64, name string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, ErrTokenNameRequired
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	prefix := token[:len(tokenPrefix)+6]
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO api_tokens (user_id, name, token_hash, prefix, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		userID, name, hashToken(token), prefix, expiresAt,
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	id, _ := res.LastInsertId()
+	t, err := s.get(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, t, nil
+}
+
+func (s *APITokenServiceDB) get(ctx context.Context, id int64) (*models.APIToken, error) {
+	var t models.APIToken
+	var expiresAt, lastUsedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, user_id, name, prefix, expires_at, last_used_at, created_at FROM api_tokens WHERE id = ?`,
+		id,
+	).Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &expiresAt, &lastUsedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.ExpiresAt = nullTimePtr(expiresAt)
+	t.LastUsedAt = nullTimePtr(lastUsedAt)
+	return &t, nil
+}
+
+func (s *APITokenServiceDB) List(ctx context.Context, userID int64) ([]models.APIToken, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, user_id, name, prefix, expires_at, last_used_at, created_at FROM api_tokens
+		 WHERE user_id = ? ORDER BY created_at DESC, id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []models.APIToken
+	for rows.Next() {
+		var t models.APIToken
+		var expiresAt, lastUsedAt sql.NullTime
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &expiresAt, &lastUsedAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.ExpiresAt = nullTimePtr(expiresAt)
+		t.LastUsedAt = nullTimePtr(lastUsedAt)
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (s *APITokenServiceDB) Revoke(ctx context.Context, id, userID int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves the owner of a plaintext token and records its use
+func (s *APITokenServiceDB) Authenticate(ctx context.Context, token string) (*models.User, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	var tokenID int64
+	var expiresAt sql.NullTime
+	var u models.User
+	err := s.db.QueryRowContext(ctx,
+		`SELECT t.id, t.expires_at, u.id, u.username, u.password_hash, u.role, u.created_at
+		 FROM api_tokens t
+		 JOIN users u ON t.user_id = u.id
+		 WHERE t.token_hash = ?`,
+		hashToken(token),
+	).Scan(&tokenID, &expiresAt, &u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if expiresAt.Valid && !now.Before(expiresAt.Time) {
+		return nil, ErrInvalidToken
+	}
+	if _, err := s.db.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, now, tokenID); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	v := t.Time
+	return &v
+}
+
+var ErrInvalidToken = &tokenError{msg: "invalid or expired API token"}
+var ErrTokenNotFound = &tokenError{msg: "API token not found"}
+var ErrTokenNameRequired = &tokenError{msg: "token name required"}
+
+type tokenError struct{ msg string }
+
+func (e *tokenError) Error() string { return e.msg }
@@ -21,9 +21,9 @@ func NewUserService(db *sql.DB) UserService {
 func (s *UserServiceDB) GetByUsername(ctx context.Context, username string) (*models.User, error) {
 	var u models.User
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, username, password_hash, role, COALESCE(ssh_public_key,''), created_at FROM users WHERE username = ?`,
+		`SELECT id, username, password_hash, role, created_at FROM users WHERE username = ?`,
 		username,
-	).Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.SSHPublicKey, &u.CreatedAt)
+	).Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -36,9 +36,9 @@ func (s *UserServiceDB) GetByUsername(ctx context.Context, username string) (*mo
 func (s *UserServiceDB) GetByID(ctx context.Context, id int64) (*models.User, error) {
 	var u models.User
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, username, password_hash, role, COALESCE(ssh_public_key,''), created_at FROM users WHERE id = ?`,
+		`SELECT id, username, password_hash, role, created_at FROM users WHERE id = ?`,
 		id,
-	).Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.SSHPublicKey, &u.CreatedAt)
+	).Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -64,31 +64,37 @@ func (s *UserServiceDB) Create(ctx context.Context, username, password string, r
 	return s.GetByID(ctx, id)
 }
 
-// CreateWithSSHKey creates a user with role=user, password, and SSH public key
+// CreateWithSSHKey creates a user with role=user, password, and SSH public key.
+// The key is optional; an invalid one fails the whole creation.
 func (s *UserServiceDB) CreateWithSSHKey(ctx context.Context, username, password, sshPublicKey string) (*models.User, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, err
 	}
-	res, err := s.db.ExecContext(ctx,
-		`INSERT INTO users (username, password_hash, role, ssh_public_key) VALUES (?, ?, 'user', ?)`,
-		username, string(hash), sshPublicKey,
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO users (username, password_hash, role) VALUES (?, ?, 'user')`,
+		username, string(hash),
 	)
 	if err != nil {
 		return nil, err
 	}
 	id, _ := res.LastInsertId()
+	if sshPublicKey != "" {
+		if _, err := insertSSHKey(ctx, tx, id, "", sshPublicKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return s.GetByID(ctx, id)
 }
 
-func (s *UserServiceDB) UpdateSSHKey(ctx context.Context, username, sshPublicKey string) error {
-	_, err := s.db.ExecContext(ctx,
-		`UPDATE users SET ssh_public_key = ? WHERE username = ?`,
-		sshPublicKey, username,
-	)
-	return err
-}
-
 func (s *UserServiceDB) List(ctx context.Context) ([]models.UserPublic, error) {
 	rows, err := s.db.QueryContext(ctx,
 		`SELECT id, username, role, created_at FROM users ORDER BY username`,
@@ -108,8 +114,24 @@ func (s *UserServiceDB) List(ctx context.Context) ([]models.UserPublic, error) {
 	return list, rows.Err()
 }
 
+// Delete removes the user with their tokens and keys and releases the
+// servers they own, all in one transaction
 func (s *UserServiceDB) Delete(ctx context.Context, id int64) error {
-	res, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM api_tokens WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_ssh_keys WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE servers SET owner_id = NULL WHERE owner_id = ?`, id); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id)
 	if err != nil {
 		return err
 	}
@@ -117,7 +139,7 @@ func (s *UserServiceDB) Delete(ctx context.Context, id int64) error {
 	if n == 0 {
 		return ErrUserNotFound
 	}
-	return nil
+	return tx.Commit()
 }
 
 var ErrUserNotFound = &userError{msg: "user not found"}
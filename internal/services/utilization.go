@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// Utilization reports booked versus used hours per server within [from, to).
+// Reservations that never became active (pending, or cancelled before start)
+// are not counted as booked. Windows are clipped to the period, and the used
+// time of a still-active reservation runs until now.
+func (s *ReservationServiceDB) Utilization(ctx context.Context, from, to time.Time) ([]models.ServerUtilization, error) {
+	from, to = from.UTC(), to.UTC()
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+reservationColumns+`, s.name
+		 FROM reservations r
+		 JOIN servers s ON r.server_id = s.id
+		 WHERE (r.status IN ('active','expired','released') OR (r.status = 'cancelled' AND r.actual_end_time IS NOT NULL))
+		 AND r.start_time < ? AND r.end_time > ?`,
+		to, from,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	byServer := make(map[int64]*models.ServerUtilization)
+	for rows.Next() {
+		var serverName string
+		r, err := scanReservation(rows, &serverName)
+		if err != nil {
+			return nil, err
+		}
+		u, ok := byServer[r.ServerID]
+		if !ok {
+			u = &models.ServerUtilization{ServerID: r.ServerID, ServerName: serverName}
+			byServer[r.ServerID] = u
+		}
+		u.Reservations++
+		if r.Status == "released" {
+			u.Released++
+		}
+		usedEnd := r.EndTime
+		switch {
+		case r.ActualEndTime != nil:
+			usedEnd = *r.ActualEndTime
+		case r.Status == "active" && now.Before(usedEnd):
+			usedEnd = now
+		}
+		u.BookedHours += overlapHours(r.StartTime, r.EndTime, from, to)
+		u.UsedHours += overlapHours(r.StartTime, usedEnd, from, to)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := make([]models.ServerUtilization, 0, len(byServer))
+	for _, u := range byServer {
+		if u.BookedHours > 0 {
+			u.UsedPercent = math.Round(u.UsedHours/u.BookedHours*1000) / 10
+		}
+		u.BookedHours = math.Round(u.BookedHours*100) / 100
+		u.UsedHours = math.Round(u.UsedHours*100) / 100
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ServerName < list[j].ServerName })
+	return list, nil
+}
+
+// overlapHours returns the length of [start, end) clipped to [from, to) in hours
+func overlapHours(start, end, from, to time.Time) float64 {
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start).Hours()
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+// WaitlistServiceDB implements WaitlistService
+type WaitlistServiceDB struct {
+	db          *sql.DB
+	reservation ReservationService
+	slack       SlackService
+}
+
+// NewWaitlistService creates a WaitlistService
+func NewWaitlistService(db *sql.DB, res ReservationService, slack SlackService) WaitlistService {
+	return &WaitlistServiceDB{db: db, reservation: res, slack: slack}
+}
+
+func (s *WaitlistServiceDB) Join(ctx context.Context, userID, serverID int64, start, end time.Time) (*models.WaitlistEntry, error) {
+	if !end.After(start) || !end.After(time.Now().UTC()) {
+		return nil, ErrInvalidWaitlistWindow
+	}
+	var decommissioned bool
+	err := s.db.QueryRowContext(ctx, `SELECT decommissioned_at IS NOT NULL FROM servers WHERE id = ?`, serverID).Scan(&decommissioned)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if decommissioned {
+		return nil, ErrServerDecommissioned
+	}
+	var count int
+	err = s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM waitlist WHERE user_id = ? AND server_id = ? AND status = 'waiting'
+		 AND start_time < ? AND end_time > ?`,
+		userID, serverID, end, start,
+	).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrAlreadyWaiting
+	}
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO waitlist (user_id, server_id, start_time, end_time, status) VALUES (?, ?, ?, ?, 'waiting')`,
+		userID, serverID, start.UTC(), end.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return s.Get(ctx, id)
+}
+
+func (s *WaitlistServiceDB) Get(ctx context.Context, id int64) (*models.WaitlistEntry, error) {
+	w, err := scanWaitlistEntry(s.db.QueryRowContext(ctx,
+		`SELECT `+waitlistColumns+` FROM waitlist w WHERE w.id = ?`,
+		id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (s *WaitlistServiceDB) List(ctx context.Context, userID *int64) ([]models.WaitlistEntryWithDetails, error) {
+	query := `SELECT ` + waitlistColumns + `, s.name, u.username
+		 FROM waitlist w
+		 JOIN servers s ON w.server_id = s.id
+		 JOIN users u ON w.user_id = u.id`
+	var args []interface{}
+	if userID != nil {
+		query += ` WHERE w.user_id = ?`
+		args = append(args, *userID)
+	}
+	query += ` ORDER BY w.created_at DESC, w.id DESC`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []models.WaitlistEntryWithDetails
+	for rows.Next() {
+		var w models.WaitlistEntryWithDetails
+		entry, err := scanWaitlistEntry(rows, &w.ServerName, &w.Username)
+		if err != nil {
+			return nil, err
+		}
+		w.WaitlistEntry = entry
+		list = append(list, w)
+	}
+	return list, rows.Err()
+}
+
+func (s *WaitlistServiceDB) Cancel(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE waitlist SET status = 'cancelled' WHERE id = ? AND status = 'waiting'`, id)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return ErrWaitlistNotFound
+	}
+	return nil
+}
+
+// Promote walks the server's waitlist in join order and books every waiter whose
+// window is now free. Entries whose window has already ended are expired; a window
+// that has already started is booked from now. On servers that require approval
+// the booking is a request, and the Slack notice says so.
+func (s *WaitlistServiceDB) Promote(ctx context.Context, serverID int64) ([]models.WaitlistEntry, error) {
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE waitlist SET status = 'expired' WHERE server_id = ? AND status = 'waiting' AND end_time <= ?`,
+		serverID, now,
+	); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+waitlistColumns+` FROM waitlist w WHERE w.server_id = ? AND w.status = 'waiting' ORDER BY w.created_at, w.id`,
+		serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var waiting []models.WaitlistEntry
+	for rows.Next() {
+		w, err := scanWaitlistEntry(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		waiting = append(waiting, w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var promoted []models.WaitlistEntry
+	for _, w := range waiting {
+		start := w.StartTime
+		if start.Before(now) {
+			start = now
+		}
+		r, err := s.reservation.Create(ctx, w.UserID, w.ServerID, start, w.EndTime)
+		if errors.Is(err, ErrOverlap) {
+			continue
+		}
+		var violation *PolicyViolationError
+		if errors.As(err, &violation) {
+			// The waiter can no longer book this window (e.g. weekly hours used up)
+			slog.Info("waitlist entry skipped by booking policy", "waitlist_id", w.ID, "rule", violation.Rule)
+			continue
+		}
+		if err != nil {
+			return promoted, err
+		}
+		res, err := s.db.ExecContext(ctx,
+			`UPDATE waitlist SET status = 'promoted', reservation_id = ? WHERE id = ? AND status = 'waiting'`,
+			r.ID, w.ID,
+		)
+		if err != nil {
+			return promoted, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			// Cancelled by the user while we were booking; give the slot back
+			if err := s.reservation.CancelByAdmin(ctx, r.ID); err != nil {
+				return promoted, err
+			}
+			continue
+		}
+		w.Status = "promoted"
+		w.ReservationID = &r.ID
+		promoted = append(promoted, w)
+		slog.Info("waitlist entry promoted", "waitlist_id", w.ID, "reservation_id", r.ID, "user_id", w.UserID, "server_id", w.ServerID)
+		s.notifyPromoted(ctx, w, r)
+	}
+	return promoted, nil
+}
+
+func (s *WaitlistServiceDB) notifyPromoted(ctx context.Context, w models.WaitlistEntry, r *models.Reservation) {
+	var username, serverName string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT u.username, s.name FROM users u, servers s WHERE u.id = ? AND s.id = ?`,
+		w.UserID, w.ServerID,
+	).Scan(&username, &serverName)
+	if err != nil {
+		slog.Warn("waitlist notify lookup failed", "waitlist_id", w.ID, "error", err)
+		return
+	}
+	window := r.StartTime.UTC().Format(time.RFC3339) + " to " + r.EndTime.UTC().Format(time.RFC3339)
+	msg := fmt.Sprintf("Waitlist: %s got a reservation on %s (%s)", username, serverName, window)
+	if r.Status == "requested" {
+		// Restricted servers book the slot as a request that still needs approval
+		msg = fmt.Sprintf("Waitlist: %s's request for %s (%s) is now waiting for approval", username, serverName, window)
+	}
+	if err := s.slack.Notify(ctx, msg); err != nil {
+		slog.Warn("slack notify failed", "waitlist_id", w.ID, "error", err)
+	}
+}
+
+// waitlistColumns is the column list read by scanWaitlistEntry; queries alias waitlist as w
+const waitlistColumns = `w.id, w.user_id, w.server_id, w.start_time, w.end_time, w.status, w.reservation_id, w.created_at`
+
+func scanWaitlistEntry(row rowScanner, extra ...interface{}) (models.WaitlistEntry, error) {
+	var w models.WaitlistEntry
+	var reservationID sql.NullInt64
+	dest := append([]interface{}{&w.ID, &w.UserID, &w.ServerID, &w.StartTime, &w.EndTime, &w.Status, &reservationID, &w.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return w, err
+	}
+	if reservationID.Valid {
+		w.ReservationID = &reservationID.Int64
+	}
+	return w, nil
+}
+
+var ErrAlreadyWaiting = &waitlistError{msg: "already on the waitlist for this time"}
+var ErrWaitlistNotFound = &waitlistError{msg: "waitlist entry not found"}
+var ErrInvalidWaitlistWindow = &waitlistError{msg: "end time must be after the start and in the future"}
+
+type waitlistError struct{ msg string }
+
+func (e *waitlistError) Error() string { return e.msg }
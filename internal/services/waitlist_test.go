@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rusik69/serverscheduler/internal/models"
+)
+
+func TestPromote(t *testing.T) {
+	type window struct{ start, end time.Duration } // offsets from now
+	type waiter struct {
+		user string
+		window
+	}
+	later := window{2 * time.Hour, 3 * time.Hour}
+	tests := []struct {
+		name         string
+		held         []window // booked by another user
+		policy       *models.BookingPolicy
+		approval     bool     // the server requires approval
+		waiters      []waiter // in join order
+		wantPromoted []string
+		wantStatus   []string // per waiter
+	}{
+		{
+			name:         "free window is booked",
+			waiters:      []waiter{{"bob", later}},
+			wantPromoted: []string{"bob"},
+			wantStatus:   []string{"promoted"},
+		},
+		{
+			name:       "busy window keeps waiting",
+			held:       []window{{90 * time.Minute, 150 * time.Minute}},
+			waiters:    []waiter{{"bob", later}},
+			wantStatus: []string{"waiting"},
+		},
+		{
+			name:         "first in line wins",
+			waiters:      []waiter{{"bob", later}, {"carol", later}},
+			wantPromoted: []string{"bob"},
+			wantStatus:   []string{"promoted", "waiting"},
+		},
+		{
+			name:         "later waiter for another window is booked too",
+			held:         []window{{90 * time.Minute, 150 * time.Minute}},
+			waiters:      []waiter{{"bob", later}, {"carol", window{4 * time.Hour, 5 * time.Hour}}},
+			wantPromoted: []string{"carol"},
+			wantStatus:   []string{"waiting", "promoted"},
+		},
+		{
+			name:       "ended window expires",
+			waiters:    []waiter{{"bob", window{-2 * time.Hour, -time.Hour}}},
+			wantStatus: []string{"expired"},
+		},
+		{
+			name:         "started window is booked from now",
+			waiters:      []waiter{{"bob", window{-time.Hour, time.Hour}}},
+			wantPromoted: []string{"bob"},
+			wantStatus:   []string{"promoted"},
+		},
+		{
+			name:         "restricted server books a request",
+			approval:     true,
+			waiters:      []waiter{{"bob", later}},
+			wantPromoted: []string{"bob"},
+			wantStatus:   []string{"promoted"},
+		},
+		{
+			name:       "policy violation keeps waiting",
+			policy:     &models.BookingPolicy{MaxDurationMinutes: 30},
+			waiters:    []waiter{{"bob", later}},
+			wantStatus: []string{"waiting"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			ctx := context.Background()
+			srv, err := NewServerService(db, nil).Create(ctx, &models.Server{Name: "lab", Hostname: "127.0.0.1", Port: 22, SSHUser: "root"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := NewReservationService(db, NewSlackService(""))
+			waitlist := NewWaitlistService(db, res, NewSlackService(""))
+			now := time.Now().UTC().Truncate(time.Minute)
+
+			holder := addTestUser(t, db, "alice")
+			for _, w := range tt.held {
+				if _, err := res.Create(ctx, holder, srv.ID, now.Add(w.start), now.Add(w.end)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.approval {
+				if _, err := db.Exec(`UPDATE servers SET requires_approval = 1 WHERE id = ?`, srv.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.policy != nil {
+				if _, err := NewPolicyService(db).Save(ctx, tt.policy); err != nil {
+					t.Fatal(err)
+				}
+			}
+			users := map[int64]string{}
+			var entries []int64
+			for _, w := range tt.waiters {
+				id := addTestUser(t, db, w.user)
+				users[id] = w.user
+				if w.end <= 0 {
+					// Join refuses ended windows, so this one ran out while waiting
+					res, err := db.Exec(`INSERT INTO waitlist (user_id, server_id, start_time, end_time, status) VALUES (?, ?, ?, ?, 'waiting')`,
+						id, srv.ID, now.Add(w.start), now.Add(w.end))
+					if err != nil {
+						t.Fatal(err)
+					}
+					entryID, _ := res.LastInsertId()
+					entries = append(entries, entryID)
+					continue
+				}
+				entry, err := waitlist.Join(ctx, id, srv.ID, now.Add(w.start), now.Add(w.end))
+				if err != nil {
+					t.Fatal(err)
+				}
+				entries = append(entries, entry.ID)
+			}
+
+			before := time.Now().UTC()
+			promoted, err := waitlist.Promote(ctx, srv.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, w := range promoted {
+				got = append(got, users[w.UserID])
+				r, err := res.Get(ctx, *w.ReservationID)
+				if err != nil || r == nil {
+					t.Fatalf("reservation of %s: %v", users[w.UserID], err)
+				}
+				if r.UserID != w.UserID || !r.EndTime.Equal(w.EndTime) {
+					t.Errorf("reservation %+v does not match waitlist entry %+v", r, w)
+				}
+				wantStatus := "pending"
+				if tt.approval {
+					wantStatus = "requested"
+				}
+				if r.Status != wantStatus {
+					t.Errorf("reservation of %s is %s, want %s", users[w.UserID], r.Status, wantStatus)
+				}
+				if r.StartTime.Before(before.Truncate(time.Second)) {
+					t.Errorf("reservation of %s starts at %s, in the past", users[w.UserID], r.StartTime)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantPromoted) {
+				t.Errorf("promoted %v, want %v", got, tt.wantPromoted)
+			}
+			for i, id := range entries {
+				entry, err := waitlist.Get(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if entry.Status != tt.wantStatus[i] {
+					t.Errorf("%s: status %s, want %s", tt.waiters[i].user, entry.Status, tt.wantStatus[i])
+				}
+			}
+		})
+	}
+}
+
+func TestJoinWindow(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	srv, err := NewServerService(db, nil).Create(ctx, &models.Server{Name: "lab", Hostname: "127.0.0.1", Port: 22, SSHUser: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitlist := NewWaitlistService(db, NewReservationService(db, NewSlackService("")), NewSlackService(""))
+	userID := addTestUser(t, db, "bob")
+	now := time.Now().UTC()
+	tests := []struct {
+		name       string
+		start, end time.Time
+		wantErr    error
+	}{
+		{name: "future window", start: now.Add(time.Hour), end: now.Add(2 * time.Hour)},
+		{name: "started window", start: now.Add(-time.Hour), end: now.Add(30 * time.Minute)},
+		{name: "end before start", start: now.Add(5 * time.Hour), end: now.Add(4 * time.Hour), wantErr: ErrInvalidWaitlistWindow},
+		{name: "empty window", start: now.Add(5 * time.Hour), end: now.Add(5 * time.Hour), wantErr: ErrInvalidWaitlistWindow},
+		{name: "ended window", start: now.Add(-2 * time.Hour), end: now.Add(-time.Hour), wantErr: ErrInvalidWaitlistWindow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := waitlist.Join(ctx, userID, srv.ID, tt.start, tt.end)
+			if err != tt.wantErr {
+				t.Errorf("Join = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}